@@ -29,6 +29,7 @@ import "os"
 import "os/exec"
 import "runtime"
 
+import "github.com/milochristiansen/lua/ast"
 import "github.com/milochristiansen/lua/luautil"
 
 // Stack
@@ -898,6 +899,12 @@ func (l *State) asFunc(proto *funcProto, env *table) *function {
 		f.up[0].val = env
 	}
 
+	if l.debugger != nil {
+		l.debugger.loaded(&f.proto)
+	}
+	if l.coverage != nil {
+		l.coverage.loaded(&f.proto)
+	}
 	return f
 }
 
@@ -929,12 +936,49 @@ func (l *State) LoadBinary(in io.Reader, name string, env int) error {
 //
 // This version uses my own compiler. This compiler does not produce code identical to the standard Lua
 // compiler for all syntax constructs, sometimes it is a little worse, rarely a little better.
-func (l *State) LoadText(in io.Reader, name string, env int) error {
+//
+// The optional parser options enable language extensions (such as "continue") for this chunk only.
+// If more than one set of options is given only the first is used.
+func (l *State) LoadText(in io.Reader, name string, env int, opts ...ast.ParseOptions) error {
 	source, err := ioutil.ReadAll(in)
 	if err != nil {
 		return err
 	}
-	proto, err := compSource(string(source), name, 1)
+	o := ast.ParseOptions{}
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	proto, err := compSource(string(source), name, 1, o)
+	if err != nil {
+		return err
+	}
+
+	envv := l.global
+	if env != 0 {
+		ok := false
+		envv, ok = l.get(env).(*table)
+		if !ok {
+			return luautil.Error{Msg: "Value used as environment is not a table.", Type: luautil.ErrTypGenRuntime}
+		}
+	}
+
+	l.stack.Push(l.asFunc(proto, envv))
+	return nil
+}
+
+// LoadAST compiles an AST into a function and pushes the result onto the stack. The AST is treated as the main
+// block of a chunk, so it is a variadic function with no parameters.
+// If there is an error it is returned and nothing is pushed.
+// Set env to 0 to use the default environment.
+//
+// The AST is checked with ast.Validate before it is compiled, if there are problems the returned error
+// is an ast.ValidationErrors.
+func (l *State) LoadAST(block []ast.Stmt, name string, env int) error {
+	err := ast.Validate(block)
+	if err != nil {
+		return err
+	}
+	proto, err := compAST(block, name)
 	if err != nil {
 		return err
 	}
@@ -1072,8 +1116,9 @@ func (l *State) Recover(onStk int, trace bool) func(*error) {
 					frame := l.stack.frames[i]
 					if frame.fn.native == nil {
 						sources = append(sources, frame.fn.proto.source)
-						if int(frame.pc) < len(frame.fn.proto.lineInfo) {
-							lines = append(lines, frame.fn.proto.lineInfo[frame.pc])
+						// pc is the next instruction, the error happened in the one before it.
+						if pc := int(frame.pc) - 1; pc >= 0 && pc < len(frame.fn.proto.lineInfo) && frame.fn.proto.lineInfo[pc] > 0 {
+							lines = append(lines, frame.fn.proto.lineInfo[pc])
 						} else if len(frame.fn.proto.lineInfo) > 0 {
 							lines = append(lines, frame.fn.proto.lineInfo[len(frame.fn.proto.lineInfo)-1])
 						} else {
@@ -22,30 +22,71 @@ misrepresented as being the original software.
 
 package ast
 
-//import "fmt"
+import "fmt"
 
 // Lots of unexported stuff to prevent generation and insertion of invalid/unexpected Node types.
 // If you want to use this with a different Lua version it would probably be better to make a copy
 // and add what you need directly instead of trying to inject what you need.
 
-// I have been told these types format well as JSON, but AFAIK this will strip all line information.
-// Sorry, I never considered marshaling to text when I designed this...
-// It may be possible to change nodeBase to fix this somehow.
+// These types can be encoded with encoding/json, but the result cannot be decoded again. Use MarshalJSON
+// and UnmarshalJSON if you need to read the AST back in.
+
+// Position is a location in the source code.
+type Position struct {
+	Line   int
+	Col    int // Counted in Unicode code points, the first char in a line is column 1.
+	Offset int // Counted in bytes from the beginning of the source.
+}
+
+// String formats a Position as "line:column".
+func (p Position) String() string {
+	return fmt.Sprintf("%v:%v", p.Line, p.Col)
+}
+
+// Span is a range of source code. End is the position just after the last char in the range.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// String formats a Span as "line:column-line:column".
+func (s Span) String() string {
+	return s.Start.String() + "-" + s.End.String()
+}
 
 // Node represents an item in the AST.
 type Node interface {
 	nodeMark()
+
+	// Line returns the line that is used for debug info when this Node is compiled. This is not always the
+	// first line of the Node, for example Operators use the line of the operator itself.
 	Line() int
 	setLine(l int)
+
+	// Span returns the range of source code this Node was parsed from, including all child Nodes.
+	Span() Span
+	setSpan(s Span)
+
+	// Comments returns the comments attached to this Node, or nil if there are none. Only statements have
+	// comments, and only if they were requested when parsing.
+	Comments() *Comments
+	setComments(c *Comments)
 }
 
 type nodeBase struct {
 	Ln int
+	Sp Span
+	Cm *Comments `json:",omitempty"`
 }
 
-func (n *nodeBase) nodeMark()     {}
-func (n *nodeBase) Line() int     { return n.Ln }
-func (n *nodeBase) setLine(l int) { n.Ln = l }
+func (n *nodeBase) nodeMark()      {}
+func (n *nodeBase) Line() int      { return n.Ln }
+func (n *nodeBase) setLine(l int)  { n.Ln = l }
+func (n *nodeBase) Span() Span     { return n.Sp }
+func (n *nodeBase) setSpan(s Span) { n.Sp = s }
+
+func (n *nodeBase) Comments() *Comments     { return n.Cm }
+func (n *nodeBase) setComments(c *Comments) { n.Cm = c }
 
 // Stmt represents a statement Node.
 type Stmt interface {
@@ -186,7 +227,9 @@ func Walk(v Visitor, n Node) {
 			Walk(v, nnn)
 		}
 	case *Operator:
-		Walk(v, nn.Left)
+		if nn.Left != nil {
+			Walk(v, nn.Left)
+		}
 		Walk(v, nn.Right)
 	case *FuncCall:
 		if nn.Receiver != nil {
@@ -201,10 +244,10 @@ func Walk(v Visitor, n Node) {
 			Walk(v, nnn)
 		}
 	case *TableConstructor:
-		for _, nnn := range nn.Keys {
-			Walk(v, nnn)
-		}
-		for _, nnn := range nn.Vals {
+		for i, nnn := range nn.Vals {
+			if nn.Keys[i] != nil {
+				Walk(v, nn.Keys[i])
+			}
 			Walk(v, nnn)
 		}
 	case *TableAccessor:
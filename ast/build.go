@@ -0,0 +1,284 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "math"
+import "strconv"
+import "strings"
+
+// Constructors for building an AST from code, without going through Lua source.
+//
+// Each constructor checks its arguments and panics with a *ValidationError if there is a problem (a nil
+// child, an invalid name, assigning to something that is not a variable, etc). Problems that depend on
+// where the Node ends up in the tree (such as break outside of a loop) cannot be found until the tree is
+// finished, use Validate for that (State.LoadAST does this for you).
+//
+// The new Nodes have no line information, use SetLine if you want the compiled code to have line numbers.
+
+// SetLine sets the line used for debug info when the Node is compiled and returns the Node.
+func SetLine(n Node, line int) Node {
+	n.setLine(line)
+	return n
+}
+
+// NewAssign creates an assignment statement (targets = values). The targets must be identifiers or table
+// accessors.
+func NewAssign(targets []Expr, values []Expr) *Assign {
+	n := &Assign{Targets: targets, Values: values}
+	built(n)
+	return n
+}
+
+// NewLocal creates a local variable declaration (local names = values). values may be empty.
+func NewLocal(names []string, values []Expr) *Assign {
+	n := &Assign{LocalDecl: true, Values: values}
+	for _, name := range names {
+		n.Targets = append(n.Targets, &ConstIdent{Value: name})
+	}
+	if values == nil {
+		n.Values = []Expr{}
+	}
+	built(n)
+	return n
+}
+
+// NewLocalFunction creates a local function declaration (local function name() end).
+func NewLocalFunction(name string, f *FuncDecl) *Assign {
+	n := &Assign{LocalFunc: true, Targets: []Expr{&ConstIdent{Value: name}}, Values: []Expr{f}}
+	built(n)
+	return n
+}
+
+// NewDoBlock creates a do block (do ... end).
+func NewDoBlock(block []Stmt) *DoBlock {
+	if block == nil {
+		block = []Stmt{} // A nil block is an empty statement.
+	}
+	n := &DoBlock{Block: block}
+	built(n)
+	return n
+}
+
+// NewIf creates an if statement. For elseif make an If the only statement in els.
+func NewIf(cond Expr, then, els []Stmt) *If {
+	n := &If{Cond: cond, Then: then, Else: els}
+	built(n)
+	return n
+}
+
+// NewWhile creates a while loop.
+func NewWhile(cond Expr, block []Stmt) *WhileLoop {
+	n := &WhileLoop{Cond: cond, Block: block}
+	built(n)
+	return n
+}
+
+// NewRepeat creates a repeat-until loop.
+func NewRepeat(block []Stmt, cond Expr) *RepeatUntilLoop {
+	n := &RepeatUntilLoop{Block: block, Cond: cond}
+	built(n)
+	return n
+}
+
+// NewForNumeric creates a numeric for loop. If step is nil it defaults to 1.
+func NewForNumeric(counter string, init, limit, step Expr, block []Stmt) *ForLoopNumeric {
+	if step == nil {
+		step = &ConstInt{Value: "1"}
+	}
+	n := &ForLoopNumeric{Counter: counter, Init: init, Limit: limit, Step: step, Block: block}
+	built(n)
+	return n
+}
+
+// NewForGeneric creates a generic for loop (for locals in init do ... end).
+func NewForGeneric(locals []string, init []Expr, block []Stmt) *ForLoopGeneric {
+	n := &ForLoopGeneric{Locals: locals, Init: init, Block: block}
+	built(n)
+	return n
+}
+
+// NewGoto creates a goto statement.
+func NewGoto(label string) *Goto {
+	n := &Goto{Label: label}
+	built(n)
+	return n
+}
+
+// NewBreak creates a break statement.
+func NewBreak() *Goto {
+	return &Goto{Label: "break", IsBreak: true}
+}
+
+// NewContinue creates a continue statement. This works even if continue was not enabled when parsing, but
+// the tree cannot be written back out as standard Lua.
+func NewContinue() *Goto {
+	return &Goto{Label: "continue", IsBreak: true}
+}
+
+// NewLabel creates a label for use with goto.
+func NewLabel(label string) *Label {
+	n := &Label{Label: label}
+	built(n)
+	return n
+}
+
+// NewReturn creates a return statement.
+func NewReturn(items ...Expr) *Return {
+	if items == nil {
+		items = []Expr{}
+	}
+	n := &Return{Items: items}
+	built(n)
+	return n
+}
+
+// NewBinaryOp creates an operator with two operands, for example OpAdd.
+func NewBinaryOp(op opTyp, left, right Expr) *Operator {
+	n := &Operator{Op: op, Left: left, Right: right}
+	built(n)
+	return n
+}
+
+// NewUnaryOp creates an operator with one operand, one of OpUMinus, OpBinNot, OpNot, or OpLength.
+func NewUnaryOp(op opTyp, operand Expr) *Operator {
+	n := &Operator{Op: op, Right: operand}
+	built(n)
+	return n
+}
+
+// NewCall creates a function call. The result may be used as a statement or an expression.
+func NewCall(f Expr, args ...Expr) *FuncCall {
+	if args == nil {
+		args = []Expr{}
+	}
+	n := &FuncCall{Function: f, Args: args}
+	built(n)
+	return n
+}
+
+// NewMethodCall creates a method call (receiver:name(args)).
+func NewMethodCall(receiver Expr, name string, args ...Expr) *FuncCall {
+	if args == nil {
+		args = []Expr{}
+	}
+	n := &FuncCall{Receiver: receiver, Function: &ConstString{Value: name}, Args: args}
+	built(n)
+	return n
+}
+
+// NewFunction creates a function. For methods make "self" the first parameter.
+func NewFunction(params []string, variadic bool, block []Stmt) *FuncDecl {
+	if params == nil {
+		params = []string{}
+	}
+	n := &FuncDecl{Params: params, IsVariadic: variadic, Block: block}
+	built(n)
+	return n
+}
+
+// NewTable creates a table constructor. A nil key means the value is given the next integer key.
+func NewTable(keys, vals []Expr) *TableConstructor {
+	n := &TableConstructor{Keys: keys, Vals: vals}
+	if keys == nil && vals == nil {
+		n.Keys, n.Vals = []Expr{}, []Expr{}
+	}
+	built(n)
+	return n
+}
+
+// NewIndex creates a table access expression (obj[key]). Use a string constant for obj.name.
+func NewIndex(obj, key Expr) *TableAccessor {
+	n := &TableAccessor{Obj: obj, Key: key}
+	built(n)
+	return n
+}
+
+// NewParens wraps an expression in parenthesis. This truncates multiple return values to one value.
+func NewParens(e Expr) *Parens {
+	n := &Parens{Inner: e}
+	built(n)
+	return n
+}
+
+// NewInt creates an integer constant. Negative values are represented as the negation of a positive constant,
+// the same as if they had been parsed.
+func NewInt(v int64) Expr {
+	if v == math.MinInt64 {
+		// Hex constants wrap around, so this is the only way to write the smallest integer.
+		return &ConstInt{Value: "0x8000000000000000"}
+	}
+	if v < 0 {
+		return &Operator{Op: OpUMinus, Right: NewInt(-v)}
+	}
+	return &ConstInt{Value: strconv.FormatInt(v, 10)}
+}
+
+// NewFloat creates a float constant. Negative values are represented as the negation of a positive constant,
+// the same as if they had been parsed. Infinity and NaN are created with operators (1.0/0.0 and 0.0/0.0).
+func NewFloat(v float64) Expr {
+	switch {
+	case math.IsNaN(v):
+		return &Operator{Op: OpDiv, Left: &ConstFloat{Value: "0.0"}, Right: &ConstFloat{Value: "0.0"}}
+	case math.IsInf(v, 0):
+		inf := &Operator{Op: OpDiv, Left: &ConstFloat{Value: "1.0"}, Right: &ConstFloat{Value: "0.0"}}
+		if v < 0 {
+			return &Operator{Op: OpUMinus, Right: inf}
+		}
+		return inf
+	case v < 0 || v == 0 && math.Signbit(v):
+		return &Operator{Op: OpUMinus, Right: NewFloat(-v)}
+	}
+
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return &ConstFloat{Value: s}
+}
+
+// NewString creates a string constant.
+func NewString(s string) *ConstString {
+	return &ConstString{Value: s}
+}
+
+// NewIdent creates a reference to a variable.
+func NewIdent(name string) *ConstIdent {
+	n := &ConstIdent{Value: name}
+	built(n)
+	return n
+}
+
+// NewBool creates a boolean constant.
+func NewBool(v bool) *ConstBool {
+	return &ConstBool{Value: v}
+}
+
+// NewNil creates the nil constant.
+func NewNil() *ConstNil {
+	return &ConstNil{}
+}
+
+// NewVariadic creates the variadic expression (...).
+func NewVariadic() *ConstVariadic {
+	return &ConstVariadic{}
+}
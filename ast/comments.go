@@ -0,0 +1,172 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "sort"
+import "strings"
+
+// Comment is a single comment from the source code.
+type Comment struct {
+	Text string // The full text of the comment, including the leading "--" and any long brackets.
+	Sp   Span
+}
+
+// Span returns the range of source code the comment was read from.
+func (c *Comment) Span() Span { return c.Sp }
+
+// IsDoc returns true if this is a LDoc style documentation comment ("---" or "--[[--").
+func (c *Comment) IsDoc() bool {
+	return strings.HasPrefix(c.Text, "---") || strings.HasPrefix(c.Text, "--[[--")
+}
+
+// Content returns the text of the comment without the comment markers. For line comments all the leading
+// dashes and a single space are removed, for long comments the brackets are removed.
+func (c *Comment) Content() string {
+	t := c.Text[2:]
+	if strings.HasPrefix(t, "[") {
+		level := strings.IndexByte(t[1:], '[')
+		if level >= 0 && strings.Count(t[1:level+1], "=") == level {
+			t = strings.TrimPrefix(t[level+2:], "--") // --[[-- style doc comments
+			return strings.TrimSuffix(t, "]"+strings.Repeat("=", level)+"]")
+		}
+	}
+	return strings.TrimPrefix(strings.TrimLeft(t, "-"), " ")
+}
+
+// Comments holds the comments attached to a statement. Comments are only read if ParseOptions.Comments
+// is set, otherwise this is always nil.
+//
+// Every comment is attached to exactly one statement. Comments between two statements are leading comments
+// of the second one, unless they start on the line where the first one ends. Comments after the last
+// statement in a block are trailing comments of that statement. Comments inside a statement that are not
+// attached to a nested statement (for example comments inside expressions or empty blocks, or comments after
+// the start of a block on the same line) are inner comments.
+// If a chunk has no statements at all its comments are lost.
+type Comments struct {
+	Leading  []*Comment
+	Trailing []*Comment
+	Inner    []*Comment
+}
+
+// Doc returns the content of the documentation comment for the statement, if any. A documentation comment
+// is a run of leading comments on consecutive lines directly before the statement, where the first comment
+// is a LDoc style doc comment ("---" or "--[[--"). Each line of the content is separated by a newline.
+func (c *Comments) Doc() string {
+	if c == nil || len(c.Leading) == 0 {
+		return ""
+	}
+
+	// Find the start of the run of comments directly before the statement.
+	i := len(c.Leading) - 1
+	for i > 0 && c.Leading[i-1].Sp.End.Line+1 >= c.Leading[i].Sp.Start.Line {
+		i--
+	}
+	if !c.Leading[i].IsDoc() {
+		return ""
+	}
+
+	lines := []string{}
+	for _, cmt := range c.Leading[i:] {
+		lines = append(lines, cmt.Content())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// commentList keeps track of which comments read by the lexer have been attached to a statement.
+type commentList struct {
+	list []*Comment
+	used []bool
+}
+
+func (cl *commentList) add(c *Comment) {
+	cl.list = append(cl.list, c)
+	cl.used = append(cl.used, false)
+}
+
+// take returns all the comments in the range [from, to) that are not attached yet and marks them as attached.
+func (cl *commentList) take(from, to Position) []*Comment {
+	i := sort.Search(len(cl.list), func(i int) bool {
+		return cl.list[i].Sp.Start.Offset >= from.Offset
+	})
+
+	rtn := []*Comment{}
+	for ; i < len(cl.list) && cl.list[i].Sp.Start.Offset < to.Offset; i++ {
+		if !cl.used[i] {
+			cl.used[i] = true
+			rtn = append(rtn, cl.list[i])
+		}
+	}
+	return rtn
+}
+
+// release marks a comment as not attached.
+func (cl *commentList) release(c *Comment) {
+	i := sort.Search(len(cl.list), func(i int) bool {
+		return cl.list[i].Sp.Start.Offset >= c.Sp.Start.Offset
+	})
+	cl.used[i] = false
+}
+
+// comments returns the comments attached to a Node, creating them if needed.
+func comments(n Node) *Comments {
+	c := n.Comments()
+	if c == nil {
+		c = &Comments{}
+		n.setComments(c)
+	}
+	return c
+}
+
+// blockComments attaches the unattached comments in the range [from, to) to the statements in the given block.
+// Comments in nested blocks must already be attached.
+func (p *parser) blockComments(block []Stmt, from, to Position) {
+	if p.l.comments == nil {
+		return
+	}
+
+	i := 0
+	for _, c := range p.l.comments.take(from, to) {
+		for i < len(block) && block[i].Span().End.Offset <= c.Sp.Start.Offset {
+			i++
+		}
+
+		switch {
+		case c.Sp.Start.Line == from.Line && (i == len(block) || block[i].Span().Start.Line > from.Line):
+			// Comments on the same line as the start of the block (but not before a statement on that line)
+			// are left for the statement that contains the block.
+			p.l.comments.release(c)
+		case i < len(block) && block[i].Span().Start.Offset <= c.Sp.Start.Offset:
+			cmts := comments(block[i])
+			cmts.Inner = append(cmts.Inner, c)
+		case i > 0 && (i == len(block) || block[i-1].Span().End.Line == c.Sp.Start.Line):
+			cmts := comments(block[i-1])
+			cmts.Trailing = append(cmts.Trailing, c)
+		case i < len(block):
+			cmts := comments(block[i])
+			cmts.Leading = append(cmts.Leading, c)
+		default:
+			// Empty block, leave it for the statement that contains the block.
+			p.l.comments.release(c)
+		}
+	}
+}
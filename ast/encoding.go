@@ -0,0 +1,402 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "fmt"
+import "bytes"
+import "reflect"
+import "encoding/xml"
+import "encoding/json"
+
+// JSONVersion is the version of the JSON format written by MarshalJSON. If the format changes in a way that
+// is not backwards compatible this will be incremented.
+const JSONVersion = 1
+
+/*
+The JSON format used by MarshalJSON and UnmarshalJSON looks like this:
+
+	{
+		"Version": 1,
+		"Block": [
+			{
+				"Type": "Assign",
+				"Line": 1,
+				"Span": {"Start": {"Line": 1, "Col": 1, "Offset": 0}, "End": {"Line": 1, "Col": 12, "Offset": 11}},
+				"LocalDecl": true,
+				"LocalFunc": false,
+				"Targets": [{"Type": "ConstIdent", "Line": 1, "Span": {...}, "Value": "a"}],
+				"Values": [{"Type": "ConstInt", "Line": 1, "Span": {...}, "Value": "1"}]
+			}
+		]
+	}
+
+Every Node is an object with a "Type" key that holds the name of the Node type, plus "Line", "Span", and
+(if there are any) "Comments" keys. The rest of the keys are the fields of the Node type, with the same names
+and types as in Go. Child Nodes use the same format, a missing child Node is null. Nil lists are encoded as
+null, and empty lists as [] (a DoBlock with a nil Block is an empty statement, not a "do end" block).
+Operators are encoded with their names, for example "OpAdd".
+*/
+
+// jsonChunk is the top level object of the JSON format.
+type jsonChunk struct {
+	Version int
+	Block   json.RawMessage
+}
+
+// nodeTypes maps Node type names to the Node types.
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, n := range []Node{
+		&Assign{}, &DoBlock{}, &If{}, &WhileLoop{}, &RepeatUntilLoop{}, &ForLoopNumeric{}, &ForLoopGeneric{},
+		&Goto{}, &Label{}, &Return{},
+		&Operator{}, &FuncCall{}, &FuncDecl{}, &TableConstructor{}, &TableAccessor{}, &Parens{},
+		&ConstInt{}, &ConstFloat{}, &ConstString{}, &ConstIdent{}, &ConstBool{}, &ConstNil{}, &ConstVariadic{},
+	} {
+		t := reflect.TypeOf(n).Elem()
+		nodeTypes[t.Name()] = t
+	}
+}
+
+var exprType = reflect.TypeOf((*Expr)(nil)).Elem()
+var stmtType = reflect.TypeOf((*Stmt)(nil)).Elem()
+var exprListType = reflect.TypeOf([]Expr(nil))
+var stmtListType = reflect.TypeOf([]Stmt(nil))
+
+// MarshalJSON encodes a block in a versioned JSON format that can be read back with UnmarshalJSON. Unlike
+// the encoding produced by json.Marshal, this keeps the type of every Node.
+func MarshalJSON(block []Stmt) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `{"Version":%d,"Block":`, JSONVersion)
+	err := marshalJSONValue(buf, reflect.ValueOf(block))
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func marshalJSONNode(buf *bytes.Buffer, n Node) error {
+	v := reflect.ValueOf(n)
+	if v.Kind() != reflect.Ptr || v.IsNil() || nodeTypes[v.Elem().Type().Name()] != v.Elem().Type() {
+		return fmt.Errorf("cannot encode Node of type %T", n)
+	}
+	v = v.Elem()
+
+	fmt.Fprintf(buf, `{"Type":%q,"Line":%d,"Span":`, v.Type().Name(), n.Line())
+	err := marshalJSONValue(buf, reflect.ValueOf(n.Span()))
+	if err != nil {
+		return err
+	}
+	if n.Comments() != nil {
+		buf.WriteString(`,"Comments":`)
+		err := marshalJSONValue(buf, reflect.ValueOf(n.Comments()))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous {
+			continue
+		}
+		fmt.Fprintf(buf, `,%q:`, f.Name)
+		err := marshalJSONValue(buf, v.Field(i))
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteString("}")
+	return nil
+}
+
+func marshalJSONValue(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Type() {
+	case exprType, stmtType:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return marshalJSONNode(buf, v.Interface().(Node))
+	case exprListType, stmtListType:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			err := marshalJSONValue(buf, v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+		return nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+// UnmarshalJSON decodes a block encoded by MarshalJSON. Errors include the path to the problem, for example
+// "Block[2].Values[0]: unknown Node type "Foo"".
+func UnmarshalJSON(data []byte) ([]Stmt, error) {
+	chunk := jsonChunk{}
+	err := json.Unmarshal(data, &chunk)
+	if err != nil {
+		return nil, err
+	}
+	if chunk.Version != JSONVersion {
+		return nil, fmt.Errorf("unsupported AST JSON version: %v", chunk.Version)
+	}
+
+	block := []Stmt(nil)
+	err = unmarshalJSONValue(chunk.Block, reflect.ValueOf(&block).Elem(), "Block")
+	return block, err
+}
+
+func unmarshalJSONNode(data json.RawMessage, path string) (Node, error) {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	typ := ""
+	err = json.Unmarshal(fields["Type"], &typ)
+	if err != nil {
+		return nil, fmt.Errorf("%v: missing or invalid Node type", path)
+	}
+	t, ok := nodeTypes[typ]
+	if !ok {
+		return nil, fmt.Errorf("%v: unknown Node type %q", path, typ)
+	}
+
+	v := reflect.New(t)
+	n := v.Interface().(Node)
+	v = v.Elem()
+
+	line, span, comments := 0, Span{}, (*Comments)(nil)
+	for key, dest := range map[string]interface{}{"Line": &line, "Span": &span, "Comments": &comments} {
+		if data, ok := fields[key]; ok {
+			err := json.Unmarshal(data, dest)
+			if err != nil {
+				return nil, fmt.Errorf("%v.%v: %v", path, key, err)
+			}
+		}
+	}
+	n.setLine(line)
+	n.setSpan(span)
+	n.setComments(comments)
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		data, ok := fields[f.Name]
+		if f.Anonymous || !ok {
+			continue
+		}
+		err := unmarshalJSONValue(data, v.Field(i), path+"."+f.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func unmarshalJSONValue(data json.RawMessage, v reflect.Value, path string) error {
+	switch v.Type() {
+	case exprType, stmtType:
+		if string(data) == "null" {
+			return nil
+		}
+		n, err := unmarshalJSONNode(data, path)
+		if err != nil {
+			return err
+		}
+		if !reflect.TypeOf(n).Implements(v.Type()) {
+			return fmt.Errorf("%v: a %T is not a %v", path, n, v.Type().Name())
+		}
+		v.Set(reflect.ValueOf(n))
+		return nil
+	case exprListType, stmtListType:
+		if string(data) == "null" {
+			return nil
+		}
+		items := []json.RawMessage{}
+		err := json.Unmarshal(data, &items)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		v.Set(reflect.MakeSlice(v.Type(), len(items), len(items)))
+		for i, item := range items {
+			err := unmarshalJSONValue(item, v.Index(i), fmt.Sprintf("%v[%v]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := json.Unmarshal(data, v.Addr().Interface())
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
+
+// XML encoding. The encoding/xml package names the element for a value after the field it is stored in, so
+// without this the type of each Node would be lost. Each Node element has a "type" attribute with the name of
+// the Node type, and nil Nodes in lists are written as empty elements with a "nil" attribute so that the keys
+// and values of a table constructor stay lined up.
+
+func (n *Assign) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *DoBlock) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *If) MarshalXML(e *xml.Encoder, start xml.StartElement) error { return marshalXML(n, e, start) }
+func (n *WhileLoop) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *RepeatUntilLoop) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ForLoopNumeric) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ForLoopGeneric) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *Goto) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *Label) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *Return) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+
+func (n *Operator) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *FuncCall) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *FuncDecl) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *TableConstructor) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *TableAccessor) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *Parens) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ConstInt) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ConstFloat) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ConstString) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ConstIdent) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ConstBool) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ConstNil) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+func (n *ConstVariadic) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalXML(n, e, start)
+}
+
+func marshalXML(n Node, e *xml.Encoder, start xml.StartElement) error {
+	v := reflect.ValueOf(n).Elem()
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: v.Type().Name()})
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	err = e.EncodeElement(n.Line(), xml.StartElement{Name: xml.Name{Local: "Line"}})
+	if err != nil {
+		return err
+	}
+	err = e.EncodeElement(n.Span(), xml.StartElement{Name: xml.Name{Local: "Span"}})
+	if err != nil {
+		return err
+	}
+	if n.Comments() != nil {
+		err = e.EncodeElement(n.Comments(), xml.StartElement{Name: xml.Name{Local: "Comments"}})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous {
+			continue
+		}
+		fstart := xml.StartElement{Name: xml.Name{Local: f.Name}}
+
+		fv := v.Field(i)
+		if fv.Type() != exprListType && fv.Type() != stmtListType {
+			err := e.EncodeElement(fv.Interface(), fstart)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for j := 0; j < fv.Len(); j++ {
+			if fv.Index(j).IsNil() {
+				nstart := fstart.Copy()
+				nstart.Attr = append(nstart.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+				err = e.EncodeElement("", nstart)
+			} else {
+				err = e.EncodeElement(fv.Index(j).Interface(), fstart)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
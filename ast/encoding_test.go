@@ -0,0 +1,98 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "testing"
+import "strings"
+import "reflect"
+import "encoding/xml"
+
+func TestJSONRoundTrip(t *testing.T) {
+	src := `-- Comment
+local a, b = 1, 2.5
+function t:m(...) return self, ... end
+x = -a .. "s" or not b;
+y = {1, k = 2, [3] = f(a)}
+do end
+if a then elseif b then else end
+while a do break end
+repeat goto x until a
+for i = 1, 2 do end
+for k, v in pairs(t) do end
+::x::
+return (f())
+`
+	block, err := Parse(src, 1, ParseOptions{Comments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MarshalJSON(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block2, err := UnmarshalJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(block, block2) {
+		t.Errorf("Decoded AST does not match:\n%s", data)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"Version":2,"Block":[]}`, "unsupported AST JSON version: 2"},
+		{`{"Version":1,"Block":[{"Type":"Foo"}]}`, `Block[0]: unknown Node type "Foo"`},
+		{`{"Version":1,"Block":[{"Type":"Return","Items":[{"Type":"Label"}]}]}`, "Block[0].Items[0]: a *ast.Label is not a Expr"},
+		{`{"Version":1,"Block":[{"Type":"Return","Items":[{"Type":"Operator","Op":"OpFoo"}]}]}`, "Block[0].Items[0].Op:"},
+	}
+
+	for _, test := range tests {
+		_, err := UnmarshalJSON([]byte(test.data))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("Incorrect error for %v: %v", test.data, err)
+		}
+	}
+}
+
+func TestXML(t *testing.T) {
+	block, err := Parse("x = {a = 1, 2}", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := xml.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every Node keeps its type, and nil table keys are kept so the keys line up with the values.
+	for _, s := range []string{`<Assign type="Assign">`, `<Targets type="ConstIdent">`, `<Values type="TableConstructor">`, `<Keys nil="true"></Keys>`} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("Missing %q in XML:\n%s", s, data)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "fmt"
+
+// SyntaxError is a single syntax error found while parsing in error recovery mode.
+type SyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+// Error formats the error the same way normal syntax errors are formatted.
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("%v On Line: %v Column: %v", err.Msg, err.Pos.Line, err.Pos.Col)
+}
+
+// ErrorList is the error returned by Parse in error recovery mode. The errors are in source order.
+type ErrorList []*SyntaxError
+
+// Error returns the message for the first error and the number of other errors.
+func (errs ErrorList) Error() string {
+	switch len(errs) {
+	case 0:
+		return "No errors"
+	case 1:
+		return errs[0].Error()
+	}
+	return fmt.Sprintf("%v (and %v more errors)", errs[0], len(errs)-1)
+}
+
+// add records a new error. Only the first error on each line is kept, as once the parser is confused
+// it tends to produce several bogus errors before it manages to synchronize.
+//
+// The lexer runs a few tokens ahead of the parser, so errors may not be found in order.
+func (errs *ErrorList) add(pos Position, msg string) {
+	at := len(*errs)
+	for i, err := range *errs {
+		if err.Pos.Line == pos.Line {
+			return
+		}
+		if err.Pos.Line > pos.Line && at == len(*errs) {
+			at = i
+		}
+	}
+
+	*errs = append(*errs, nil)
+	copy((*errs)[at+1:], (*errs)[at:])
+	(*errs)[at] = &SyntaxError{Pos: pos, Msg: msg}
+}
@@ -95,6 +95,16 @@ func (o opTyp) MarshalText() ([]byte, error) {
 	return opTypNames[int(o)], nil
 }
 
+func (o *opTyp) UnmarshalText(text []byte) error {
+	for i, name := range opTypNames {
+		if string(name) == string(text) {
+			*o = opTyp(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid opTyp name %q", text)
+}
+
 func (o opTyp) String() string {
 	name, err := o.MarshalText()
 	if err != nil {
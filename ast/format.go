@@ -0,0 +1,616 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "io"
+import "fmt"
+import "bytes"
+import "strings"
+import "unicode"
+import "unicode/utf8"
+import "github.com/milochristiansen/lua/luautil"
+
+// QuoteStyle selects the quote character Format uses for strings.
+type QuoteStyle int
+
+const (
+	QuoteDouble  QuoteStyle = iota // Always use double quotes.
+	QuoteSingle                    // Always use single quotes.
+	QuoteMinimal                   // Use whichever quote needs fewer escapes, double quotes if there is a tie.
+)
+
+// FormatOptions controls the output of Format. The zero value indents with tabs and uses double quotes.
+type FormatOptions struct {
+	// The string used for each level of indentation. If empty a single tab is used.
+	Indent string
+
+	Quote QuoteStyle
+}
+
+// Format writes the given block as Lua source code.
+//
+// Parsing the output results in a tree that is equivalent to the input, ignoring source positions. Function
+// call arguments are always wrapped in parenthesis, parenthesis are added around operators where needed
+// because of precedence, and empty statements (stray semicolons) are dropped. Existing Parens Nodes are kept,
+// as they change the meaning of multiple return values.
+//
+// If the tree has source positions blank lines between statements are kept, as is the choice between
+// "function f() end" and "f = function() end". Comments (see ParseOptions.Comments) are written as well,
+// inner comments are moved so that they are before the statement they belong to.
+//
+// Format will return an error if the tree contains a nil where a Node is required, or if there is a Node
+// type that cannot be formatted.
+func Format(w io.Writer, block []Stmt, opts FormatOptions) (err error) {
+	if opts.Indent == "" {
+		opts.Indent = "\t"
+	}
+
+	p := &printer{opts: opts}
+	defer func() {
+		if x := recover(); x != nil {
+			e, ok := x.(luautil.Error)
+			if !ok {
+				panic(x)
+			}
+			err = e
+		}
+	}()
+
+	p.stmts(block)
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	p.buf.WriteString("\n")
+
+	// Every line is started with a newline, so the first char is always an extra newline.
+	_, err = w.Write(p.buf.Bytes()[1:])
+	return err
+}
+
+type printer struct {
+	buf   bytes.Buffer
+	opts  FormatOptions
+	depth int
+	last  int // The source line where the last statement ended, 0 if unknown.
+}
+
+func (p *printer) write(s ...string) {
+	for _, ss := range s {
+		p.buf.WriteString(ss)
+	}
+}
+
+// newline starts a new indented line.
+func (p *printer) newline() {
+	p.buf.WriteString("\n")
+	for i := 0; i < p.depth; i++ {
+		p.buf.WriteString(p.opts.Indent)
+	}
+}
+
+// gap starts a new line, with a blank line before it if there is one in the source.
+func (p *printer) gap(line int) {
+	if p.last > 0 && line > p.last+1 {
+		p.buf.WriteString("\n")
+	}
+	p.newline()
+}
+
+// stmts writes a list of statements. Each statement is on its own line.
+func (p *printer) stmts(block []Stmt) {
+	p.last = 0
+	first := true
+	for _, s := range block {
+		if s == nil {
+			luautil.Raise("Cannot format nil statement", luautil.ErrTypUndefined)
+		}
+		if d, ok := s.(*DoBlock); ok && d.Block == nil {
+			continue // Empty statement
+		}
+
+		c := s.Comments()
+		if c == nil {
+			c = &Comments{}
+		}
+		for _, cs := range [][]*Comment{c.Leading, c.Inner} {
+			for _, cmt := range cs {
+				p.gap(cmt.Sp.Start.Line)
+				p.write(cmt.Text)
+				p.last = cmt.Sp.End.Line
+			}
+		}
+
+		p.gap(s.Span().Start.Line)
+		if !first && startsWithParen(s) {
+			p.write(";") // Else it would be read as a call continuing the last statement.
+		}
+		p.stmt(s)
+		p.last = s.Span().End.Line
+
+		for _, cmt := range c.Trailing {
+			if cmt.Sp.Start.Line == p.last && p.last != 0 {
+				p.write(" ")
+			} else {
+				p.gap(cmt.Sp.Start.Line)
+			}
+			p.write(cmt.Text)
+			p.last = cmt.Sp.End.Line
+		}
+		first = false
+	}
+}
+
+// body writes an indented block. If the block is not empty the following keyword will be on a new line,
+// else it is separated from what came before with a space.
+func (p *printer) body(block []Stmt) {
+	last := p.last
+	p.depth++
+	l := p.buf.Len()
+	p.stmts(block)
+	p.depth--
+	if p.buf.Len() == l {
+		p.write(" ")
+	} else {
+		p.newline()
+	}
+	p.last = last
+}
+
+func (p *printer) stmt(s Stmt) {
+	switch n := s.(type) {
+	case *Assign:
+		if p.funcDeclStmt(n) {
+			return
+		}
+		if n.LocalDecl {
+			p.write("local ")
+		}
+		p.exprs(n.Targets)
+		if len(n.Values) > 0 {
+			p.write(" = ")
+			p.exprs(n.Values)
+		}
+	case *FuncCall:
+		p.expr(n)
+	case *DoBlock:
+		p.write("do")
+		p.body(n.Block)
+		p.write("end")
+	case *If:
+		p.write("if ")
+		p.expr(n.Cond)
+		p.write(" then")
+		p.body(n.Then)
+		for {
+			if len(n.Else) == 0 {
+				break
+			}
+			if nn, ok := n.Else[0].(*If); ok && len(n.Else) == 1 && nn.Comments() == nil && nn.Span().End == n.Span().End {
+				n = nn
+				p.write("elseif ")
+				p.expr(n.Cond)
+				p.write(" then")
+				p.body(n.Then)
+				continue
+			}
+			p.write("else")
+			p.body(n.Else)
+			break
+		}
+		p.write("end")
+	case *WhileLoop:
+		p.write("while ")
+		p.expr(n.Cond)
+		p.write(" do")
+		p.body(n.Block)
+		p.write("end")
+	case *RepeatUntilLoop:
+		p.write("repeat")
+		p.body(n.Block)
+		p.write("until ")
+		p.expr(n.Cond)
+	case *ForLoopNumeric:
+		p.write("for ", n.Counter, " = ")
+		p.expr(n.Init)
+		p.write(", ")
+		p.expr(n.Limit)
+		if s, ok := n.Step.(*ConstInt); n.Step != nil && !(ok && s.Value == "1" && s.Span().Start == s.Span().End) {
+			p.write(", ")
+			p.expr(n.Step)
+		}
+		p.write(" do")
+		p.body(n.Block)
+		p.write("end")
+	case *ForLoopGeneric:
+		p.write("for ", strings.Join(n.Locals, ", "), " in ")
+		p.exprs(n.Init)
+		p.write(" do")
+		p.body(n.Block)
+		p.write("end")
+	case *Goto:
+		switch {
+		case n.IsBreak && n.Label == "continue":
+			p.write("continue")
+		case n.IsBreak:
+			p.write("break")
+		default:
+			p.write("goto ", n.Label)
+		}
+	case *Label:
+		p.write("::", n.Label, "::")
+	case *Return:
+		p.write("return")
+		if len(n.Items) > 0 {
+			p.write(" ")
+			p.exprs(n.Items)
+		}
+	default:
+		luautil.Raise(fmt.Sprintf("Cannot format statement of type %T", s), luautil.ErrTypUndefined)
+	}
+}
+
+// funcDeclStmt writes an assignment of a function as a function declaration statement, if possible.
+// For parsed trees this is only done if the source was a function declaration statement.
+func (p *printer) funcDeclStmt(n *Assign) bool {
+	if n.LocalDecl || len(n.Targets) != 1 || len(n.Values) != 1 {
+		return false
+	}
+	f, ok := n.Values[0].(*FuncDecl)
+	if n.LocalFunc {
+		// There is no other way to write these.
+		if _, isIdent := n.Targets[0].(*ConstIdent); !ok || !isIdent {
+			luautil.Raise("Cannot format local function declaration without a name or function", luautil.ErrTypUndefined)
+		}
+		p.write("local ")
+	} else if !ok || !isNameChain(n.Targets[0]) || f.Span().Start != n.Span().Start {
+		return false
+	}
+
+	params := f.Params
+	p.write("function ")
+	if t, ok := n.Targets[0].(*TableAccessor); ok && len(params) > 0 && params[0] == "self" {
+		p.expr(t.Obj)
+		p.write(":", t.Key.(*ConstString).Value)
+		params = params[1:]
+	} else {
+		p.expr(n.Targets[0])
+	}
+	p.funcBody(params, f)
+	return true
+}
+
+// isNameChain returns true if the Expr is a name followed by zero or more ".name" parts.
+func isNameChain(e Expr) bool {
+	switch n := e.(type) {
+	case *ConstIdent:
+		return isName(n.Value)
+	case *TableAccessor:
+		k, ok := n.Key.(*ConstString)
+		return ok && isName(k.Value) && isNameChain(n.Obj)
+	}
+	return false
+}
+
+// isName returns true if the string is a valid identifier that is not a keyword.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	if _, ok := keywords[s]; ok || s == "continue" {
+		return false // "continue" may be a keyword, depending on the ParseOptions.
+	}
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// startsWithParen returns true if the statement will start with an open parenthesis.
+func startsWithParen(s Stmt) bool {
+	var e Expr
+	switch n := s.(type) {
+	case *FuncCall:
+		e = n
+	case *Assign:
+		if n.LocalDecl || len(n.Targets) == 0 {
+			return false
+		}
+		e = n.Targets[0]
+	default:
+		return false
+	}
+
+	for {
+		switch n := e.(type) {
+		case *FuncCall:
+			e = n.Function
+			if n.Receiver != nil {
+				e = n.Receiver
+			}
+		case *TableAccessor:
+			e = n.Obj
+		case *ConstIdent:
+			return false
+		default:
+			return true // Parens, or something that will be wrapped in them.
+		}
+	}
+}
+
+func (p *printer) exprs(es []Expr) {
+	for i, e := range es {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(e)
+	}
+}
+
+var opSymbols = [...]string{
+	OpAdd:            "+",
+	OpSub:            "-",
+	OpMul:            "*",
+	OpMod:            "%",
+	OpPow:            "^",
+	OpDiv:            "/",
+	OpIDiv:           "//",
+	OpBinAND:         "&",
+	OpBinOR:          "|",
+	OpBinXOR:         "~",
+	OpBinShiftL:      "<<",
+	OpBinShiftR:      ">>",
+	OpUMinus:         "-",
+	OpBinNot:         "~",
+	OpNot:            "not ",
+	OpLength:         "#",
+	OpConcat:         "..",
+	OpEqual:          "==",
+	OpNotEqual:       "~=",
+	OpLessThan:       "<",
+	OpGreaterThan:    ">",
+	OpLessOrEqual:    "<=",
+	OpGreaterOrEqual: ">=",
+	OpAnd:            "and",
+	OpOr:             "or",
+}
+
+func (p *printer) expr(e Expr) {
+	switch n := e.(type) {
+	case nil:
+		luautil.Raise("Cannot format nil expression", luautil.ErrTypUndefined)
+	case *Operator:
+		if n.Op < 0 || int(n.Op) >= len(opSymbols) {
+			luautil.Raise(fmt.Sprintf("Cannot format invalid operator: %v", int(n.Op)), luautil.ErrTypUndefined)
+		}
+		pri := priorities[n.Op]
+		if n.Left == nil {
+			p.write(opSymbols[n.Op])
+			if r, ok := n.Right.(*Operator); ok && r.Left == nil && opSymbols[r.Op] == opSymbols[n.Op] {
+				p.write(" ") // "- -x", not "--x"
+			}
+			p.operand(n.Right, needParens(n.Right, pri.right, true))
+			return
+		}
+		p.operand(n.Left, needParens(n.Left, pri.left, false))
+		p.write(" ", opSymbols[n.Op], " ")
+		p.operand(n.Right, needParens(n.Right, pri.right, true))
+	case *FuncCall:
+		if n.Receiver != nil {
+			k, ok := n.Function.(*ConstString)
+			if !ok || !isName(k.Value) {
+				luautil.Raise("Cannot format method call with invalid method name", luautil.ErrTypUndefined)
+			}
+			p.prefix(n.Receiver)
+			p.write(":", k.Value)
+		} else {
+			p.prefix(n.Function)
+		}
+		p.write("(")
+		p.exprs(n.Args)
+		p.write(")")
+	case *FuncDecl:
+		p.write("function")
+		p.funcBody(n.Params, n)
+	case *TableConstructor:
+		p.table(n)
+	case *TableAccessor:
+		p.prefix(n.Obj)
+		if k, ok := n.Key.(*ConstString); ok && isName(k.Value) {
+			p.write(".", k.Value)
+			return
+		}
+		p.write("[")
+		p.expr(n.Key)
+		p.write("]")
+	case *Parens:
+		p.write("(")
+		p.expr(n.Inner)
+		p.write(")")
+	case *ConstInt:
+		p.write(n.Value)
+	case *ConstFloat:
+		p.write(n.Value)
+	case *ConstString:
+		p.write(quote(n.Value, p.opts.Quote))
+	case *ConstIdent:
+		p.write(n.Value)
+	case *ConstBool:
+		if n.Value {
+			p.write("true")
+		} else {
+			p.write("false")
+		}
+	case *ConstNil:
+		p.write("nil")
+	case *ConstVariadic:
+		p.write("...")
+	default:
+		luautil.Raise(fmt.Sprintf("Cannot format expression of type %T", e), luautil.ErrTypUndefined)
+	}
+}
+
+// needParens returns true if e needs parenthesis when it is the operand of an operator with the given
+// priority. See subexpr for how the priorities work.
+func needParens(e Expr, priority int, right bool) bool {
+	o, ok := e.(*Operator)
+	if !ok {
+		return false
+	}
+	if right {
+		// A unary operator is always read completely, no matter what is to the left of it.
+		return o.Left != nil && priorities[o.Op].left <= priority
+	}
+	return priority > priorities[o.Op].right
+}
+
+func (p *printer) operand(e Expr, parens bool) {
+	if parens {
+		p.write("(")
+		p.expr(e)
+		p.write(")")
+		return
+	}
+	p.expr(e)
+}
+
+// prefix writes an Expr that is going to be indexed or called. Only some kinds of expressions may be used
+// this way without parenthesis.
+func (p *printer) prefix(e Expr) {
+	switch e.(type) {
+	case *ConstIdent, *TableAccessor, *FuncCall, *Parens:
+		p.expr(e)
+	default:
+		p.operand(e, true)
+	}
+}
+
+func (p *printer) funcBody(params []string, f *FuncDecl) {
+	if f.IsVariadic {
+		params = append(params[:len(params):len(params)], "...")
+	}
+	p.write("(", strings.Join(params, ", "), ")")
+	p.body(f.Block)
+	p.write("end")
+}
+
+// table writes a table constructor. Tables that were on more than one line in the source get one item per
+// line, everything else is kept on a single line.
+func (p *printer) table(n *TableConstructor) {
+	if len(n.Keys) != len(n.Vals) {
+		luautil.Raise("Cannot format table constructor with mismatched keys and values", luautil.ErrTypUndefined)
+	}
+	if len(n.Vals) == 0 {
+		p.write("{}")
+		return
+	}
+
+	// If a single line table has a value that takes more than one line, it looks better with one item per line.
+	start := p.buf.Len()
+	multiline := n.Span().Start.Line != n.Span().End.Line
+	if !multiline {
+		p.tableItems(n, false)
+		if bytes.IndexByte(p.buf.Bytes()[start:], '\n') == -1 {
+			return
+		}
+		p.buf.Truncate(start)
+	}
+	p.tableItems(n, true)
+}
+
+func (p *printer) tableItems(n *TableConstructor, multiline bool) {
+	p.write("{")
+	p.depth++
+	for i, v := range n.Vals {
+		if multiline {
+			p.newline()
+		} else if i > 0 {
+			p.write(", ")
+		}
+
+		switch k := n.Keys[i].(type) {
+		case nil:
+		case *ConstString:
+			if isName(k.Value) {
+				p.write(k.Value, " = ")
+				break
+			}
+			p.write("[")
+			p.expr(k)
+			p.write("] = ")
+		default:
+			p.write("[")
+			p.expr(k)
+			p.write("] = ")
+		}
+		p.expr(v)
+
+		if multiline {
+			p.write(",")
+		}
+	}
+	p.depth--
+	if multiline {
+		p.newline()
+	}
+	p.write("}")
+}
+
+// quote formats a string as a Lua string literal.
+func quote(s string, style QuoteStyle) string {
+	q := byte('"')
+	switch style {
+	case QuoteSingle:
+		q = '\''
+	case QuoteMinimal:
+		if strings.Count(s, "\"") > strings.Count(s, "'") {
+			q = '\''
+		}
+	}
+
+	buf := []byte{q}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			buf = append(buf, fmt.Sprintf("\\x%02X", s[i])...)
+		case r == rune(q) || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r == '\n':
+			buf = append(buf, `\n`...)
+		case r == '\r':
+			buf = append(buf, `\r`...)
+		case r == '\t':
+			buf = append(buf, `\t`...)
+		case r < utf8.RuneSelf && !unicode.IsPrint(r):
+			buf = append(buf, fmt.Sprintf("\\x%02X", r)...)
+		case !unicode.IsPrint(r):
+			buf = append(buf, fmt.Sprintf("\\u{%X}", r)...)
+		default:
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return string(append(buf, q))
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "testing"
+import "bytes"
+import "reflect"
+
+// stripPositions removes everything from a tree that Format does not keep.
+func stripPositions(block []Stmt) {
+	for _, s := range block {
+		Inspect(s, func(n Node) bool {
+			if n != nil {
+				n.setLine(0)
+				n.setSpan(Span{})
+				n.setComments(nil)
+			}
+			return true
+		})
+	}
+}
+
+func dropEmpty(block []Stmt) []Stmt {
+	rtn := []Stmt{}
+	for _, s := range block {
+		if d, ok := s.(*DoBlock); !ok || d.Block != nil {
+			rtn = append(rtn, s)
+		}
+	}
+	return rtn
+}
+
+func formatString(t *testing.T, src string, opts FormatOptions) string {
+	block, err := Parse(src, 1, ParseOptions{Comments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	err = Format(buf, block, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	src := `local a, b = 1, 2.5e3
+local function f(x, ...) return x, ... end
+function t.a.b:m(y) self.y = y end
+t.g = function() end
+x = -(-a) ^ 2 + (b - 1) * -c .. "s" .. 'q"\n\0' .. "\65B\xff"
+y = not (a and b) or a < b and #t == 0 ~= (1 // 2 << 3)
+z = (a + b) + c, a + (b + c), a - (b - c), (a ^ b) ^ c, a ^ (b ^ c), (a .. b) .. c
+w = {1, 2; k = "v", ["not a name"] = 3, [f(1)] = {}, f = function(a) return a end}
+t["end"], t[1] = ("x"):rep(3), (f())
+f{1}
+print "x"
+do end
+if a then b() elseif c then d() else if e then f() end end
+while a do break end
+repeat local q = 1 until q
+for i = 1, 10 do end
+for i = 10, 1, -1 do goto done end
+for k, v in pairs(t) do end
+::done::
+;(f)()
+return
+`
+	block, err := Parse(src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := formatString(t, src, FormatOptions{})
+	block2, err := Parse(out, 1)
+	if err != nil {
+		t.Fatalf("Output does not parse: %v\n%v", err, out)
+	}
+
+	// Empty statements are not kept, and Format adds them where needed.
+	block, block2 = dropEmpty(block), dropEmpty(block2)
+	stripPositions(block)
+	stripPositions(block2)
+	if !reflect.DeepEqual(block, block2) {
+		t.Errorf("Output does not match the input:\n%v", out)
+	}
+
+	// Formatting is stable.
+	if out2 := formatString(t, out, FormatOptions{}); out2 != out {
+		t.Errorf("Formatting formatted code changed it:\n%v\n%v", out, out2)
+	}
+}
+
+func TestFormatLayout(t *testing.T) {
+	src := `-- Header.
+
+--- Doc.
+local function f(a) -- trailing
+    if a then   return 1
+  elseif b then
+ return 2 end
+
+	return {
+	1, 'two',
+	}
+end
+x = - -1
+`
+	expect := `-- Header.
+
+--- Doc.
+-- trailing
+local function f(a)
+  if a then
+    return 1
+  elseif b then
+    return 2
+  end
+
+  return {
+    1,
+    'two',
+  }
+end
+x = - -1
+`
+	out := formatString(t, src, FormatOptions{Indent: "  ", Quote: QuoteSingle})
+	if out != expect {
+		t.Errorf("Incorrect output:\n%v\nExpected:\n%v", out, expect)
+	}
+
+	// Operators are wrapped in parenthesis where needed.
+	block := []Stmt{&Return{Items: []Expr{
+		&Operator{Op: OpMul, Left: &Operator{Op: OpAdd, Left: &ConstInt{Value: "1"}, Right: &ConstInt{Value: "2"}}, Right: &ConstIdent{Value: "x"}},
+		&FuncCall{Function: &ConstString{Value: "it's"}, Receiver: nil},
+	}}}
+	buf := new(bytes.Buffer)
+	if err := Format(buf, block, FormatOptions{Quote: QuoteMinimal}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "return (1 + 2) * x, (\"it's\")()\n" {
+		t.Errorf("Incorrect output: %q", buf.String())
+	}
+
+	if err := Format(buf, []Stmt{&Return{Items: []Expr{nil}}}, FormatOptions{}); err == nil {
+		t.Error("No error for invalid tree")
+	}
+}
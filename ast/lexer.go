@@ -53,7 +53,7 @@ const (
 	tknTrue
 	tknFalse
 	tknNil
-	tknContinue // Not used by the default parser.
+	tknContinue // Only generated if enabled via ParseOptions.
 
 	// Operators
 	tknAdd         // +
@@ -98,17 +98,16 @@ const (
 )
 
 var keywords = map[string]int{
-	"and":    tknAnd,
-	"or":     tknOr,
-	"not":    tknNot,
-	"while":  tknWhile,
-	"for":    tknFor,
-	"repeat": tknRepeat,
-	"until":  tknUntil,
-	"in":     tknIn,
-	"do":     tknDo,
-	"break":  tknBreak,
-	//"continue": tknContinue, // Uncomment to enable the "continue" keyword.
+	"and":      tknAnd,
+	"or":       tknOr,
+	"not":      tknNot,
+	"while":    tknWhile,
+	"for":      tknFor,
+	"repeat":   tknRepeat,
+	"until":    tknUntil,
+	"in":       tknIn,
+	"do":       tknDo,
+	"break":    tknBreak,
 	"end":      tknEnd,
 	"if":       tknIf,
 	"then":     tknThen,
@@ -123,10 +122,15 @@ var keywords = map[string]int{
 	"nil":      tknNil,
 }
 
-func keyword(s string) int {
+// keyword returns the token type for an identifier or keyword.
+// "continue" is only a keyword if it was enabled for this lexer, else it is a plain name.
+func (lex *lexer) keyword(s string) int {
 	if t, ok := keywords[s]; ok {
 		return t
 	}
+	if lex.opts.Continue && s == "continue" {
+		return tknContinue
+	}
 	return tknName
 }
 
@@ -135,27 +139,43 @@ type lexer struct {
 	look    *token
 	current *token
 
-	source *strings.Reader
-	line   int
-	char   rune
-	nline  int // Keep some lookahead information around.
-	nchar  rune
-	eof    bool // true if there are no more chars to read
-	neof   bool // true if nchar is invalid (next call to next will trigger EOF)
+	source  *strings.Reader
+	line    int
+	col     int
+	offset  int
+	char    rune
+	nline   int // Keep some lookahead information around.
+	ncol    int
+	noffset int
+	nchar   rune
+	eof     bool // true if there are no more chars to read
+	neof    bool // true if nchar is invalid (next call to next will trigger EOF)
 
 	lexeme []rune
 
 	token     int
 	tokenline int
+	tokenpos  Position
 
 	strdepth int
 	objdepth int
+
+	opts     ParseOptions
+	errs     *ErrorList   // Only set in error recovery mode.
+	src      string       // Only used to read comment text.
+	comments *commentList // Only set if comments are enabled.
 }
 
 // Returns a new Lua lexer.
-func newLexer(source string, line int) *lexer {
+func newLexer(source string, line int, opts ParseOptions) *lexer {
 	lex := new(lexer)
 
+	lex.opts = opts
+	if opts.Comments {
+		lex.comments = &commentList{}
+	}
+
+	lex.src = source
 	lex.source = strings.NewReader(source)
 
 	lex.line = line
@@ -165,19 +185,23 @@ func newLexer(source string, line int) *lexer {
 
 	lex.token = tknINVALID
 	lex.tokenline = line
+	lex.tokenpos = Position{Line: line, Col: 1}
 
 	lex.strdepth = 0
 	lex.objdepth = 0
 
-	// prime the pump
+	return lex
+}
+
+// prime reads the first few chars and tokens. This is separate from newLexer so that errors in
+// the first tokens are raised after the caller has a reference to the lexer.
+func (lex *lexer) prime() {
 	lex.nextchar()
 	lex.nextchar()
-	lex.exlook = &token{"INVALID", tknINVALID, lex.tokenline}
-	lex.look = &token{"INVALID", tknINVALID, lex.tokenline}
+	lex.exlook = &token{"INVALID", tknINVALID, lex.tokenline, Span{lex.tokenpos, lex.tokenpos}}
+	lex.look = &token{"INVALID", tknINVALID, lex.tokenline, Span{lex.tokenpos, lex.tokenpos}}
 	lex.advance()
 	lex.advance()
-
-	return lex
 }
 
 // advance retrieves the next token from the stream.
@@ -185,18 +209,62 @@ func newLexer(source string, line int) *lexer {
 func (lex *lexer) advance() {
 	lex.current, lex.look = lex.look, lex.exlook
 	if lex.eof {
-		lex.exlook = &token{"EOF", tknINVALID, lex.tokenline}
+		lex.exlook = &token{"EOF", tknINVALID, lex.tokenline, Span{lex.pos(), lex.pos()}}
 		return
 	}
 
-	lex.eatWS()
-	if lex.eof {
-		lex.exlook = &token{"EOF", tknINVALID, lex.tokenline}
-		return
+	for {
+		lex.eatWS()
+		if lex.eof {
+			lex.exlook = &token{"EOF", tknINVALID, lex.tokenline, Span{lex.pos(), lex.pos()}}
+			return
+		}
+
+		// We are at the beginning of a token
+		lex.tokenline = lex.line
+		lex.tokenpos = lex.pos()
+		if lex.errs == nil {
+			lex.lexToken()
+			return
+		}
+		if lex.tryToken() {
+			return
+		}
 	}
+}
+
+// tryToken is lexToken for error recovery mode. If there is an error it is recorded and the rest of the
+// line is skipped. A broken string is replaced with an empty string so the statement it is part of can
+// still be parsed, for anything else false is returned so the caller can try again.
+//
+// Skipping the whole line is a little heavy handed, but most lexer errors are in strings, and trying to
+// lex the rest of a broken string as code results in lots of bogus errors.
+func (lex *lexer) tryToken() (ok bool) {
+	isString := lex.char == '"' || lex.char == '\''
+	defer func() {
+		if x := recover(); x != nil {
+			e, isErr := x.(luautil.Error)
+			if !isErr {
+				panic(x)
+			}
+			lex.errs.add(lex.pos(), e.Msg)
+			lex.lexeme = lex.lexeme[0:0]
+			for !lex.eof && lex.char != '\n' {
+				lex.nextchar()
+			}
+			ok = isString
+			if ok {
+				lex.exlook = &token{"", tknString, lex.tokenline, Span{Start: lex.tokenpos, End: lex.pos()}}
+			}
+		}
+	}()
+
+	lex.lexToken()
+	return true
+}
 
-	// We are at the beginning of a token
-	lex.tokenline = lex.line
+// lexToken reads a single token into exlook. The current char must be the first char of the token.
+func (lex *lexer) lexToken() {
 	switch lex.char {
 	case ';':
 		lex.makeToken(tknUnnecessary)
@@ -311,7 +379,7 @@ func (lex *lexer) advance() {
 			}
 
 			ident := string(lex.lexeme)
-			lex.exlook = &token{ident, keyword(ident), lex.tokenline}
+			lex.exlook = &token{ident, lex.keyword(ident), lex.tokenline, Span{}}
 		} else if lex.matchNumeric() {
 			lex.matchNumber()
 		} else {
@@ -319,9 +387,17 @@ func (lex *lexer) advance() {
 		}
 	}
 
+	// The current char is the first one after the token.
+	lex.exlook.Span = Span{Start: lex.tokenpos, End: lex.pos()}
+
 	lex.lexeme = lex.lexeme[0:0]
 }
 
+// pos returns the position of the current char.
+func (lex *lexer) pos() Position {
+	return Position{Line: lex.line, Col: lex.col, Offset: lex.offset}
+}
+
 // getCurrent gets the next token, and panics with an error if it's not of type tokenType.
 // May cause a panic if the lexer encounters an error.
 // Used as a type checked advance.
@@ -401,7 +477,11 @@ func (lex *lexer) nextchar() {
 		return
 	}
 	if lex.neof {
+		// Move the position one past the end of the source, so tokens that end at EOF get a proper end position.
 		lex.eof = true
+		lex.line = lex.nline
+		lex.col = lex.ncol
+		lex.offset = lex.noffset
 		return
 	}
 
@@ -410,6 +490,18 @@ func (lex *lexer) nextchar() {
 
 	lex.char = lex.nchar
 	lex.line = lex.nline
+	lex.col = lex.ncol
+	lex.offset = lex.noffset
+
+	// Columns are counted in code points starting at 1. A newline is the last char of the line it ends,
+	// so the line count is incremented for the char after it.
+	lex.noffset = int(lex.source.Size()) - lex.source.Len()
+	if lex.char == '\n' {
+		lex.nline = lex.line + 1
+		lex.ncol = 1
+	} else {
+		lex.ncol = lex.col + 1
+	}
 
 	// Read the next char. This does a lot of special stuff to handle all possible types
 	// of line endings (as required by the stupid Lua spec). The only place special handling
@@ -420,7 +512,6 @@ again:
 	if err != nil {
 		if prevNL == '\n' || prevNL == '\r' {
 			lex.nchar = '\n'
-			lex.nline++
 			return
 		}
 		lex.neof = true
@@ -437,7 +528,6 @@ again:
 	if (prevNL == '\n' && lex.nchar == '\r') || (prevNL == '\r' && lex.nchar == '\n') {
 		prevNL = '\000'
 		lex.nchar = '\n'
-		lex.nline++
 		return
 	}
 
@@ -450,7 +540,6 @@ again:
 	// If we found a newline before but the next char was not a newline then unread the next char and go on.
 	if prevNL == '\n' || prevNL == '\r' {
 		lex.nchar = '\n'
-		lex.nline++
 		lex.source.UnreadRune()
 		return
 	}
@@ -465,7 +554,7 @@ func (lex *lexer) addLexeme() {
 
 // Add the current char to the lexeme buffer.
 func (lex *lexer) makeToken(tkn int) {
-	lex.exlook = &token{"", tkn, lex.tokenline}
+	lex.exlook = &token{"", tkn, lex.tokenline, Span{}}
 	lex.nextchar()
 }
 
@@ -473,94 +562,91 @@ func (lex *lexer) makeToken(tkn int) {
 func (lex *lexer) eatWS() {
 	for {
 		if lex.match("-") && lex.nmatch("-") {
+			start := lex.pos()
+			lex.eatComment()
+			if lex.comments != nil {
+				end := lex.pos()
+				lex.comments.add(&Comment{Text: lex.src[start.Offset:end.Offset], Sp: Span{Start: start, End: end}})
+			}
+			if lex.eof {
+				return
+			}
+			continue
+		}
+		if lex.match("\n\r \t") {
 			lex.nextchar()
+			if lex.eof {
+				return
+			}
+			continue
+		}
+		break
+	}
+}
+
+// Eat a single comment. Line comments end just before the newline.
+func (lex *lexer) eatComment() {
+	lex.nextchar()
+	lex.nextchar()
+	if lex.eof {
+		return
+	}
+
+	// Is long comment?
+	if lex.match("[") && lex.nmatch("[=") {
+		i := 0
+		lex.nextchar()
+		if lex.eof {
+			return
+		}
+		for lex.match("=") {
+			i++
 			lex.nextchar()
 			if lex.eof {
 				return
 			}
+		}
+		lex.nextchar()
+		if lex.eof {
+			return
+		}
 
-			// Is long comment?
-			if lex.match("[") && lex.nmatch("[=") {
-				i := 0
-				lex.nextchar()
-				if lex.eof {
-					return
-				}
-				for lex.match("=") {
-					i++
-					lex.nextchar()
-					if lex.eof {
-						return
-					}
-				}
+	nextcchar:
+		for {
+			if lex.match("]") && lex.nmatch("=]") {
+				// Make sure the closing long bracket is the same level as the opener
 				lex.nextchar()
 				if lex.eof {
 					return
 				}
 
-			nextcchar:
-				for {
-					if lex.match("]") && lex.nmatch("=]") {
-						// Make sure the closing long bracket is the same level as the opener
-						lex.nextchar()
-						if lex.eof {
-							return
-						}
-
-						if i > 0 {
-							for k := 0; k < i; k++ {
-								if !lex.match("=") {
-									continue nextcchar
-								}
-								lex.nextchar()
-								if lex.eof {
-									return
-								}
-							}
-						}
-
-						if !lex.match("]") {
-							continue
+				if i > 0 {
+					for k := 0; k < i; k++ {
+						if !lex.match("=") {
+							continue nextcchar
 						}
 						lex.nextchar()
 						if lex.eof {
 							return
 						}
-						break
-					}
-					lex.nextchar()
-					if lex.eof {
-						return
 					}
 				}
-				continue
-			}
 
-			for {
-				if lex.match("\n") {
-					lex.nextchar()
-					if lex.eof {
-						return
-					}
-					break
+				if !lex.match("]") {
+					continue
 				}
 				lex.nextchar()
-				if lex.eof {
-					return
-				}
+				return
 			}
-		}
-		if lex.match("\n\r \t") {
 			lex.nextchar()
 			if lex.eof {
 				return
 			}
-			continue
-		}
-		if lex.match("-") && lex.nmatch("-") {
-			continue
 		}
-		break
+	}
+
+	for !lex.eof && !lex.match("\n") {
+		lex.nextchar()
 	}
 }
 
@@ -611,10 +697,10 @@ func (lex *lexer) matchNumber() {
 		luautil.Raise("Invalid numeric literal", luautil.ErrTypGenLexer)
 	}
 	if iok {
-		lex.exlook = &token{n, tknInt, lex.tokenline}
+		lex.exlook = &token{n, tknInt, lex.tokenline, Span{}}
 		return
 	}
-	lex.exlook = &token{n, tknFloat, lex.tokenline}
+	lex.exlook = &token{n, tknFloat, lex.tokenline, Span{}}
 }
 
 func hexval(r rune) byte {
@@ -643,7 +729,7 @@ func (lex *lexer) matchString(delim rune) {
 		luautil.Raise("Unexpected EOF while reading a string", luautil.ErrTypGenLexer)
 	}
 	if lex.char == delim {
-		lex.exlook = &token{"", tknString, lex.tokenline}
+		lex.exlook = &token{"", tknString, lex.tokenline, Span{}}
 		lex.nextchar()
 		return
 	}
@@ -685,8 +771,6 @@ func (lex *lexer) matchString(delim rune) {
 				strbytes = appendRune(strbytes, '\'')
 			case '\\':
 				strbytes = appendRune(strbytes, '\\')
-			case '0':
-				strbytes = appendRune(strbytes, '\000')
 			case 'z':
 				for lex.match("\n\r \t") {
 					lex.nextchar()
@@ -731,9 +815,9 @@ func (lex *lexer) matchString(delim rune) {
 				strbytes = appendRune(strbytes, r)
 			default:
 				if lex.matchNumeric() {
-					r := byte('\000')
+					r := 0
 					for i := 0; i < 3 && lex.matchNumeric(); i++ {
-						r = 10*r + byte(lex.char) - '0'
+						r = 10*r + int(lex.char-'0')
 
 						lex.nextchar()
 						if lex.eof {
@@ -743,7 +827,8 @@ func (lex *lexer) matchString(delim rune) {
 					if r > 0xFF {
 						luautil.Raise("Decimal escape value is too large", luautil.ErrTypGenLexer)
 					}
-					strbytes = append(strbytes, r)
+					strbytes = append(strbytes, byte(r))
+					continue // Already at the char after the escape.
 				} else {
 					luautil.Raise("Invalid escape sequence while reading a string", luautil.ErrTypGenLexer)
 				}
@@ -757,7 +842,7 @@ func (lex *lexer) matchString(delim rune) {
 		lex.nextchar()
 	}
 	lex.nextchar()
-	lex.exlook = &token{string(strbytes), tknString, lex.tokenline}
+	lex.exlook = &token{string(strbytes), tknString, lex.tokenline, Span{}}
 	return
 }
 
@@ -818,7 +903,7 @@ next:
 		lex.addLexeme()
 		lex.nextchar()
 	}
-	lex.exlook = &token{string(lex.lexeme), tknString, lex.tokenline}
+	lex.exlook = &token{string(lex.lexeme), tknString, lex.tokenline, Span{}}
 }
 
 // Token
@@ -827,6 +912,7 @@ type token struct {
 	Lexeme string
 	Type   int
 	Line   int
+	Span   Span // Set by advance once the whole token is read.
 }
 
 func (t *token) String() string {
@@ -908,12 +994,14 @@ func tokenTypeToString(typ int) string {
 }
 
 // Panics with a message formatted like one of the following:
+//
 //	Invalid token: Found: thecurrenttoken. Expected: expected1, expected2, or expected3.
 //	Invalid token: Found: thecurrenttoken. Expected: expected1 or expected2.
 //	Invalid token: Found: thecurrenttoken. Expected: expected.
 //	Invalid token: Found: thecurrenttoken (Lexeme: test). Expected: expected1, expected2, or expected3.
 //	Invalid token: Found: thecurrenttoken (Lexeme: test). Expected: expected1 or expected2.
 //	Invalid token: Found: thecurrenttoken (Lexeme: test). Expected: expected.
+//
 // If the lexeme is long (>20 chars) it is truncated.
 func exitOnTokenExpected(token *token, expected ...int) {
 	expectedString := ""
@@ -949,5 +1037,15 @@ func exitOnTokenExpected(token *token, expected ...int) {
 			found += " (Lexeme: " + token.Lexeme[:17] + "...)"
 		}
 	}
-	luautil.Raise("Invalid token: Found: "+found+" Expected: "+expectedString, luautil.ErrTypGenSyntax)
+	panic(posError{
+		Error: luautil.Error{Msg: "Invalid token: Found: " + found + " Expected: " + expectedString, Type: luautil.ErrTypGenSyntax},
+		pos:   token.Span.Start,
+	})
+}
+
+// posError is a syntax error that has a known position. Errors raised without a position are assumed to
+// be at the lexer's current position.
+type posError struct {
+	luautil.Error
+	pos Position
 }
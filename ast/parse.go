@@ -31,10 +31,41 @@ type parser struct {
 	l *lexer
 }
 
+// ParseOptions controls optional language extensions. The zero value parses standard Lua 5.3.
+type ParseOptions struct {
+	// Continue enables the "continue" keyword. If false "continue" is an ordinary identifier, exactly
+	// like in standard Lua.
+	Continue bool
+
+	// Recover enables error recovery. Instead of stopping at the first syntax error the parser skips
+	// ahead to the start of the next statement (or the end of the current block) and keeps going.
+	// Statements that contain errors are left out of the returned AST, and the returned error is an
+	// ErrorList with every error found.
+	Recover bool
+
+	// Comments enables reading comments. Comments are attached to the nearest statement, see Comments for
+	// the details.
+	Comments bool
+}
+
 // Parse reads Lua source into an AST using the types in this package.
-func Parse(source string, line int) (block []Stmt, err error) {
+//
+// The options only apply to this one chunk. If more than one set of options is given only the first
+// is used.
+//
+// Syntax errors include the line and column where the problem was found. If error recovery is enabled
+// the partial AST is returned even if there are errors.
+func Parse(source string, line int, opts ...ParseOptions) (block []Stmt, err error) {
+	o := ParseOptions{}
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
 	p := &parser{
-		l: newLexer(source, line),
+		l: newLexer(source, line, o),
+	}
+	if o.Recover {
+		p.l.errs = &ErrorList{}
 	}
 
 	defer func() {
@@ -45,8 +76,12 @@ func Parse(source string, line int) (block []Stmt, err error) {
 			//fmt.Printf("%s\n", buf)
 
 			switch e := x.(type) {
+			case posError:
+				e.Msg = fmt.Sprintf("%v On Line: %v Column: %v", e.Msg, e.pos.Line, e.pos.Col)
+				err = e.Error
 			case luautil.Error:
-				e.Msg = fmt.Sprintf("%v On Line: %v", e.Msg, p.l.tokenline)
+				// Errors without a position come from the lexer, so the current char is the problem.
+				e.Msg = fmt.Sprintf("%v On Line: %v Column: %v", e.Msg, p.l.line, p.l.col)
 				err = e
 			case error:
 				err = &luautil.Error{Err: e, Type: luautil.ErrTypWrapped}
@@ -56,13 +91,91 @@ func Parse(source string, line int) (block []Stmt, err error) {
 		}
 	}()
 
+	p.l.prime()
 	for !p.l.checkLook(tknINVALID) {
-		block = append(block, p.statement())
+		block = p.appendStatement(block)
+	}
+	p.blockComments(block, Position{}, p.l.look.Span.End)
+	if p.l.errs != nil && len(*p.l.errs) > 0 {
+		return block, *p.l.errs
 	}
 	return block, nil
 }
 
+// Statements that may begin a new statement or end a block. When recovering from an error the parser
+// skips tokens until it finds one of these.
+var syncTokens = []int{
+	tknLocal, tknFunction, tknReturn, tknIf, tknWhile, tknFor, tknRepeat, tknDo, tknGoto, tknBreak, tknContinue,
+	tknDblColon, tknUnnecessary,
+	tknEnd, tknElse, tknElseif, tknUntil,
+	tknINVALID,
+}
+
+// appendStatement parses a statement and appends it to the block. In error recovery mode statements
+// with errors are recorded and skipped.
+func (p *parser) appendStatement(block []Stmt) (rtn []Stmt) {
+	if p.l.errs == nil {
+		return append(block, p.statement())
+	}
+
+	defer func() {
+		if x := recover(); x != nil {
+			switch e := x.(type) {
+			case posError:
+				p.l.errs.add(e.pos, e.Msg)
+			case luautil.Error:
+				p.l.errs.add(p.l.pos(), e.Msg)
+			default:
+				panic(x)
+			}
+
+			// Most statements start on a new line, so a name at the start of a line is probably the start of
+			// the next statement.
+			for !p.l.checkLook(syncTokens...) {
+				if p.l.look.Type == tknName && p.l.look.Line > p.l.current.Line {
+					break
+				}
+				p.l.advance()
+			}
+			rtn = block
+		}
+	}()
+
+	return append(block, p.statement())
+}
+
+// start returns the position of the first char of the look ahead token.
+func (p *parser) start() Position {
+	return p.l.look.Span.Start
+}
+
+// stmtSpan sets the Span of a Stmt to run from start to the end of the last token read.
+// Any comments inside the Stmt that are not already attached to a nested Stmt are attached to this one.
+func (p *parser) stmtSpan(n Stmt, start Position) Stmt {
+	n.setSpan(Span{Start: start, End: p.l.current.Span.End})
+	if p.l.comments != nil {
+		if inner := p.l.comments.take(start, n.Span().End); len(inner) > 0 {
+			comments(n).Inner = inner
+		}
+	}
+	return n
+}
+
+// exprSpan sets the Span of a Expr to run from start to the end of the last token read.
+func (p *parser) exprSpan(n Expr, start Position) Expr {
+	n.setSpan(Span{Start: start, End: p.l.current.Span.End})
+	return n
+}
+
+// tokenExpr attaches line and span information from the current token to a Expr and returns the Expr.
+func (p *parser) tokenExpr(n Expr) Expr {
+	n.setLine(p.l.current.Line)
+	n.setSpan(p.l.current.Span)
+	return n
+}
+
 func (p *parser) funcDeclStat(local bool) Stmt {
+	start := p.start()
 	p.l.getCurrent(tknFunction)
 
 	// Function declarations are exploded into an explicit assignment statement.
@@ -77,9 +190,9 @@ func (p *parser) funcDeclStat(local bool) Stmt {
 	hasSelf := false
 	if local {
 		p.l.getCurrent(tknName)
-		ident = exprLine(&ConstIdent{
+		ident = p.tokenExpr(&ConstIdent{
 			Value: p.l.current.Lexeme,
-		}, p.l.current.Line)
+		})
 	} else {
 		ident = p.ident()
 		if p.l.checkLook(tknColon) {
@@ -87,36 +200,39 @@ func (p *parser) funcDeclStat(local bool) Stmt {
 			p.l.getCurrent(tknColon)
 			line := p.l.current.Line
 			p.l.getCurrent(tknName)
-			ident = exprLine(&TableAccessor{
+			ident = p.exprSpan(exprLine(&TableAccessor{
 				Obj: ident,
-				Key: exprLine(&ConstString{
+				Key: p.tokenExpr(&ConstString{
 					Value: p.l.current.Lexeme,
-				}, p.l.current.Line),
-			}, line)
+				}),
+			}, line), ident.Span().Start)
 		}
 	}
 	node.(*Assign).Targets[0] = ident
 
 	// Read Parameters and Block
-	node.(*Assign).Values[0] = p.funcDeclBody(hasSelf)
-	return node
+	node.(*Assign).Values[0] = p.funcDeclBody(hasSelf, start)
+	return p.stmtSpan(node, start)
 }
 
 // The block opener must have already been read
 func (p *parser) block(enders ...int) []Stmt {
+	start := p.l.current.Span.End
 	rtn := []Stmt{}
 	for !p.l.checkLook(append(enders, tknINVALID)...) {
-		rtn = append(rtn, p.statement())
+		rtn = p.appendStatement(rtn)
 	}
+	p.blockComments(rtn, start, p.l.look.Span.Start)
 	p.l.getCurrent(enders...)
 	return rtn
 }
 
 func (p *parser) statement() Stmt {
+	start := p.start()
 	switch p.l.look.Type {
 	case tknUnnecessary: // ;
 		p.l.getCurrent(tknUnnecessary)
-		return stmtLine(&DoBlock{Block: nil}, p.l.current.Line) // FIXME!
+		return p.stmtSpan(stmtLine(&DoBlock{Block: nil}, p.l.current.Line), start) // FIXME!
 	case tknIf:
 		p.l.getCurrent(tknIf)
 		line := p.l.current.Line
@@ -126,6 +242,9 @@ func (p *parser) statement() Stmt {
 		rnode := node
 		p.l.getCurrent(tknThen)
 		node.(*If).Then = p.block(tknElse, tknElseif, tknEnd)
+
+		// Nested if statements created for elseif clauses run from their elseif to the end of the whole statement.
+		nested := []Stmt{node}
 	loop:
 		for {
 			switch p.l.current.Type {
@@ -134,10 +253,13 @@ func (p *parser) statement() Stmt {
 				break loop
 			case tknElseif:
 				line := p.l.current.Line
+				estart := p.l.current.Span.Start
 				pnode := node
 				node = stmtLine(&If{
 					Cond: p.expression(),
 				}, line)
+				node.setSpan(Span{Start: estart})
+				nested = append(nested, node)
 
 				p.l.getCurrent(tknThen)
 
@@ -150,21 +272,25 @@ func (p *parser) statement() Stmt {
 				panic("IMPOSSIBLE")
 			}
 		}
+		p.stmtSpan(rnode, start)
+		for _, n := range nested[1:] {
+			p.stmtSpan(n, n.Span().Start)
+		}
 		return rnode
 	case tknWhile:
 		p.l.getCurrent(tknWhile)
 		line := p.l.current.Line
 		cond := p.expression()
 		p.l.getCurrent(tknDo)
-		return stmtLine(&WhileLoop{
+		return p.stmtSpan(stmtLine(&WhileLoop{
 			Cond:  cond,
 			Block: p.block(tknEnd),
-		}, line)
+		}, line), start)
 	case tknDo:
 		p.l.getCurrent(tknDo)
 		line := p.l.current.Line
 		rtn := p.block(tknEnd)
-		return stmtLine(&DoBlock{Block: rtn}, line)
+		return p.stmtSpan(stmtLine(&DoBlock{Block: rtn}, line), start)
 	case tknFor:
 		p.l.getCurrent(tknFor)
 		line := p.l.current.Line
@@ -190,7 +316,9 @@ func (p *parser) statement() Stmt {
 				p.l.getCurrent(tknSeperator)
 				s = p.expression()
 			} else {
+				// The implied step has no source, so it gets an empty span at the end of the limit.
 				s = exprLine(&ConstInt{Value: "1"}, p.l.current.Line)
+				s.setSpan(Span{Start: p.l.current.Span.End, End: p.l.current.Span.End})
 			}
 		} else {
 			for {
@@ -212,27 +340,27 @@ func (p *parser) statement() Stmt {
 		}
 		p.l.getCurrent(tknDo)
 		if numeric {
-			return stmtLine(&ForLoopNumeric{
+			return p.stmtSpan(stmtLine(&ForLoopNumeric{
 				Counter: counter,
 				Init:    i,
 				Limit:   l,
 				Step:    s,
 				Block:   p.block(tknEnd),
-			}, line)
+			}, line), start)
 		}
-		return stmtLine(&ForLoopGeneric{
+		return p.stmtSpan(stmtLine(&ForLoopGeneric{
 			Locals: locals,
 			Init:   init,
 			Block:  p.block(tknEnd),
-		}, line)
+		}, line), start)
 	case tknRepeat:
 		p.l.getCurrent(tknRepeat)
 		line := p.l.current.Line
 		blk := p.block(tknUntil)
-		return stmtLine(&RepeatUntilLoop{
+		return p.stmtSpan(stmtLine(&RepeatUntilLoop{
 			Cond:  p.expression(),
 			Block: blk,
-		}, line)
+		}, line), start)
 	case tknFunction:
 		return p.funcDeclStat(false)
 	case tknLocal:
@@ -241,16 +369,17 @@ func (p *parser) statement() Stmt {
 		if p.l.checkLook(tknFunction) {
 			// This is incorrect, "local function f" should translate to "local f; f = function" not "local f = function".
 			// The compiler has some special case code to correct this.
-			return p.funcDeclStat(true)
+			n := p.funcDeclStat(true)
+			return p.stmtSpan(n, start)
 		}
 		targets := []Expr{}
 		c := 0
 		for !p.l.checkLook(tknSet) {
 			c++
 			p.l.getCurrent(tknName)
-			targets = append(targets, exprLine(&ConstIdent{
+			targets = append(targets, p.tokenExpr(&ConstIdent{
 				Value: p.l.current.Lexeme,
-			}, p.l.current.Line))
+			}))
 			if !p.l.checkLook(tknSeperator) {
 				break
 			}
@@ -266,18 +395,18 @@ func (p *parser) statement() Stmt {
 				vals = append(vals, p.expression())
 			}
 		}
-		return stmtLine(&Assign{
+		return p.stmtSpan(stmtLine(&Assign{
 			LocalDecl: true,
 			Targets:   targets,
 			Values:    vals,
-		}, line)
+		}, line), start)
 	case tknDblColon:
 		p.l.getCurrent(tknDblColon)
 		line := p.l.current.Line
 		p.l.getCurrent(tknName)
 		lbl := p.l.current.Lexeme
 		p.l.getCurrent(tknDblColon)
-		return stmtLine(&Label{Label: lbl}, line)
+		return p.stmtSpan(stmtLine(&Label{Label: lbl}, line), start)
 	case tknReturn:
 		p.l.getCurrent(tknReturn)
 		line := p.l.current.Line
@@ -289,19 +418,19 @@ func (p *parser) statement() Stmt {
 			}
 			p.l.getCurrent(tknSeperator)
 		}
-		return stmtLine(&Return{Items: items}, line)
+		return p.stmtSpan(stmtLine(&Return{Items: items}, line), start)
 	case tknBreak:
 		p.l.getCurrent(tknBreak)
-		return stmtLine(&Goto{Label: "break", IsBreak: true}, p.l.current.Line)
+		return p.stmtSpan(stmtLine(&Goto{Label: "break", IsBreak: true}, p.l.current.Line), start)
 	case tknContinue:
-		// The lexer will never generate this unless you uncomment the definition for the "continue" keyword.
+		// The lexer will never generate this unless the "continue" keyword is enabled in the ParseOptions.
 		p.l.getCurrent(tknContinue)
-		return stmtLine(&Goto{Label: "continue", IsBreak: true}, p.l.current.Line)
+		return p.stmtSpan(stmtLine(&Goto{Label: "continue", IsBreak: true}, p.l.current.Line), start)
 	case tknGoto:
 		p.l.getCurrent(tknGoto)
 		line := p.l.current.Line
 		p.l.getCurrent(tknName)
-		return stmtLine(&Goto{Label: p.l.current.Lexeme}, line)
+		return p.stmtSpan(stmtLine(&Goto{Label: p.l.current.Lexeme}, line), start)
 	default:
 		ident := p.suffixedValue()
 		line := p.l.current.Line
@@ -320,10 +449,9 @@ func (p *parser) statement() Stmt {
 			p.l.getCurrent(tknSeperator)
 			vals = append(vals, p.expression())
 		}
-		return stmtLine(&Assign{
+		return p.stmtSpan(stmtLine(&Assign{
 			Targets: targets,
 			Values:  vals,
-		}, line)
+		}, line), start)
 	}
-	panic("UNREACHABLE")
 }
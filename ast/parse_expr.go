@@ -25,10 +25,11 @@ package ast
 // Read a sequence of identifiers and indexing operations.
 // If the ident chain ends with a :ident part this does not read it.
 func (p *parser) ident() Expr {
+	start := p.start()
 	p.l.getCurrent(tknName)
-	ident := exprLine(&ConstIdent{
+	ident := p.tokenExpr(&ConstIdent{
 		Value: p.l.current.Lexeme,
-	}, p.l.current.Line)
+	})
 
 	for p.l.checkLook(tknOIndex, tknDot) {
 		switch p.l.look.Type {
@@ -42,16 +43,17 @@ func (p *parser) ident() Expr {
 			}, line)
 
 			p.l.getCurrent(tknCIndex)
+			p.exprSpan(ident, start)
 		case tknDot: // .ident
 			p.l.getCurrent(tknDot)
 			line := p.l.current.Line
 			p.l.getCurrent(tknName)
-			ident = exprLine(&TableAccessor{
+			ident = p.exprSpan(exprLine(&TableAccessor{
 				Obj: ident,
-				Key: exprLine(&ConstString{
+				Key: p.tokenExpr(&ConstString{
 					Value: p.l.current.Lexeme,
-				}, p.l.current.Line),
-			}, line)
+				}),
+			}, line), start)
 		default:
 			panic("IMPOSSIBLE")
 		}
@@ -67,9 +69,9 @@ func (p *parser) funcCall(ident Expr) Expr {
 		p.l.getCurrent(tknColon)
 		p.l.getCurrent(tknName)
 		r = ident
-		f = exprLine(&ConstString{
+		f = p.tokenExpr(&ConstString{
 			Value: p.l.current.Lexeme,
-		}, p.l.current.Line)
+		})
 	} else {
 		f = ident
 	}
@@ -80,9 +82,9 @@ func (p *parser) funcCall(ident Expr) Expr {
 		args = append(args, p.tblConstruct())
 	case tknString:
 		p.l.getCurrent(tknString)
-		args = append(args, exprLine(&ConstString{
+		args = append(args, p.tokenExpr(&ConstString{
 			Value: p.l.current.Lexeme,
-		}, p.l.current.Line))
+		}))
 	case tknOParen:
 		p.l.getCurrent(tknOParen)
 		for !p.l.checkLook(tknCParen) {
@@ -97,14 +99,15 @@ func (p *parser) funcCall(ident Expr) Expr {
 		p.l.getCurrent(tknOBracket, tknString, tknOParen) // For the error message
 	}
 
-	return exprLine(&FuncCall{
+	return p.exprSpan(exprLine(&FuncCall{
 		Receiver: r,
 		Function: f,
 		Args:     args,
-	}, line)
+	}, line), ident.Span().Start)
 }
 
-func (p *parser) funcDeclBody(hasSelf bool) Expr {
+// funcDeclBody reads a function's parameters and block. start should be the position of the "function" keyword.
+func (p *parser) funcDeclBody(hasSelf bool, start Position) Expr {
 	// Read Parameters
 	p.l.getCurrent(tknOParen)
 	line := p.l.current.Line
@@ -135,16 +138,17 @@ func (p *parser) funcDeclBody(hasSelf bool) Expr {
 	// Read Block
 	block := p.block(tknEnd)
 
-	return exprLine(&FuncDecl{
+	return p.exprSpan(exprLine(&FuncDecl{
 		Params:     params,
 		IsVariadic: variadic,
 		Block:      block,
-	}, line)
+	}, line), start)
 }
 
 func (p *parser) tblConstruct() Expr {
 	vals, keys := []Expr{}, []Expr{}
 
+	start := p.start()
 	p.l.getCurrent(tknOBracket)
 	line := p.l.current.Line
 
@@ -156,7 +160,7 @@ func (p *parser) tblConstruct() Expr {
 				break
 			}
 			p.l.getCurrent(tknName)
-			keys = append(keys, exprLine(&ConstString{Value: p.l.current.Lexeme}, p.l.current.Line))
+			keys = append(keys, p.tokenExpr(&ConstString{Value: p.l.current.Lexeme}))
 			p.l.getCurrent(tknSet)
 		case tknOIndex:
 			p.l.getCurrent(tknOIndex)
@@ -176,10 +180,10 @@ func (p *parser) tblConstruct() Expr {
 
 	p.l.getCurrent(tknCBracket)
 
-	return exprLine(&TableConstructor{
+	return p.exprSpan(exprLine(&TableConstructor{
 		Keys: keys,
 		Vals: vals,
-	}, line)
+	}, line), start)
 }
 
 func (p *parser) expression() Expr {
@@ -281,11 +285,12 @@ var tknToUnOp = map[int]opTyp{
 func (p *parser) subexpr(limit int) Expr {
 	// Grab the starting left hand side of the expression
 	var e1 Expr
+	start := p.start()
 	op, ok := tknToUnOp[p.l.look.Type]
 	if ok {
 		p.l.advance()
 		line := p.l.current.Line
-		e1 = exprLine(&Operator{Op: op, Right: p.subexpr(12)}, line)
+		e1 = p.exprSpan(exprLine(&Operator{Op: op, Right: p.subexpr(12)}, line), start)
 	} else {
 		e1 = p.value()
 	}
@@ -296,7 +301,7 @@ func (p *parser) subexpr(limit int) Expr {
 	for ok && priorities[op].left > limit {
 		p.l.advance()
 		line := p.l.current.Line
-		e1 = exprLine(&Operator{Op: op, Left: e1, Right: p.subexpr(priorities[op].right)}, line)
+		e1 = p.exprSpan(exprLine(&Operator{Op: op, Left: e1, Right: p.subexpr(priorities[op].right)}, line), start)
 
 		op, ok = tknToBinOp[p.l.look.Type]
 	}
@@ -495,29 +500,30 @@ func (p *parser) value() Expr {
 	case tknOBracket:
 		return p.tblConstruct()
 	case tknFunction:
+		start := p.start()
 		p.l.getCurrent(tknFunction)
-		return p.funcDeclBody(false)
+		return p.funcDeclBody(false, start)
 	case tknTrue:
 		p.l.getCurrent(tknTrue)
-		return exprLine(&ConstBool{Value: true}, p.l.current.Line)
+		return p.tokenExpr(&ConstBool{Value: true})
 	case tknFalse:
 		p.l.getCurrent(tknFalse)
-		return exprLine(&ConstBool{Value: false}, p.l.current.Line)
+		return p.tokenExpr(&ConstBool{Value: false})
 	case tknNil:
 		p.l.getCurrent(tknNil)
-		return exprLine(&ConstNil{}, p.l.current.Line)
+		return p.tokenExpr(&ConstNil{})
 	case tknVariadic:
 		p.l.getCurrent(tknVariadic)
-		return exprLine(&ConstVariadic{}, p.l.current.Line)
+		return p.tokenExpr(&ConstVariadic{})
 	case tknInt:
 		p.l.getCurrent(tknInt)
-		return exprLine(&ConstInt{Value: p.l.current.Lexeme}, p.l.current.Line)
+		return p.tokenExpr(&ConstInt{Value: p.l.current.Lexeme})
 	case tknFloat:
 		p.l.getCurrent(tknFloat)
-		return exprLine(&ConstFloat{Value: p.l.current.Lexeme}, p.l.current.Line)
+		return p.tokenExpr(&ConstFloat{Value: p.l.current.Lexeme})
 	case tknString:
 		p.l.getCurrent(tknString)
-		return exprLine(&ConstString{Value: p.l.current.Lexeme}, p.l.current.Line)
+		return p.tokenExpr(&ConstString{Value: p.l.current.Lexeme})
 	default:
 		return p.suffixedValue()
 	}
@@ -525,6 +531,7 @@ func (p *parser) value() Expr {
 
 // suffixedValue -> primaryValue { '.' ident | '[' exp ']' | ':' ident funcargs | funcargs }
 func (p *parser) suffixedValue() Expr {
+	start := p.start()
 	l := p.primaryValue()
 	for p.l.checkLook(tknOIndex, tknDot, tknColon, tknOParen, tknString, tknOBracket) {
 		switch p.l.look.Type {
@@ -538,24 +545,25 @@ func (p *parser) suffixedValue() Expr {
 			}, line)
 
 			p.l.getCurrent(tknCIndex)
+			p.exprSpan(l, start)
 		case tknDot: // .ident or .ident() or .ident:ident()
 			p.l.getCurrent(tknDot)
 			line := p.l.current.Line
 			p.l.getCurrent(tknName)
 			if p.l.checkLook(tknColon, tknOParen) {
-				l = p.funcCall(exprLine(&TableAccessor{
+				l = p.funcCall(p.exprSpan(exprLine(&TableAccessor{
 					Obj: l,
-					Key: exprLine(&ConstString{
+					Key: p.tokenExpr(&ConstString{
 						Value: p.l.current.Lexeme,
-					}, p.l.current.Line),
-				}, line))
+					}),
+				}, line), start))
 			} else {
-				l = exprLine(&TableAccessor{
+				l = p.exprSpan(exprLine(&TableAccessor{
 					Obj: l,
-					Key: exprLine(&ConstString{
+					Key: p.tokenExpr(&ConstString{
 						Value: p.l.current.Lexeme,
-					}, p.l.current.Line),
-				}, line)
+					}),
+				}, line), start)
 			}
 		case tknColon, tknOParen, tknString, tknOBracket:
 			l = p.funcCall(l)
@@ -569,10 +577,11 @@ func (p *parser) primaryValue() Expr {
 	switch p.l.look.Type {
 	case tknName:
 		p.l.getCurrent(tknName)
-		return exprLine(&ConstIdent{
+		return p.tokenExpr(&ConstIdent{
 			Value: p.l.current.Lexeme,
-		}, p.l.current.Line)
+		})
 	case tknOParen:
+		start := p.start()
 		p.l.getCurrent(tknOParen)
 
 		line := p.l.current.Line
@@ -581,7 +590,7 @@ func (p *parser) primaryValue() Expr {
 		}, line)
 
 		p.l.getCurrent(tknCParen)
-		return l
+		return p.exprSpan(l, start)
 	default:
 		p.l.getCurrent(tknName, tknOParen)
 		panic("UNREACHABLE")
@@ -0,0 +1,194 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "testing"
+import "strings"
+import "fmt"
+
+func TestSpans(t *testing.T) {
+	src := "local x = 1 + foo.bar(2, \"é\")\r\nif x then\n\treturn x\nelseif y then return 2 end"
+	block, err := Parse(src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every node must cover exactly the source text it was parsed from.
+	expect := []string{
+		"local x = 1 + foo.bar(2, \"é\")",
+		"x",
+		"1 + foo.bar(2, \"é\")",
+		"1",
+		"foo.bar(2, \"é\")",
+		"foo.bar",
+		"foo",
+		"bar",
+		"2",
+		"\"é\"",
+		"if x then\n\treturn x\nelseif y then return 2 end",
+		"x",
+		"return x",
+		"x",
+		"elseif y then return 2 end",
+		"y",
+		"return 2",
+		"2",
+	}
+	found := []string{}
+	for _, s := range block {
+		Inspect(s, func(n Node) bool {
+			if n != nil {
+				sp := n.Span()
+				found = append(found, src[sp.Start.Offset:sp.End.Offset])
+			}
+			return true
+		})
+	}
+	if strings.Join(found, "|") != strings.Join(expect, "|") {
+		t.Errorf("Incorrect spans:\n%q\nExpected:\n%q", found, expect)
+	}
+
+	// Columns are counted in code points, and CRLF line endings count as a single newline.
+	sp := block[1].(*If).Then[0].Span()
+	if sp.String() != "3:2-3:10" {
+		t.Errorf("Incorrect position for return statement: %v", sp)
+	}
+	sp = block[0].(*Assign).Values[0].Span()
+	if sp.String() != "1:11-1:30" {
+		t.Errorf("Incorrect position for operator: %v", sp)
+	}
+}
+
+func TestErrorColumns(t *testing.T) {
+	_, err := Parse("local x = \n  (1 + )", 1)
+	if err == nil || !strings.HasSuffix(err.Error(), "On Line: 2 Column: 8") {
+		t.Errorf("Incorrect syntax error: %v", err)
+	}
+
+	_, err = Parse("local x = $", 1)
+	if err == nil || !strings.HasSuffix(err.Error(), "On Line: 1 Column: 11") {
+		t.Errorf("Incorrect lexer error: %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	src := `local x = = 1
+print(x)
+function f()
+	local y = (1 +
+	return 5
+end
+end end
+local z = "\q"
+z = 2
+`
+	block, err := Parse(src, 1, ParseOptions{Recover: true})
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Expected an ErrorList, got: %v", err)
+	}
+
+	lines := []int{}
+	for _, e := range errs {
+		lines = append(lines, e.Pos.Line)
+	}
+	if fmt.Sprint(lines) != "[1 5 7 8]" {
+		t.Errorf("Incorrect error lines: %v\n%v", lines, errs)
+	}
+
+	// The statements without errors should still be in the AST.
+	if len(block) != 4 {
+		t.Fatalf("Incorrect statement count: %v", len(block))
+	}
+	if _, ok := block[0].(*FuncCall); !ok {
+		t.Errorf("Expected a function call, got: %T", block[0])
+	}
+	if _, ok := block[1].(*Assign); !ok {
+		t.Errorf("Expected a function declaration, got: %T", block[1])
+	}
+	if block[2].Line() != 8 || block[3].Line() != 9 {
+		t.Errorf("Incorrect lines for last statements: %v %v", block[2].Line(), block[3].Line())
+	}
+
+	// Without recovery only the first error is reported.
+	_, err = Parse(src, 1)
+	if _, ok := err.(ErrorList); ok || err == nil {
+		t.Errorf("Incorrect error without recovery: %v", err)
+	}
+}
+
+func TestComments(t *testing.T) {
+	src := `--- Adds two numbers.
+-- Returns the sum.
+local function add(a, b) -- header
+	return a + b -- sum
+	-- end of body
+end
+
+-- Not attached to add.
+x = { -- table
+	1,
+}
+if x then
+	-- empty
+end
+`
+	block, err := Parse(src, 1, ParseOptions{Comments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := func(cs []*Comment) string {
+		rtn := []string{}
+		for _, c := range cs {
+			rtn = append(rtn, c.Text)
+		}
+		return strings.Join(rtn, "|")
+	}
+
+	c := block[0].Comments()
+	if c.Doc() != "Adds two numbers.\nReturns the sum." {
+		t.Errorf("Incorrect doc comment: %q", c.Doc())
+	}
+	if text(c.Inner) != "-- header" {
+		t.Errorf("Incorrect inner comments: %q", text(c.Inner))
+	}
+	c = block[0].(*Assign).Values[0].(*FuncDecl).Block[0].Comments()
+	if text(c.Trailing) != "-- sum|-- end of body" {
+		t.Errorf("Incorrect trailing comments: %q", text(c.Trailing))
+	}
+	c = block[1].Comments()
+	if text(c.Leading) != "-- Not attached to add." || text(c.Inner) != "-- table" || c.Doc() != "" {
+		t.Errorf("Incorrect comments for table: %q %q %q", text(c.Leading), text(c.Inner), c.Doc())
+	}
+	c = block[2].Comments()
+	if text(c.Inner) != "-- empty" {
+		t.Errorf("Incorrect comments for if: %q", text(c.Inner))
+	}
+
+	// Comments are only read if they were asked for.
+	block, _ = Parse(src, 1)
+	if block[0].Comments() != nil {
+		t.Error("Comments attached without being enabled")
+	}
+}
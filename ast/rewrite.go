@@ -0,0 +1,333 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+// Rewrite traverses an AST in the same order as Walk, calling pre for each Node before its children and post
+// after them. Either function may be nil. The Cursor passed to pre and post may be used to replace the current
+// Node, or (if it is part of a block or expression list) to delete it or insert new Nodes around it.
+//
+// If pre returns false the Node's children are not traversed and post is not called for it. If post returns
+// false the traversal stops. Nodes that pre replaces have their children traversed instead of the old Node's
+// children, deleted Nodes are not traversed further, and inserted Nodes are never traversed.
+//
+// Rewrite returns the root Node, which will be different from n if n was replaced.
+func Rewrite(n Node, pre, post func(*Cursor) bool) (result Node) {
+	r := &rewriter{pre: pre, post: post}
+	defer r.abort()
+
+	result = n
+	r.apply(&Cursor{node: n, root: &result})
+	return result
+}
+
+// RewriteBlock is like Rewrite, except it traverses every statement in a block. Statements may be deleted from
+// or inserted into the top level block. The new block is returned.
+func RewriteBlock(block []Stmt, pre, post func(*Cursor) bool) (result []Stmt) {
+	r := &rewriter{pre: pre, post: post}
+	defer func() {
+		result = block
+	}()
+	defer r.abort()
+
+	r.block(nil, "", &block)
+	return block
+}
+
+// Cursor describes a Node found during a call to Rewrite and is used to change it.
+//
+// Cursor methods panic if they are used in a way that would make the AST invalid, for example deleting a Node
+// that is not part of a list, or replacing a statement with an expression.
+type Cursor struct {
+	parent Node
+	name   string
+	node   Node
+
+	iter    *iterator // nil if the Node is not part of a list.
+	deleted bool
+
+	// Exactly one of these is set, depending on where the Node is.
+	stmts *[]Stmt
+	exprs *[]Expr
+	slot  *Expr
+	root  *Node
+
+	keys *[]Expr // For table constructor values, the matching keys.
+}
+
+type iterator struct {
+	index, step int
+}
+
+// Node returns the current Node, nil if it was deleted.
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the Node that contains the current Node. For the root Node (or statements in the block passed
+// to RewriteBlock) this is nil.
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Name returns the name of the parent field that contains the current Node, for example "Cond" or "Block".
+func (c *Cursor) Name() string { return c.name }
+
+// Index returns the index of the current Node in the parent field if that field is a list, otherwise -1.
+func (c *Cursor) Index() int {
+	if c.iter == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+// Replace replaces the current Node. Statements may only be replaced with statements and expressions with
+// expressions.
+func (c *Cursor) Replace(n Node) {
+	if c.deleted {
+		panic("ast: Replace called on a deleted Node")
+	}
+	if isNil(n) {
+		panic("ast: Replace called with nil, use Delete to remove Nodes")
+	}
+
+	switch {
+	case c.stmts != nil:
+		(*c.stmts)[c.iter.index] = c.toStmt(n)
+	case c.exprs != nil:
+		(*c.exprs)[c.iter.index] = c.toExpr(n)
+	case c.slot != nil:
+		*c.slot = c.toExpr(n)
+	default:
+		*c.root = n
+	}
+	c.node = n
+}
+
+// Delete removes the current Node from its list. Removing a table constructor value also removes its key.
+func (c *Cursor) Delete() {
+	if c.deleted {
+		panic("ast: Delete called on a deleted Node")
+	}
+
+	i := c.list()
+	if c.stmts != nil {
+		*c.stmts = remove(*c.stmts, i)
+	} else {
+		*c.exprs = append((*c.exprs)[:i], (*c.exprs)[i+1:]...)
+		if c.keys != nil {
+			*c.keys = append((*c.keys)[:i], (*c.keys)[i+1:]...)
+		}
+	}
+	c.iter.step--
+	c.deleted = true
+	c.node = nil
+}
+
+// InsertBefore inserts a Node into the list before the current Node. The new Node is not traversed. Values
+// inserted into a table constructor are given the next integer key.
+func (c *Cursor) InsertBefore(n Node) {
+	c.insert(c.list(), n)
+	c.iter.index++
+}
+
+// InsertAfter inserts a Node into the list after the current Node. The new Node is not traversed. Values
+// inserted into a table constructor are given the next integer key.
+func (c *Cursor) InsertAfter(n Node) {
+	i := c.list()
+	if !c.deleted {
+		i++
+	}
+	c.insert(i, n)
+	c.iter.step++
+}
+
+func (c *Cursor) insert(i int, n Node) {
+	if isNil(n) {
+		panic("ast: cannot insert nil")
+	}
+
+	if c.stmts != nil {
+		*c.stmts = insert(*c.stmts, i, c.toStmt(n))
+		return
+	}
+
+	*c.exprs = append(*c.exprs, nil)
+	copy((*c.exprs)[i+1:], (*c.exprs)[i:])
+	(*c.exprs)[i] = c.toExpr(n)
+	if c.keys != nil {
+		*c.keys = append(*c.keys, nil)
+		copy((*c.keys)[i+1:], (*c.keys)[i:])
+		(*c.keys)[i] = nil
+	}
+}
+
+// list returns the index of the current Node, or panics if it is not in a list that may be changed.
+func (c *Cursor) list() int {
+	if c.stmts == nil && c.exprs == nil {
+		panic("ast: Node is not part of a list")
+	}
+	return c.iter.index
+}
+
+func (c *Cursor) toStmt(n Node) Stmt {
+	s, ok := n.(Stmt)
+	if !ok {
+		panic("ast: cannot use " + typeName(n) + " as a statement")
+	}
+	return s
+}
+
+func (c *Cursor) toExpr(n Node) Expr {
+	e, ok := n.(Expr)
+	if !ok {
+		panic("ast: cannot use " + typeName(n) + " as an expression")
+	}
+	return e
+}
+
+type rewriteAbort struct{}
+
+type rewriter struct {
+	pre, post func(*Cursor) bool
+}
+
+func (r *rewriter) abort() {
+	if x := recover(); x != nil {
+		if _, ok := x.(rewriteAbort); !ok {
+			panic(x)
+		}
+	}
+}
+
+func (r *rewriter) apply(c *Cursor) {
+	if r.pre != nil && !r.pre(c) {
+		return
+	}
+	if c.deleted {
+		return
+	}
+
+	r.children(c.node)
+
+	if r.post != nil && !r.post(c) {
+		panic(rewriteAbort{})
+	}
+}
+
+func (r *rewriter) block(parent Node, name string, list *[]Stmt) {
+	iter := &iterator{}
+	for iter.index < len(*list) {
+		iter.step = 1
+		if n := (*list)[iter.index]; !isNil(n) {
+			r.apply(&Cursor{parent: parent, name: name, node: n, iter: iter, stmts: list})
+		}
+		iter.index += iter.step
+	}
+}
+
+func (r *rewriter) exprs(parent Node, name string, list *[]Expr) {
+	iter := &iterator{}
+	for iter.index < len(*list) {
+		iter.step = 1
+		if n := (*list)[iter.index]; !isNil(n) {
+			r.apply(&Cursor{parent: parent, name: name, node: n, iter: iter, exprs: list})
+		}
+		iter.index += iter.step
+	}
+}
+
+func (r *rewriter) expr(parent Node, name string, slot *Expr) {
+	if !isNil(*slot) {
+		r.apply(&Cursor{parent: parent, name: name, node: *slot, slot: slot})
+	}
+}
+
+func (r *rewriter) table(n *TableConstructor) {
+	iter := &iterator{}
+	for iter.index < len(n.Vals) {
+		iter.step = 1
+
+		// Keys may be replaced, but not deleted, so they get their own iterator.
+		i := iter.index
+		if i < len(n.Keys) && !isNil(n.Keys[i]) {
+			r.apply(&Cursor{parent: n, name: "Keys", node: n.Keys[i], iter: &iterator{index: i}, slot: &n.Keys[i]})
+		}
+		if v := n.Vals[i]; !isNil(v) {
+			r.apply(&Cursor{parent: n, name: "Vals", node: v, iter: iter, exprs: &n.Vals, keys: &n.Keys})
+		}
+		iter.index += iter.step
+	}
+}
+
+func (r *rewriter) children(n Node) {
+	switch nn := n.(type) {
+	case *Assign:
+		r.exprs(n, "Targets", &nn.Targets)
+		r.exprs(n, "Values", &nn.Values)
+	case *DoBlock:
+		r.block(n, "Block", &nn.Block)
+	case *If:
+		r.expr(n, "Cond", &nn.Cond)
+		r.block(n, "Then", &nn.Then)
+		r.block(n, "Else", &nn.Else)
+	case *WhileLoop:
+		r.expr(n, "Cond", &nn.Cond)
+		r.block(n, "Block", &nn.Block)
+	case *RepeatUntilLoop:
+		r.block(n, "Block", &nn.Block)
+		r.expr(n, "Cond", &nn.Cond)
+	case *ForLoopNumeric:
+		r.expr(n, "Init", &nn.Init)
+		r.expr(n, "Limit", &nn.Limit)
+		r.expr(n, "Step", &nn.Step)
+		r.block(n, "Block", &nn.Block)
+	case *ForLoopGeneric:
+		r.exprs(n, "Init", &nn.Init)
+		r.block(n, "Block", &nn.Block)
+	case *Goto:
+	case *Label:
+	case *Return:
+		r.exprs(n, "Items", &nn.Items)
+	case *Operator:
+		r.expr(n, "Left", &nn.Left)
+		r.expr(n, "Right", &nn.Right)
+	case *FuncCall:
+		r.expr(n, "Receiver", &nn.Receiver)
+		r.expr(n, "Function", &nn.Function)
+		r.exprs(n, "Args", &nn.Args)
+	case *FuncDecl:
+		r.block(n, "Block", &nn.Block)
+	case *TableConstructor:
+		r.table(nn)
+	case *TableAccessor:
+		r.expr(n, "Obj", &nn.Obj)
+		r.expr(n, "Key", &nn.Key)
+	case *Parens:
+		r.expr(n, "Inner", &nn.Inner)
+	case *ConstInt:
+	case *ConstFloat:
+	case *ConstString:
+	case *ConstIdent:
+	case *ConstBool:
+	case *ConstNil:
+	case *ConstVariadic:
+	default:
+		panic("IMPOSSIBLE")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "testing"
+import "bytes"
+import "strconv"
+
+func rewriteString(t *testing.T, src string, pre, post func(*Cursor) bool) string {
+	block, err := Parse(src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripPositions(block)
+	block = RewriteBlock(block, pre, post)
+	if err := Validate(block); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = Format(buf, block, FormatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestRewrite(t *testing.T) {
+	cases := []struct {
+		name      string
+		src       string
+		pre, post func(*Cursor) bool
+		out       string
+	}{
+		{
+			// Fold integer additions bottom up.
+			name: "replace",
+			src:  "x = 1 + 2 + y + (3 + 4)",
+			post: func(c *Cursor) bool {
+				op, ok := c.Node().(*Operator)
+				if !ok || op.Op != OpAdd {
+					return true
+				}
+				l, ok1 := op.Left.(*ConstInt)
+				r, ok2 := op.Right.(*ConstInt)
+				if ok1 && ok2 {
+					a, _ := strconv.ParseInt(l.Value, 10, 64)
+					b, _ := strconv.ParseInt(r.Value, 10, 64)
+					c.Replace(NewInt(a + b))
+				}
+				return true
+			},
+			out: "x = 3 + y + (7)\n",
+		},
+		{
+			name: "delete",
+			src:  "a() b() do b() c() end",
+			pre: func(c *Cursor) bool {
+				if f, ok := c.Node().(*FuncCall); ok && c.Index() >= 0 {
+					if f.Function.(*ConstIdent).Value == "b" {
+						c.Delete()
+					}
+				}
+				return true
+			},
+			out: "a()\ndo\n\tc()\nend\n",
+		},
+		{
+			// Insert trace calls around every call statement, but not inside of the inserted calls.
+			name: "insert",
+			src:  "local function f() g() end f()",
+			pre: func(c *Cursor) bool {
+				if _, ok := c.Node().(*FuncCall); ok && c.Name() == "Block" || ok && c.Parent() == nil {
+					c.InsertBefore(NewCall(NewIdent("enter")))
+					c.InsertAfter(NewCall(NewIdent("leave")))
+				}
+				return true
+			},
+			out: "local function f()\n\tenter()\n\tg()\n\tleave()\nend\nenter()\nf()\nleave()\n",
+		},
+		{
+			// Table keys stay in sync with values.
+			name: "table",
+			src:  "t = {a = 1, 2, b = 3, 4}",
+			pre: func(c *Cursor) bool {
+				if c.Name() != "Vals" {
+					return true
+				}
+				switch c.Node().(*ConstInt).Value {
+				case "1":
+					c.Delete()
+				case "3":
+					c.InsertBefore(NewString("x"))
+				}
+				return true
+			},
+			out: "t = {2, \"x\", b = 3, 4}\n",
+		},
+		{
+			// Only the first assignment is changed.
+			name: "abort",
+			src:  "x = 1 y = 1",
+			post: func(c *Cursor) bool {
+				if _, ok := c.Node().(*ConstInt); ok {
+					c.Replace(NewInt(2))
+					return false
+				}
+				return true
+			},
+			out: "x = 2\ny = 1\n",
+		},
+	}
+
+	for _, cs := range cases {
+		out := rewriteString(t, cs.src, cs.pre, cs.post)
+		if out != cs.out {
+			t.Errorf("%v: Wrong output:\n%v\nExpected:\n%v", cs.name, out, cs.out)
+		}
+	}
+
+	// Replacing the root.
+	n := Rewrite(NewParens(NewIdent("x")), func(c *Cursor) bool {
+		if p, ok := c.Node().(*Parens); ok {
+			c.Replace(p.Inner)
+		}
+		return true
+	}, nil)
+	if id, ok := n.(*ConstIdent); !ok || id.Value != "x" {
+		t.Errorf("Root not replaced: %#v", n)
+	}
+
+	// Invalid changes panic.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Replacing a statement with an expression did not panic.")
+			}
+		}()
+		RewriteBlock([]Stmt{NewBreak()}, func(c *Cursor) bool {
+			c.Replace(NewNil())
+			return true
+		}, nil)
+	}()
+}
@@ -0,0 +1,460 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+Scope and variable resolution for Lua ASTs.
+
+Analyze follows the same rules as the compiler to find the variable each identifier refers to. The result
+records whether each identifier is a local, an upvalue, or a global, where each local was declared, which
+locals hide other locals, and which locals are never read. This is the information needed by linters,
+editors ("go to definition", completion), and other tools that work with source code.
+
+Globals are resolved through "_ENV". If a local named "_ENV" is in scope then globals are actually fields in
+that local, and the Ref for the global records the local it was read through.
+*/
+package scope
+
+import "github.com/milochristiansen/lua/ast"
+
+// Kind is the type of a variable reference.
+type Kind int
+
+const (
+	Local   Kind = iota // A local variable in the current function.
+	Upvalue             // A local variable from an enclosing function.
+	Global              // A field in _ENV.
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Local:
+		return "local"
+	case Upvalue:
+		return "upvalue"
+	case Global:
+		return "global"
+	}
+	return "invalid"
+}
+
+// DeclKind is the way a local variable was declared.
+type DeclKind int
+
+const (
+	DeclLocal     DeclKind = iota // local x
+	DeclLocalFunc                 // local function x() end
+	DeclParam                     // function(x) end
+	DeclFor                       // for x = 1, 2 do end, for x in y do end
+)
+
+// Binding is a local variable (including parameters and loop variables).
+type Binding struct {
+	Name string
+	Kind DeclKind
+
+	// Decl is the statement or expression that declares the local. For local declarations and local functions
+	// this is an *ast.Assign, for parameters it is an *ast.FuncDecl, and for loop variables it is the loop.
+	Decl ast.Node
+
+	// Ident is the identifier in the declaration, if there is one. Parameters and loop variables do not have an
+	// identifier Node, only a name.
+	Ident *ast.ConstIdent
+
+	// From is the position where the local comes into scope. References before this position refer to something
+	// else, even if they are inside Scope.
+	From ast.Position
+
+	Scope   *Scope
+	Func    *ast.FuncDecl // The function the local belongs to, nil for the main chunk.
+	Shadows *Binding      // The local with the same name that this local hides, if any.
+	Refs    []*Ref        // Every reference to this local, in source order.
+}
+
+// Used returns true if the local is ever read. Locals that are only ever written are not used.
+func (b *Binding) Used() bool {
+	for _, ref := range b.Refs {
+		if !ref.Write {
+			return true
+		}
+	}
+	return false
+}
+
+// Ref is a reference to a variable.
+type Ref struct {
+	Ident *ast.ConstIdent
+	Kind  Kind
+	Write bool // True if the variable is being assigned to.
+
+	Binding *Binding // The local being referenced, nil for globals.
+	Env     *Binding // For globals, the local _ENV that the global is a field of, nil for the default _ENV.
+}
+
+// Scope is a block of code that may contain local variables.
+type Scope struct {
+	Parent   *Scope
+	Children []*Scope
+
+	// Node is the Node that created the scope: a function, loop, do block, or if statement. Node is nil for the
+	// main chunk.
+	Node ast.Node
+
+	// Span is the region of source code the scope covers. The main chunk covers all the code and has an empty
+	// span. If statements have separate scopes for the two branches.
+	Span ast.Span
+
+	Func     *ast.FuncDecl // The function the scope is in, nil for the main chunk.
+	Bindings []*Binding    // Every local declared directly in this scope, in source order.
+}
+
+// Info is the result of analyzing a chunk.
+type Info struct {
+	Root     *Scope
+	Bindings []*Binding // Every local in the chunk, in source order.
+	Refs     []*Ref     // Every reference to a variable in the chunk.
+
+	Uses map[*ast.ConstIdent]*Ref     // References by identifier.
+	Defs map[*ast.ConstIdent]*Binding // Locals by the identifier that declares them.
+}
+
+// Lookup returns the local an identifier refers to or declares, nil if it is a global (or not a variable).
+func (i *Info) Lookup(id *ast.ConstIdent) *Binding {
+	if b, ok := i.Defs[id]; ok {
+		return b
+	}
+	if ref, ok := i.Uses[id]; ok {
+		return ref.Binding
+	}
+	return nil
+}
+
+// Unused returns every local that is never read. Locals with names that start with an underscore and
+// parameters named "self" are ignored.
+func (i *Info) Unused() []*Binding {
+	rtn := []*Binding{}
+	for _, b := range i.Bindings {
+		if b.Used() || b.Name[0] == '_' || b.Kind == DeclParam && b.Name == "self" {
+			continue
+		}
+		rtn = append(rtn, b)
+	}
+	return rtn
+}
+
+// Shadowed returns every local that hides another local with the same name.
+func (i *Info) Shadowed() []*Binding {
+	rtn := []*Binding{}
+	for _, b := range i.Bindings {
+		if b.Shadows != nil {
+			rtn = append(rtn, b)
+		}
+	}
+	return rtn
+}
+
+// Undefined returns every read of a global that is not in allow and is never assigned to in the chunk. Globals
+// read through a local _ENV are ignored.
+func (i *Info) Undefined(allow map[string]bool) []*Ref {
+	set := map[string]bool{}
+	for _, ref := range i.Refs {
+		if ref.Kind == Global && ref.Write && ref.Env == nil {
+			set[ref.Ident.Value] = true
+		}
+	}
+
+	rtn := []*Ref{}
+	for _, ref := range i.Refs {
+		if ref.Kind != Global || ref.Write || ref.Env != nil {
+			continue
+		}
+		if allow[ref.Ident.Value] || set[ref.Ident.Value] {
+			continue
+		}
+		rtn = append(rtn, ref)
+	}
+	return rtn
+}
+
+// Visible returns the locals that are in scope at the given position, innermost first. Locals that are hidden by
+// other locals are not included. This requires position information, so it does not work with trees that were not
+// produced by the parser.
+func (i *Info) Visible(pos ast.Position) []*Binding {
+	s := i.Root
+outer:
+	for {
+		for _, c := range s.Children {
+			if c.Span.Start.Offset <= pos.Offset && pos.Offset <= c.Span.End.Offset {
+				s = c
+				continue outer
+			}
+		}
+		break
+	}
+
+	rtn := []*Binding{}
+	seen := map[string]bool{}
+	for ; s != nil; s = s.Parent {
+		for j := len(s.Bindings) - 1; j >= 0; j-- {
+			b := s.Bindings[j]
+			if b.From.Offset > pos.Offset || seen[b.Name] {
+				continue
+			}
+			seen[b.Name] = true
+			rtn = append(rtn, b)
+		}
+	}
+	return rtn
+}
+
+// Analyze resolves every variable in a chunk.
+func Analyze(block []ast.Stmt) *Info {
+	r := &resolver{
+		info: &Info{
+			Root: &Scope{},
+			Uses: map[*ast.ConstIdent]*Ref{},
+			Defs: map[*ast.ConstIdent]*Binding{},
+		},
+	}
+	r.scope = r.info.Root
+	r.block(block)
+	return r.info
+}
+
+type resolver struct {
+	info  *Info
+	scope *Scope
+}
+
+func (r *resolver) open(n ast.Node, span ast.Span) {
+	s := &Scope{Parent: r.scope, Node: n, Span: span, Func: r.scope.Func}
+	if f, ok := n.(*ast.FuncDecl); ok {
+		s.Func = f
+	}
+	r.scope.Children = append(r.scope.Children, s)
+	r.scope = s
+}
+
+func (r *resolver) close() {
+	r.scope = r.scope.Parent
+}
+
+func (r *resolver) lookup(name string) *Binding {
+	for s := r.scope; s != nil; s = s.Parent {
+		for i := len(s.Bindings) - 1; i >= 0; i-- {
+			if s.Bindings[i].Name == name {
+				return s.Bindings[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (r *resolver) declare(name string, kind DeclKind, decl ast.Node, id *ast.ConstIdent, from ast.Position) {
+	b := &Binding{
+		Name:    name,
+		Kind:    kind,
+		Decl:    decl,
+		Ident:   id,
+		From:    from,
+		Scope:   r.scope,
+		Func:    r.scope.Func,
+		Shadows: r.lookup(name),
+	}
+	r.scope.Bindings = append(r.scope.Bindings, b)
+	r.info.Bindings = append(r.info.Bindings, b)
+	if id != nil {
+		r.info.Defs[id] = b
+	}
+}
+
+func (r *resolver) ref(id *ast.ConstIdent, write bool) {
+	ref := &Ref{Ident: id, Write: write, Binding: r.lookup(id.Value)}
+	switch {
+	case ref.Binding == nil:
+		ref.Kind = Global
+		ref.Env = r.lookup("_ENV")
+		if ref.Env != nil {
+			// Using a global reads the _ENV local.
+			ref.Env.Refs = append(ref.Env.Refs, &Ref{Ident: id, Kind: r.kind(ref.Env), Binding: ref.Env})
+		}
+	default:
+		ref.Kind = r.kind(ref.Binding)
+		ref.Binding.Refs = append(ref.Binding.Refs, ref)
+	}
+	r.info.Refs = append(r.info.Refs, ref)
+	r.info.Uses[id] = ref
+}
+
+func (r *resolver) kind(b *Binding) Kind {
+	if b.Func == r.scope.Func {
+		return Local
+	}
+	return Upvalue
+}
+
+func (r *resolver) block(block []ast.Stmt) {
+	for _, s := range block {
+		r.stmt(s)
+	}
+}
+
+func (r *resolver) stmt(n ast.Stmt) {
+	switch nn := n.(type) {
+	case *ast.Assign:
+		switch {
+		case nn.LocalDecl:
+			// The new locals are not visible in their own initializers.
+			r.exprs(nn.Values)
+			for _, t := range nn.Targets {
+				if id, ok := t.(*ast.ConstIdent); ok {
+					r.declare(id.Value, DeclLocal, nn, id, nn.Span().End)
+				}
+			}
+		case nn.LocalFunc:
+			// The function can refer to itself.
+			if id, ok := nn.Targets[0].(*ast.ConstIdent); ok {
+				r.declare(id.Value, DeclLocalFunc, nn, id, id.Span().Start)
+			}
+			r.exprs(nn.Values)
+		default:
+			for _, t := range nn.Targets {
+				if id, ok := t.(*ast.ConstIdent); ok {
+					r.ref(id, true)
+					continue
+				}
+				r.expr(t)
+			}
+			r.exprs(nn.Values)
+		}
+	case *ast.DoBlock:
+		r.open(nn, nn.Span())
+		r.block(nn.Block)
+		r.close()
+	case *ast.If:
+		r.expr(nn.Cond)
+		then := ast.Span{Start: nn.Cond.Span().End, End: nn.Span().End}
+		if len(nn.Else) > 0 {
+			then.End = nn.Else[0].Span().Start
+		}
+		r.open(nn, then)
+		r.block(nn.Then)
+		r.close()
+		r.open(nn, ast.Span{Start: then.End, End: nn.Span().End})
+		r.block(nn.Else)
+		r.close()
+	case *ast.WhileLoop:
+		r.expr(nn.Cond)
+		r.open(nn, nn.Span())
+		r.block(nn.Block)
+		r.close()
+	case *ast.RepeatUntilLoop:
+		// The condition can see locals from the loop body.
+		r.open(nn, nn.Span())
+		r.block(nn.Block)
+		r.expr(nn.Cond)
+		r.close()
+	case *ast.ForLoopNumeric:
+		r.expr(nn.Init)
+		r.expr(nn.Limit)
+		r.expr(nn.Step)
+		r.open(nn, nn.Span())
+		from := nn.Limit.Span().End
+		if nn.Step.Span().End.Offset > from.Offset {
+			from = nn.Step.Span().End
+		}
+		r.declare(nn.Counter, DeclFor, nn, nil, from)
+		r.block(nn.Block)
+		r.close()
+	case *ast.ForLoopGeneric:
+		r.exprs(nn.Init)
+		r.open(nn, nn.Span())
+		from := nn.Span().Start
+		if len(nn.Init) > 0 {
+			from = nn.Init[len(nn.Init)-1].Span().End
+		}
+		for _, name := range nn.Locals {
+			r.declare(name, DeclFor, nn, nil, from)
+		}
+		r.block(nn.Block)
+		r.close()
+	case *ast.Goto:
+	case *ast.Label:
+	case *ast.Return:
+		r.exprs(nn.Items)
+	case *ast.FuncCall:
+		r.expr(nn)
+	default:
+		panic("IMPOSSIBLE")
+	}
+}
+
+func (r *resolver) exprs(list []ast.Expr) {
+	for _, e := range list {
+		r.expr(e)
+	}
+}
+
+func (r *resolver) expr(n ast.Expr) {
+	switch nn := n.(type) {
+	case *ast.Operator:
+		if nn.Left != nil {
+			r.expr(nn.Left)
+		}
+		r.expr(nn.Right)
+	case *ast.FuncCall:
+		if nn.Receiver != nil {
+			r.expr(nn.Receiver)
+		} else {
+			r.expr(nn.Function)
+		}
+		r.exprs(nn.Args)
+	case *ast.FuncDecl:
+		r.open(nn, nn.Span())
+		for _, name := range nn.Params {
+			r.declare(name, DeclParam, nn, nil, nn.Span().Start)
+		}
+		r.block(nn.Block)
+		r.close()
+	case *ast.TableConstructor:
+		for i, v := range nn.Vals {
+			if nn.Keys[i] != nil {
+				r.expr(nn.Keys[i])
+			}
+			r.expr(v)
+		}
+	case *ast.TableAccessor:
+		r.expr(nn.Obj)
+		r.expr(nn.Key)
+	case *ast.Parens:
+		r.expr(nn.Inner)
+	case *ast.ConstIdent:
+		r.ref(nn, false)
+	case *ast.ConstInt:
+	case *ast.ConstFloat:
+	case *ast.ConstString:
+	case *ast.ConstBool:
+	case *ast.ConstNil:
+	case *ast.ConstVariadic:
+	default:
+		panic("IMPOSSIBLE")
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package scope
+
+import "testing"
+import "github.com/milochristiansen/lua/ast"
+
+func analyze(t *testing.T, src string) ([]ast.Stmt, *Info) {
+	block, err := ast.Parse(src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block, Analyze(block)
+}
+
+// refs returns the kind of every reference to name, in source order.
+func refs(info *Info, name string) []Kind {
+	rtn := []Kind{}
+	for _, ref := range info.Refs {
+		if ref.Ident.Value == name {
+			rtn = append(rtn, ref.Kind)
+		}
+	}
+	return rtn
+}
+
+func names(bs []*Binding) []string {
+	rtn := []string{}
+	for _, b := range bs {
+		rtn = append(rtn, b.Name)
+	}
+	return rtn
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResolve(t *testing.T) {
+	_, info := analyze(t, `
+local a = a
+local function f(x)
+	return a + x + f()
+end
+for i = 1, 10 do
+	local a = i
+	print(a)
+end
+repeat local r = 1 until r
+local b
+b = 2
+`)
+
+	expect := map[string][]Kind{
+		"a":     {Global, Upvalue, Local},
+		"x":     {Local},
+		"f":     {Upvalue},
+		"i":     {Local},
+		"r":     {Local},
+		"b":     {Local},
+		"print": {Global},
+	}
+	for name, kinds := range expect {
+		got := refs(info, name)
+		if len(got) != len(kinds) {
+			t.Errorf("Wrong references to %v: %v, expected %v", name, got, kinds)
+			continue
+		}
+		for i := range got {
+			if got[i] != kinds[i] {
+				t.Errorf("Wrong references to %v: %v, expected %v", name, got, kinds)
+				break
+			}
+		}
+	}
+
+	// The inner a hides the outer a.
+	sh := info.Shadowed()
+	if len(sh) != 1 || sh[0].Name != "a" || sh[0].Shadows != info.Bindings[0] {
+		t.Errorf("Wrong shadowed locals: %v", names(sh))
+	}
+
+	// b is only assigned to.
+	if got := names(info.Unused()); !equal(got, []string{"b"}) {
+		t.Errorf("Wrong unused locals: %v", got)
+	}
+
+	if got := info.Undefined(map[string]bool{"print": true}); len(got) != 1 || got[0].Ident.Value != "a" {
+		t.Errorf("Wrong undefined globals: %v", len(got))
+	}
+}
+
+func TestEnv(t *testing.T) {
+	_, info := analyze(t, `
+g = 1
+local function sandbox()
+	local _ENV = {}
+	return x
+end
+return g, y, sandbox
+`)
+
+	undef := info.Undefined(nil)
+	if len(undef) != 1 || undef[0].Ident.Value != "y" {
+		t.Errorf("Wrong undefined globals: %v", len(undef))
+	}
+
+	var x *Ref
+	for _, ref := range info.Refs {
+		if ref.Ident.Value == "x" {
+			x = ref
+		}
+	}
+	if x == nil || x.Kind != Global || x.Env == nil || x.Env.Name != "_ENV" || !x.Env.Used() {
+		t.Errorf("Global not resolved through local _ENV: %#v", x)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	src := `local a = 1
+local function f(p)
+	local b = 2
+	-- here
+end
+-- there
+local c = 3
+`
+	_, info := analyze(t, src)
+
+	pos := func(marker string) ast.Position {
+		for i := 0; i+len(marker) <= len(src); i++ {
+			if src[i:i+len(marker)] == marker {
+				return ast.Position{Offset: i}
+			}
+		}
+		t.Fatalf("Marker %q not found.", marker)
+		return ast.Position{}
+	}
+
+	if got := names(info.Visible(pos("here"))); !equal(got, []string{"b", "p", "f", "a"}) {
+		t.Errorf("Wrong visible locals inside function: %v", got)
+	}
+	if got := names(info.Visible(pos("there"))); !equal(got, []string{"f", "a"}) {
+		t.Errorf("Wrong visible locals after function: %v", got)
+	}
+}
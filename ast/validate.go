@@ -0,0 +1,317 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "fmt"
+import "reflect"
+
+// ValidationError describes a problem with an AST that was not produced by Parse.
+type ValidationError struct {
+	Path string // The path to the problem from the root block, for example "Block[2].Values[0]".
+	Node Node   // The Node with the problem, nil if the problem is a missing Node.
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Msg
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	if e.Node != nil && e.Node.Line() > 0 {
+		msg = fmt.Sprintf("%v (line %v)", msg, e.Node.Line())
+	}
+	return msg
+}
+
+// ValidationErrors is a list of every problem Validate found, in the order they were found.
+type ValidationErrors []*ValidationError
+
+func (el ValidationErrors) Error() string {
+	switch len(el) {
+	case 0:
+		return "No errors"
+	case 1:
+		return el[0].Error()
+	}
+	return fmt.Sprintf("%v (and %v more errors)", el[0], len(el)-1)
+}
+
+// Validate checks that an AST can be compiled. This is mostly useful for trees that were built by hand or
+// decoded with UnmarshalJSON, trees from Parse are always valid.
+//
+// Validate finds missing Nodes, Nodes that are not allowed where they are (for example assigning to a function
+// call), invalid names and number constants, break or continue outside of a loop, "..." outside of a variadic
+// function, and return statements that are not the last statement in a block. Goto statements are not checked,
+// that is left to the compiler.
+//
+// If there are problems the returned error is a ValidationErrors.
+func Validate(block []Stmt) error {
+	v := &validator{}
+	v.block(block, "Block", validCtx{variadic: true})
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type validCtx struct {
+	variadic bool // Is "..." allowed?
+	loop     bool // Are break and continue allowed?
+}
+
+type validator struct {
+	errs    ValidationErrors
+	shallow bool // Only check the Node and its direct children, not the whole tree.
+}
+
+func (v *validator) errorf(path string, n Node, format string, a ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{Path: path, Node: n, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) block(block []Stmt, path string, ctx validCtx) {
+	for i, s := range block {
+		spath := fmt.Sprintf("%v[%v]", path, i)
+		if isNil(s) {
+			v.errorf(spath, nil, "missing statement")
+			continue
+		}
+		if _, ok := s.(*Return); ok && i != len(block)-1 {
+			v.errorf(spath, s, "return must be the last statement in a block")
+		}
+		if !v.shallow {
+			v.node(s, spath, ctx)
+		}
+	}
+}
+
+func (v *validator) node(n Node, path string, ctx validCtx) {
+	v.check(n, path)
+
+	switch nn := n.(type) {
+	case *Goto:
+		if nn.IsBreak && !ctx.loop && !v.shallow {
+			v.errorf(path, n, "%v outside of a loop", nn.Label)
+		}
+	case *ConstVariadic:
+		if !ctx.variadic && !v.shallow {
+			v.errorf(path, n, "cannot use \"...\" outside of a variadic function")
+		}
+	case *FuncDecl:
+		ctx = validCtx{variadic: nn.IsVariadic}
+	}
+
+	// Check the children.
+	rv := reflect.ValueOf(n).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Type().Field(i)
+		if f.Anonymous {
+			continue
+		}
+		fpath := joinPath(path, f.Name)
+
+		switch f.Type {
+		case stmtListType:
+			fctx := ctx
+			switch n.(type) {
+			case *WhileLoop, *RepeatUntilLoop, *ForLoopNumeric, *ForLoopGeneric:
+				fctx.loop = true
+			}
+			v.block(rv.Field(i).Interface().([]Stmt), fpath, fctx)
+		case exprListType:
+			for j, e := range rv.Field(i).Interface().([]Expr) {
+				epath := fmt.Sprintf("%v[%v]", fpath, j)
+				if isNil(e) {
+					// Table constructors use nil for missing keys.
+					if _, ok := n.(*TableConstructor); !ok || f.Name != "Keys" {
+						v.errorf(epath, nil, "missing expression")
+					}
+					continue
+				}
+				if !v.shallow {
+					v.node(e, epath, ctx)
+				}
+			}
+		case exprType:
+			e, _ := rv.Field(i).Interface().(Expr)
+			if isNil(e) {
+				// Optional parts (unary operators have no left side) are checked in check.
+				switch {
+				case f.Name == "Left" && reflect.TypeOf(n) == reflect.TypeOf(&Operator{}):
+				case f.Name == "Receiver" && reflect.TypeOf(n) == reflect.TypeOf(&FuncCall{}):
+				default:
+					v.errorf(fpath, nil, "missing expression")
+				}
+				continue
+			}
+			if !v.shallow {
+				v.node(e, fpath, ctx)
+			}
+		}
+	}
+}
+
+// built checks a new Node and panics with a *ValidationError if there is a problem. Only the Node itself and
+// its direct children are checked, things like break outside of a loop are left for Validate.
+func built(n Node) {
+	v := &validator{shallow: true}
+	v.node(n, "", validCtx{})
+	if len(v.errs) > 0 {
+		panic(v.errs[0])
+	}
+}
+
+// check looks for problems with a single Node that do not depend on where it is in the tree.
+func (v *validator) check(n Node, path string) {
+	switch nn := n.(type) {
+	case *Assign:
+		switch {
+		case len(nn.Targets) == 0:
+			v.errorf(path, n, "assignment without targets")
+		case nn.LocalDecl && nn.LocalFunc:
+			v.errorf(path, n, "assignment may not be both a local declaration and a local function")
+		case nn.LocalFunc:
+			if len(nn.Targets) != 1 || len(nn.Values) != 1 || reflect.TypeOf(nn.Values[0]) != reflect.TypeOf(&FuncDecl{}) {
+				v.errorf(path, n, "local function declarations must have one name and one function")
+			}
+		case !nn.LocalDecl && len(nn.Values) == 0:
+			v.errorf(path, n, "assignment without values")
+		}
+		for i, t := range nn.Targets {
+			switch t.(type) {
+			case *ConstIdent:
+			case *TableAccessor:
+				if nn.LocalDecl || nn.LocalFunc {
+					v.errorf(joinPath(path, fmt.Sprintf("Targets[%v]", i)), t, "cannot declare a table field as a local")
+				}
+			default:
+				if !isNil(t) {
+					v.errorf(joinPath(path, fmt.Sprintf("Targets[%v]", i)), t, "cannot assign to a %v", typeName(t))
+				}
+			}
+		}
+	case *ForLoopNumeric:
+		v.checkName(path, n, "loop counter", nn.Counter)
+	case *ForLoopGeneric:
+		if len(nn.Locals) == 0 {
+			v.errorf(path, n, "generic for loop without variables")
+		}
+		for _, name := range nn.Locals {
+			v.checkName(path, n, "loop variable", name)
+		}
+		if len(nn.Init) == 0 {
+			v.errorf(path, n, "generic for loop without an iterator")
+		}
+	case *Goto:
+		if nn.IsBreak {
+			if nn.Label != "break" && nn.Label != "continue" {
+				v.errorf(path, n, "break statement with invalid label %q (must be \"break\" or \"continue\")", nn.Label)
+			}
+			break
+		}
+		v.checkName(path, n, "label", nn.Label)
+	case *Label:
+		v.checkName(path, n, "label", nn.Label)
+	case *Operator:
+		if nn.Op < 0 || int(nn.Op) >= len(opTypNames) {
+			v.errorf(path, n, "invalid operator: %v", int(nn.Op))
+			break
+		}
+		unary := nn.Op == OpUMinus || nn.Op == OpBinNot || nn.Op == OpNot || nn.Op == OpLength
+		if unary && !isNil(nn.Left) {
+			v.errorf(path, n, "unary operator %v with a left operand", nn.Op)
+		}
+		if !unary && isNil(nn.Left) {
+			v.errorf(path, n, "binary operator %v without a left operand", nn.Op)
+		}
+	case *FuncCall:
+		if !isNil(nn.Receiver) {
+			name, ok := nn.Function.(*ConstString)
+			if !ok || name == nil || !validName(name.Value) {
+				v.errorf(path, n, "method calls must use a valid name as the method")
+			}
+		}
+	case *FuncDecl:
+		for _, name := range nn.Params {
+			v.checkName(path, n, "parameter", name)
+		}
+	case *TableConstructor:
+		if len(nn.Keys) != len(nn.Vals) {
+			v.errorf(path, n, "table constructor has %v keys and %v values", len(nn.Keys), len(nn.Vals))
+		}
+	case *ConstInt:
+		if !isNumber(nn.Value, tknInt) {
+			v.errorf(path, n, "invalid integer constant %q", nn.Value)
+		}
+	case *ConstFloat:
+		if !isNumber(nn.Value, tknFloat) {
+			v.errorf(path, n, "invalid float constant %q", nn.Value)
+		}
+	case *ConstIdent:
+		v.checkName(path, n, "identifier", nn.Value)
+	}
+}
+
+func (v *validator) checkName(path string, n Node, what, name string) {
+	if !validName(name) {
+		v.errorf(path, n, "invalid %v name %q", what, name)
+	}
+}
+
+// validName returns true if the string is a valid identifier.
+func validName(s string) bool {
+	return isName(s) || s == "continue"
+}
+
+// joinPath adds a field to a path. The path for the root of a constructor check is empty.
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// isNumber returns true if s is a single number token of the given type.
+func isNumber(s string, typ int) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	lex := newLexer(s, 1, ParseOptions{})
+	lex.prime()
+	return lex.look.Type == typ && lex.exlook.Type == tknINVALID && lex.look.Span.Start.Offset == 0
+}
+
+// isNil returns true for nil interfaces, and interfaces that hold a nil pointer.
+func isNil(n Node) bool {
+	if n == nil {
+		return true
+	}
+	v := reflect.ValueOf(n)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+func typeName(n Node) string {
+	return reflect.TypeOf(n).Elem().Name()
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package ast
+
+import "testing"
+import "bytes"
+
+func TestValidate(t *testing.T) {
+	// Parsed trees are always valid.
+	block, err := Parse(`
+local function f(a, ...) return ... end
+for i = 1, 2 do if i then break end end
+x = {1, a = 2}
+`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(block); err != nil {
+		t.Errorf("Parsed tree is not valid: %v", err)
+	}
+
+	block = []Stmt{
+		&Assign{Targets: []Expr{&FuncCall{Function: &ConstIdent{Value: "f"}, Args: []Expr{}}}, Values: []Expr{&ConstNil{}}},
+		&Goto{Label: "break", IsBreak: true},
+		&Assign{LocalDecl: true, Targets: []Expr{&ConstIdent{Value: "1x"}}},
+		&Return{Items: []Expr{&FuncDecl{Block: []Stmt{&Return{Items: []Expr{&ConstVariadic{}}}}}}},
+		&Return{Items: []Expr{&Operator{Op: OpAdd, Right: &ConstInt{Value: "1.5"}}, nil}},
+	}
+	err = Validate(block)
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Expected ValidationErrors, got: %v", err)
+	}
+
+	expect := []string{
+		"Block[0].Targets[0]: cannot assign to a FuncCall",
+		"Block[1]: break outside of a loop",
+		"Block[2].Targets[0]: invalid identifier name \"1x\"",
+		"Block[3]: return must be the last statement in a block",
+		"Block[3].Items[0].Block[0].Items[0]: cannot use \"...\" outside of a variadic function",
+		"Block[4].Items[0]: binary operator OpAdd without a left operand",
+		"Block[4].Items[0].Right: invalid integer constant \"1.5\"",
+		"Block[4].Items[1]: missing expression",
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("Incorrect error count: %v\n%v", len(errs), errs)
+	}
+	for i, e := range errs {
+		if e.Error() != expect[i] {
+			t.Errorf("Incorrect error:\n%v\nExpected:\n%v", e, expect[i])
+		}
+	}
+}
+
+func TestConstructors(t *testing.T) {
+	f := NewFunction([]string{"self", "x"}, false, []Stmt{
+		NewIf(NewBinaryOp(OpLessThan, NewIdent("x"), NewInt(0)), []Stmt{
+			NewReturn(NewUnaryOp(OpUMinus, NewIdent("x"))),
+		}, nil),
+		NewReturn(NewIdent("x")),
+	})
+	block := []Stmt{
+		NewLocal([]string{"t"}, []Expr{NewTable([]Expr{NewString("abs")}, []Expr{f})}),
+		NewForNumeric("i", NewInt(-2), NewFloat(2), nil, []Stmt{
+			NewCall(NewIdent("print"), NewMethodCall(NewIdent("t"), "abs", NewIdent("i"))),
+		}),
+	}
+	if err := Validate(block); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Format(buf, block, FormatOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect := `local t = {
+	abs = function(self, x)
+		if x < 0 then
+			return -x
+		end
+		return x
+	end,
+}
+for i = -2, 2.0 do
+	print(t:abs(i))
+end
+`
+	if buf.String() != expect {
+		t.Errorf("Incorrect tree:\n%v", buf.String())
+	}
+
+	// Invalid arguments panic with a ValidationError.
+	func() {
+		defer func() {
+			err, ok := recover().(*ValidationError)
+			if !ok || err.Error() != "Targets[0]: cannot assign to a ConstString" {
+				t.Errorf("Incorrect panic: %v", err)
+			}
+		}()
+		NewAssign([]Expr{NewString("x")}, []Expr{NewNil()})
+	}()
+	func() {
+		defer func() {
+			err, ok := recover().(*ValidationError)
+			if !ok || err.Error() != "Right: missing expression" {
+				t.Errorf("Incorrect panic: %v", err)
+			}
+		}()
+		NewUnaryOp(OpNot, nil)
+	}()
+}
@@ -46,6 +46,19 @@ type callFrame struct {
 	retC    int // The actual number of items returned
 	retBase int // First value to return
 	retTo   int // Index (in previous frame) to place the first return value into.
+
+	// Used by the debugger to find the start of a new line.
+	dbgLine int
+	dbgPC   int32
+
+	// Used by the profiler to cache this frame's place in the call tree.
+	prof   *profNode
+	profFn *function
+
+	// Used by coverage to cache this frame's counters and find out which way branches went.
+	cov       *funcCov
+	covFn     *function
+	covBranch int32 // One more than the pc of the last instruction if it was a branch, else 0.
 }
 
 // nxtOp gets the next opCode from a Lua function's code.
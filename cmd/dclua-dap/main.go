@@ -0,0 +1,98 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+Dclua-dap is a Debug Adapter Protocol server for Lua scripts written for this VM.
+
+Usage:
+
+	dclua-dap [flags]
+
+By default the adapter talks to the editor over standard input and output. With -listen it instead waits for
+a single connection on the given TCP address, which must be on the local machine.
+
+The adapter supports the "launch" request with the following arguments:
+
+	program      The path to the script to run.
+	stopOnEntry  Stop before the first line of the script.
+
+The script is run with the full standard library (including the string extensions), anything it prints is
+sent to the editor as output.
+
+The adapter provides line breakpoints (moved to the next line with code if needed), function breakpoints
+(matched against the name a function was called by), pausing, stepping in, over, and out, the call stack,
+locals, upvalues, and table contents, and evaluating expressions or statements in the context of a stack frame.
+
+Flags:
+
+	-listen addr  Listen on a TCP address such as "127.0.0.1:4711" instead of using standard input and output.
+
+Errors are logged to standard error.
+*/
+package main
+
+import "flag"
+import "log"
+import "net"
+import "os"
+
+func main() {
+	listen := flag.String("listen", "", "Listen on this localhost TCP address instead of using stdio.")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("dclua-dap: ")
+
+	if *listen == "" {
+		err := newSession(os.Stdout).serve(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	host, _, err := net.SplitHostPort(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		log.Fatalf("Refusing to listen on %q, only localhost addresses are allowed.", *listen)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Listening on %v", ln.Addr())
+
+	conn, err := ln.Accept()
+	ln.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = newSession(conn).serve(conn)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,452 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "bufio"
+import "encoding/json"
+import "fmt"
+import "io"
+import "os"
+import "path/filepath"
+import "sync"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/cmd/internal/baseproto"
+import "github.com/milochristiansen/lua/lmodbase"
+import "github.com/milochristiansen/lua/lmodmath"
+import "github.com/milochristiansen/lua/lmodpackage"
+import "github.com/milochristiansen/lua/lmodstring"
+import "github.com/milochristiansen/lua/lmodtable"
+import "github.com/milochristiansen/lua/lmodutf8"
+
+// The script runs on its own goroutine. While it is stopped that goroutine sits in session.stopped running
+// commands sent by the request loop, so the State is never touched from two goroutines at once.
+
+// vmCmd is a command for the script goroutine. If run is nil the script is resumed with mode.
+type vmCmd struct {
+	run  func()
+	mode lua.StepMode
+}
+
+type session struct {
+	out io.Writer
+	wmu sync.Mutex // Protects out and seq.
+	seq int
+
+	l *lua.State
+	d *lua.Debugger
+
+	launched   bool
+	configured bool
+	running    bool
+	entry      bool // Report the next stop as "entry".
+
+	mu        sync.Mutex // Protects isStopped.
+	isStopped bool
+	cmds      chan vmCmd
+	done      chan struct{} // Closed when the script exits.
+
+	handles []func() []lua.Variable // Variable references, only used on the script goroutine.
+}
+
+func newSession(out io.Writer) *session {
+	return &session{
+		out:  out,
+		cmds: make(chan vmCmd),
+		done: make(chan struct{}),
+	}
+}
+
+// serve reads requests until the client disconnects.
+func (s *session) serve(in io.Reader) error {
+	r := bufio.NewReader(in)
+	for {
+		msg, err := readMessage(r)
+		if err == io.EOF {
+			s.shutdown()
+			return nil
+		}
+		if err != nil {
+			s.shutdown()
+			return err
+		}
+		if msg.Type != "request" {
+			continue
+		}
+		if !s.handle(msg) {
+			return nil
+		}
+	}
+}
+
+func (s *session) send(msg interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	baseproto.Write(s.out, msg)
+}
+
+func (s *session) respond(req *message, body interface{}) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true, Body: body})
+}
+
+func (s *session) fail(req *message, format string, a ...interface{}) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: fmt.Sprintf(format, a...)})
+}
+
+func (s *session) event(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// handle handles a single request, returning false if the session is over.
+func (s *session) handle(req *message) bool {
+	switch req.Command {
+	case "initialize":
+		s.respond(req, Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		})
+	case "launch":
+		args := LaunchArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "Invalid arguments: %v", err)
+			break
+		}
+		if err := s.launch(args); err != nil {
+			s.fail(req, "%v", err)
+			break
+		}
+		s.respond(req, nil)
+
+		// Breakpoints can be resolved now that the program is loaded.
+		s.event("initialized", nil)
+		s.start()
+	case "setBreakpoints":
+		args := SetBreakpointsArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "Invalid arguments: %v", err)
+			break
+		}
+		if s.d == nil {
+			s.fail(req, "No program launched.")
+			break
+		}
+		lines := []int{}
+		for _, bp := range args.Breakpoints {
+			lines = append(lines, bp.Line)
+		}
+		bps := []Breakpoint{}
+		for i, line := range s.d.SetBreakpoints(sourceName(args.Source.Path), lines) {
+			if line == 0 {
+				bps = append(bps, Breakpoint{Line: lines[i]})
+				continue
+			}
+			bps = append(bps, Breakpoint{Verified: true, Line: line})
+		}
+		s.respond(req, map[string]interface{}{"breakpoints": bps})
+	case "setFunctionBreakpoints":
+		args := SetFunctionBreakpointsArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "Invalid arguments: %v", err)
+			break
+		}
+		if s.d == nil {
+			s.fail(req, "No program launched.")
+			break
+		}
+		names := []string{}
+		bps := []Breakpoint{}
+		for _, bp := range args.Breakpoints {
+			names = append(names, bp.Name)
+			bps = append(bps, Breakpoint{Verified: true})
+		}
+		s.d.SetFunctionBreakpoints(names)
+		s.respond(req, map[string]interface{}{"breakpoints": bps})
+	case "setExceptionBreakpoints":
+		s.respond(req, map[string]interface{}{"breakpoints": []Breakpoint{}})
+	case "configurationDone":
+		s.respond(req, nil)
+		s.configured = true
+		s.start()
+	case "threads":
+		s.respond(req, map[string]interface{}{"threads": []Thread{{ID: 1, Name: "main"}}})
+	case "stackTrace":
+		s.whileStopped(req, func() {
+			frames := []StackFrame{}
+			for i, f := range s.d.Frames() {
+				sf := StackFrame{ID: i, Name: f.Name, Line: f.Line, Column: 1}
+				if f.Native {
+					sf.Line = 0
+					sf.PresentationHint = "subtle"
+				} else {
+					sf.Source = &Source{Name: filepath.Base(f.Source), Path: f.Source}
+				}
+				frames = append(frames, sf)
+			}
+			s.respond(req, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+		})
+	case "scopes":
+		args := ScopesArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "Invalid arguments: %v", err)
+			break
+		}
+		s.whileStopped(req, func() {
+			locals := s.addHandle(func() []lua.Variable { return s.d.Locals(args.FrameID) })
+			ups := s.addHandle(func() []lua.Variable { return s.d.Upvalues(args.FrameID) })
+			s.respond(req, map[string]interface{}{"scopes": []Scope{
+				{Name: "Locals", VariablesReference: locals},
+				{Name: "Upvalues", VariablesReference: ups},
+			}})
+		})
+	case "variables":
+		args := VariablesArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "Invalid arguments: %v", err)
+			break
+		}
+		s.whileStopped(req, func() {
+			if args.VariablesReference <= 0 || args.VariablesReference > len(s.handles) {
+				s.fail(req, "Invalid variables reference.")
+				return
+			}
+			vars := []Variable{}
+			for _, v := range s.handles[args.VariablesReference-1]() {
+				vars = append(vars, s.variable(v))
+			}
+			s.respond(req, map[string]interface{}{"variables": vars})
+		})
+	case "evaluate":
+		args := EvaluateArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "Invalid arguments: %v", err)
+			break
+		}
+		s.whileStopped(req, func() {
+			v, err := s.d.Eval(args.FrameID, args.Expression)
+			if err != nil {
+				s.fail(req, "%v", err)
+				return
+			}
+			dv := s.variable(v)
+			s.respond(req, map[string]interface{}{"result": dv.Value, "type": dv.Type, "variablesReference": dv.VariablesReference})
+		})
+	case "continue":
+		s.resume(req, lua.StepContinue, map[string]interface{}{"allThreadsContinued": true})
+	case "next":
+		s.resume(req, lua.StepOver, nil)
+	case "stepIn":
+		s.resume(req, lua.StepIn, nil)
+	case "stepOut":
+		s.resume(req, lua.StepOut, nil)
+	case "pause":
+		if s.d != nil {
+			s.d.Pause()
+		}
+		s.respond(req, nil)
+	case "disconnect", "terminate":
+		s.shutdown()
+		s.respond(req, nil)
+		return req.Command != "disconnect"
+	default:
+		s.fail(req, "Unsupported request %q.", req.Command)
+	}
+	return true
+}
+
+// launch creates the State and loads the program, but does not run it.
+func (s *session) launch(args LaunchArguments) error {
+	if s.launched {
+		return fmt.Errorf("Already launched.")
+	}
+	if args.Program == "" {
+		return fmt.Errorf("No program given.")
+	}
+	path := sourceName(args.Program)
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	l := lua.NewState()
+	l.Output = outputWriter{s}
+	for _, open := range []lua.NativeFunction{lmodbase.Open, lmodpackage.Open, lmodstring.Open, lmodtable.Open, lmodmath.Open, lmodutf8.Open} {
+		l.Push(open)
+		l.Call(0, 0)
+	}
+
+	s.d = lua.NewDebugger()
+	s.d.Stopped = s.stopped
+	l.SetDebugger(s.d)
+
+	err = l.LoadText(src, path, 0)
+	if err != nil {
+		return err
+	}
+	if args.StopOnEntry {
+		s.entry = true
+		s.d.Pause()
+	}
+
+	s.l = l
+	s.launched = true
+	return nil
+}
+
+// start runs the program once it is launched and configured.
+func (s *session) start() {
+	if !s.launched || !s.configured || s.running {
+		return
+	}
+	s.running = true
+
+	go func() {
+		defer close(s.done)
+
+		code := 0
+		if err := s.l.PCall(0, 0); err != nil {
+			s.event("output", OutputEvent{Category: "stderr", Output: err.Error() + "\n"})
+			code = 1
+		}
+		s.event("exited", ExitedEvent{ExitCode: code})
+		s.event("terminated", nil)
+	}()
+}
+
+// shutdown kills the script (if it is running) and waits for it to exit.
+func (s *session) shutdown() {
+	if !s.running {
+		return
+	}
+	s.d.Terminate()
+	s.mu.Lock()
+	if s.isStopped {
+		s.isStopped = false
+		s.cmds <- vmCmd{mode: lua.StepContinue}
+	}
+	s.mu.Unlock()
+	<-s.done
+	s.running = false
+}
+
+// stopped is the Debugger's Stopped callback, it runs on the script goroutine.
+func (s *session) stopped(d *lua.Debugger, reason lua.StopReason) lua.StepMode {
+	name := reason.String()
+	if s.entry {
+		name, s.entry = "entry", false
+	}
+
+	s.mu.Lock()
+	s.isStopped = true
+	s.mu.Unlock()
+	s.event("stopped", StoppedEvent{Reason: name, ThreadID: 1, AllThreadsStopped: true})
+
+	for cmd := range s.cmds {
+		if cmd.run != nil {
+			cmd.run()
+			continue
+		}
+		s.handles = nil
+		return cmd.mode
+	}
+	return lua.StepContinue
+}
+
+// whileStopped runs f on the script goroutine, or fails the request if the script is not stopped. If f raises
+// an error the request fails.
+func (s *session) whileStopped(req *message, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isStopped {
+		s.fail(req, "The program is not stopped.")
+		return
+	}
+	done := make(chan struct{})
+	s.cmds <- vmCmd{run: func() {
+		defer close(done)
+		if err := s.l.Protect(f); err != nil {
+			s.fail(req, "%v", err)
+		}
+	}}
+	<-done
+}
+
+// resume resumes the script with the given mode.
+func (s *session) resume(req *message, mode lua.StepMode, body interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isStopped {
+		s.fail(req, "The program is not stopped.")
+		return
+	}
+	s.isStopped = false
+	s.respond(req, body)
+	s.cmds <- vmCmd{mode: mode}
+}
+
+// addHandle adds a variable reference. Only call on the script goroutine.
+func (s *session) addHandle(f func() []lua.Variable) int {
+	s.handles = append(s.handles, f)
+	return len(s.handles)
+}
+
+// variable converts a Variable, adding a reference for tables. Only call on the script goroutine.
+func (s *session) variable(v lua.Variable) Variable {
+	dv := Variable{Name: v.Name, Value: v.Value, Type: v.Type}
+	if v.Ref != 0 {
+		ref := v.Ref
+		dv.VariablesReference = s.addHandle(func() []lua.Variable { return s.d.Fields(ref) })
+	}
+	return dv
+}
+
+// sourceName converts a path from the client to the name used for the chunk.
+func sourceName(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// outputWriter sends anything the script prints to the client.
+type outputWriter struct {
+	s *session
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.event("output", OutputEvent{Category: "stdout", Output: string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "testing"
+import "bufio"
+import "encoding/json"
+import "io"
+import "os"
+import "path/filepath"
+
+import "github.com/milochristiansen/lua/cmd/internal/baseproto"
+
+// client talks to a session over a pair of pipes, the same way an editor would over stdio.
+type client struct {
+	t    *testing.T
+	w    io.Writer
+	msgs chan *message
+	seq  int
+
+	events []*message // Events received while waiting for something else.
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, msgs: make(chan *message, 16)}
+
+	go func() {
+		newSession(outW).serve(inR)
+		outW.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			msg, err := readMessage(r)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+// request sends a request and decodes the response body into v. It fails the test if the request fails.
+func (c *client) request(command string, args, v interface{}) {
+	c.t.Helper()
+
+	c.seq++
+	raw, _ := json.Marshal(args)
+	err := baseproto.Write(c.w, &message{Seq: c.seq, Type: "request", Command: command, Arguments: raw})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for msg := range c.msgs {
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("Response for the wrong request: %v", msg.RequestSeq)
+		}
+		if !msg.Success {
+			c.t.Fatalf("%v failed: %v", command, msg.Message)
+		}
+		if v != nil {
+			if err := json.Unmarshal(msg.Body, v); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+	c.t.Fatal("Connection closed.")
+}
+
+// wait waits for an event and decodes its body into v.
+func (c *client) wait(name string, v interface{}) {
+	c.t.Helper()
+
+	for i, msg := range c.events {
+		if msg.Event == name {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			if v != nil {
+				json.Unmarshal(msg.Body, v)
+			}
+			return
+		}
+	}
+	for msg := range c.msgs {
+		if msg.Type == "event" && msg.Event == name {
+			if v != nil {
+				json.Unmarshal(msg.Body, v)
+			}
+			return
+		}
+		c.events = append(c.events, msg)
+	}
+	c.t.Fatalf("Connection closed while waiting for %v.", name)
+}
+
+func TestSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lua")
+	err := os.WriteFile(path, []byte(`local t = {x = 1}
+local function f(a)
+	local b = a * 2
+	return b
+end
+
+print(f(t.x))
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.request("initialize", map[string]interface{}{"adapterID": "dclua"}, nil)
+	c.request("launch", LaunchArguments{Program: path}, nil)
+	c.wait("initialized", nil)
+
+	bps := struct{ Breakpoints []Breakpoint }{}
+	c.request("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: path}, Breakpoints: []SourceBreakpoint{{Line: 6}}}, &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified || bps.Breakpoints[0].Line != 7 {
+		t.Errorf("Unexpected breakpoints: %+v", bps.Breakpoints)
+	}
+	c.request("configurationDone", nil, nil)
+
+	stop := StoppedEvent{}
+	c.wait("stopped", &stop)
+	if stop.Reason != "breakpoint" {
+		t.Errorf("Unexpected stop reason: %v", stop.Reason)
+	}
+
+	c.request("stepIn", nil, nil)
+	c.wait("stopped", &stop)
+
+	trace := struct{ StackFrames []StackFrame }{}
+	c.request("stackTrace", StackTraceArguments{ThreadID: 1}, &trace)
+	if len(trace.StackFrames) != 2 || trace.StackFrames[0].Name != "f" || trace.StackFrames[0].Line != 3 || trace.StackFrames[1].Name != "main chunk" {
+		t.Fatalf("Unexpected stack: %+v", trace.StackFrames)
+	}
+
+	scopes := struct{ Scopes []Scope }{}
+	c.request("scopes", ScopesArguments{FrameID: 1}, &scopes)
+	vars := struct{ Variables []Variable }{}
+	c.request("variables", VariablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference}, &vars)
+	if len(vars.Variables) != 2 || vars.Variables[0].Name != "t" || vars.Variables[0].VariablesReference == 0 {
+		t.Fatalf("Unexpected locals: %+v", vars.Variables)
+	}
+	c.request("variables", VariablesArguments{VariablesReference: vars.Variables[0].VariablesReference}, &vars)
+	if len(vars.Variables) != 1 || vars.Variables[0].Name != "x" || vars.Variables[0].Value != "1" {
+		t.Errorf("Unexpected fields: %+v", vars.Variables)
+	}
+
+	result := struct{ Result string }{}
+	c.request("evaluate", EvaluateArguments{Expression: "a + 10", FrameID: 0}, &result)
+	if result.Result != "11" {
+		t.Errorf("Unexpected evaluate result: %v", result.Result)
+	}
+
+	c.request("continue", nil, nil)
+	exit := ExitedEvent{}
+	c.wait("exited", &exit)
+	if exit.ExitCode != 0 {
+		t.Errorf("Unexpected exit code: %v", exit.ExitCode)
+	}
+	output := ""
+	for _, msg := range c.events {
+		out := OutputEvent{}
+		if msg.Event == "output" && json.Unmarshal(msg.Body, &out) == nil {
+			output += out.Output
+		}
+	}
+	if output != "2\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	c.wait("terminated", nil)
+	c.request("disconnect", nil, nil)
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+import "unicode/utf16"
+import "unicode/utf8"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/ast/scope"
+
+// document is an open file and the result of analyzing it.
+type document struct {
+	uri     string
+	version int
+	text    string
+	lines   []int // The byte offset of the start of each line.
+
+	block []ast.Stmt // Parsed in recovery mode, so this is never nil even if there are errors.
+	info  *scope.Info
+	diags []Diagnostic
+}
+
+func newDocument(uri string, version int, text string, cfg *config) *document {
+	d := &document{uri: uri, version: version}
+	d.setText(text, cfg)
+	return d
+}
+
+// setText replaces the document's text and analyzes it again.
+func (d *document) setText(text string, cfg *config) {
+	d.text = text
+	d.lines = []int{0}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				i++
+			}
+			fallthrough
+		case '\n':
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	d.analyze(cfg)
+}
+
+// edit applies a change from the client.
+func (d *document) edit(change TextDocumentContentChangeEvent, cfg *config) {
+	if change.Range == nil {
+		d.setText(change.Text, cfg)
+		return
+	}
+	start, end := d.offset(change.Range.Start), d.offset(change.Range.End)
+	d.setText(d.text[:start]+change.Text+d.text[end:], cfg)
+}
+
+// offset converts a LSP position to a byte offset. Positions past the end of a line are clamped to the end of the
+// line.
+func (d *document) offset(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	i := d.lines[p.Line]
+	for n := 0; n < p.Character && i < len(d.text); {
+		r, size := utf8.DecodeRuneInString(d.text[i:])
+		if r == '\n' || r == '\r' {
+			break
+		}
+		n += len(utf16.Encode([]rune{r}))
+		i += size
+	}
+	return i
+}
+
+// position converts a byte offset to a LSP position.
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	return Position{Line: line, Character: len(utf16.Encode([]rune(d.text[d.lines[line]:offset])))}
+}
+
+func (d *document) rng(s ast.Span) Range {
+	return Range{Start: d.position(s.Start.Offset), End: d.position(s.End.Offset)}
+}
+
+// lineRange returns the range of a whole line, given a one based line number.
+func (d *document) lineRange(line int) Range {
+	if line < 1 || line > len(d.lines) {
+		line = 1
+	}
+	end := len(d.text)
+	if line < len(d.lines) {
+		end = d.lines[line]
+	}
+	for end > d.lines[line-1] && (d.text[end-1] == '\n' || d.text[end-1] == '\r') {
+		end--
+	}
+	return Range{Start: Position{Line: line - 1}, End: d.position(end)}
+}
+
+var compileErrLine = regexp.MustCompile(`[Oo]n [Ll]ine:? (\d+)`)
+
+// analyze parses the document, resolves its variables, and finds any problems.
+func (d *document) analyze(cfg *config) {
+	opts := cfg.opts
+	opts.Recover = true
+	block, err := ast.Parse(d.text, 1, opts)
+	d.block = block
+	d.info = scope.Analyze(block)
+	d.diags = []Diagnostic{}
+
+	if err != nil {
+		errs, ok := err.(ast.ErrorList)
+		if !ok {
+			d.diags = append(d.diags, Diagnostic{Range: d.lineRange(1), Severity: SeverityError, Source: "syntax", Message: err.Error()})
+			return
+		}
+		for _, e := range errs {
+			p := d.position(e.Pos.Offset)
+			d.diags = append(d.diags, Diagnostic{Range: Range{p, p}, Severity: SeverityError, Source: "syntax", Message: e.Msg})
+		}
+		return
+	}
+
+	// Some errors (mostly to do with goto) are only found by the compiler.
+	err = lua.NewState().LoadAST(block, d.uri, 0)
+	if err != nil {
+		line := 1
+		if m := compileErrLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		d.diags = append(d.diags, Diagnostic{Range: d.lineRange(line), Severity: SeverityError, Source: "compile", Message: err.Error()})
+	}
+
+	for _, ref := range d.info.Undefined(cfg.globals) {
+		d.diags = append(d.diags, Diagnostic{
+			Range:    d.rng(ref.Ident.Span()),
+			Severity: SeverityWarning,
+			Source:   "lint",
+			Message:  "undefined global " + ref.Ident.Value,
+		})
+	}
+	for _, b := range d.info.Unused() {
+		d.diags = append(d.diags, Diagnostic{
+			Range:    d.rng(d.nameSpan(b)),
+			Severity: SeverityHint,
+			Source:   "lint",
+			Message:  "unused " + b.Name,
+		})
+	}
+}
+
+// nameSpan returns the span of the name in a local's declaration. Parameters and loop variables do not have their
+// own Node, so the name is found in the source.
+func (d *document) nameSpan(b *scope.Binding) ast.Span {
+	if b.Ident != nil {
+		return b.Ident.Span()
+	}
+
+	span := b.Decl.Span()
+	start, end := span.Start.Offset, span.End.Offset
+	if end > len(d.text) || start > end {
+		return span
+	}
+	src := d.text[start:end]
+	if _, ok := b.Decl.(*ast.FuncDecl); ok {
+		// Skip the function name.
+		if i := strings.IndexByte(src, '('); i >= 0 {
+			start += i
+			src = src[i:]
+		}
+	}
+	for i := 0; i+len(b.Name) <= len(src); i++ {
+		if src[i:i+len(b.Name)] == b.Name && !isNameByte(src, i-1) && !isNameByte(src, i+len(b.Name)) {
+			return ast.Span{Start: ast.Position{Offset: start + i}, End: ast.Position{Offset: start + i + len(b.Name)}}
+		}
+	}
+	return span
+}
+
+func isNameByte(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// nodeAt returns the innermost Node that contains the offset, along with its parent.
+func (d *document) nodeAt(offset int) (n, parent ast.Node) {
+	ast.RewriteBlock(d.block, func(c *ast.Cursor) bool {
+		span := c.Node().Span()
+		if offset < span.Start.Offset || offset > span.End.Offset {
+			return false
+		}
+		n, parent = c.Node(), c.Parent()
+		return true
+	}, nil)
+	return n, parent
+}
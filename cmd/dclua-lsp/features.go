@@ -0,0 +1,316 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "regexp"
+import "sort"
+import "strings"
+
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/ast/scope"
+import "github.com/milochristiansen/lua/cmd/internal/luastd"
+
+// symbols lists the functions and variables declared in the document. Functions declared anywhere are listed, but
+// variables are only listed if they are declared directly in the main chunk or a function body.
+func (d *document) symbols() []DocumentSymbol {
+	return d.blockSymbols(d.block, true)
+}
+
+func (d *document) blockSymbols(block []ast.Stmt, vars bool) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for _, s := range block {
+		switch nn := s.(type) {
+		case *ast.Assign:
+			syms = append(syms, d.assignSymbols(nn, vars)...)
+		case *ast.DoBlock:
+			syms = append(syms, d.blockSymbols(nn.Block, false)...)
+		case *ast.If:
+			syms = append(syms, d.blockSymbols(nn.Then, false)...)
+			syms = append(syms, d.blockSymbols(nn.Else, false)...)
+		case *ast.WhileLoop:
+			syms = append(syms, d.blockSymbols(nn.Block, false)...)
+		case *ast.RepeatUntilLoop:
+			syms = append(syms, d.blockSymbols(nn.Block, false)...)
+		case *ast.ForLoopNumeric:
+			syms = append(syms, d.blockSymbols(nn.Block, false)...)
+		case *ast.ForLoopGeneric:
+			syms = append(syms, d.blockSymbols(nn.Block, false)...)
+		}
+	}
+	return syms
+}
+
+func (d *document) assignSymbols(a *ast.Assign, vars bool) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for i, t := range a.Targets {
+		name := targetName(t)
+		if name == "" {
+			continue
+		}
+		var val ast.Expr
+		if i < len(a.Values) {
+			val = a.Values[i]
+		}
+
+		sym := DocumentSymbol{Name: name, Kind: SymbolVariable, Range: d.rng(a.Span()), SelectionRange: d.rng(t.Span())}
+		if _, ok := t.(*ast.TableAccessor); ok {
+			sym.Kind = SymbolField
+		}
+		if a.LocalDecl || a.LocalFunc {
+			sym.Detail = "local"
+		}
+
+		switch v := val.(type) {
+		case *ast.FuncDecl:
+			sym.Kind = SymbolFunction
+			sym.Detail = signature(v, false)
+			if ta, ok := t.(*ast.TableAccessor); ok && isMethod(a, v) {
+				sym.Kind = SymbolMethod
+				sym.Name = targetName(ta.Obj) + ":" + ta.Key.(*ast.ConstString).Value
+				sym.Detail = signature(v, true)
+			}
+			sym.Children = d.blockSymbols(v.Block, true)
+		case *ast.TableConstructor:
+			if !vars {
+				continue
+			}
+			sym.Children = d.tableSymbols(v)
+		default:
+			if !vars {
+				continue
+			}
+		}
+		syms = append(syms, sym)
+	}
+	return syms
+}
+
+func (d *document) tableSymbols(t *ast.TableConstructor) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for i, k := range t.Keys {
+		key, ok := k.(*ast.ConstString)
+		if !ok {
+			continue
+		}
+		sym := DocumentSymbol{
+			Name:           key.Value,
+			Kind:           SymbolField,
+			Range:          d.rng(ast.Span{Start: key.Span().Start, End: t.Vals[i].Span().End}),
+			SelectionRange: d.rng(key.Span()),
+		}
+		switch v := t.Vals[i].(type) {
+		case *ast.FuncDecl:
+			sym.Kind = SymbolFunction
+			sym.Detail = signature(v, false)
+			sym.Children = d.blockSymbols(v.Block, true)
+		case *ast.TableConstructor:
+			sym.Children = d.tableSymbols(v)
+		}
+		syms = append(syms, sym)
+	}
+	return syms
+}
+
+// targetName returns the name of a variable or a chain of constant field accesses ("a.b.c"), or "" for anything
+// else.
+func targetName(e ast.Expr) string {
+	switch ee := e.(type) {
+	case *ast.ConstIdent:
+		return ee.Value
+	case *ast.TableAccessor:
+		key, ok := ee.Key.(*ast.ConstString)
+		obj := targetName(ee.Obj)
+		if ok && obj != "" {
+			return obj + "." + key.Value
+		}
+	}
+	return ""
+}
+
+// isMethod returns true if a function was declared with the "function a:b() end" syntax.
+func isMethod(a *ast.Assign, f *ast.FuncDecl) bool {
+	return f.Span().Start == a.Span().Start && len(f.Params) > 0 && f.Params[0] == "self"
+}
+
+func signature(f *ast.FuncDecl, method bool) string {
+	params := f.Params
+	if method {
+		params = params[1:]
+	}
+	if f.IsVariadic {
+		params = append(append([]string{}, params...), "...")
+	}
+	return "function(" + strings.Join(params, ", ") + ")"
+}
+
+// definition finds the declaration of the variable at p. For globals this is the first assignment in the
+// document.
+func (d *document) definition(p Position) *Location {
+	n, _ := d.nodeAt(d.offset(p))
+	id, ok := n.(*ast.ConstIdent)
+	if !ok {
+		return nil
+	}
+
+	if b := d.info.Lookup(id); b != nil {
+		return &Location{URI: d.uri, Range: d.rng(d.nameSpan(b))}
+	}
+	for _, ref := range d.info.Refs {
+		if ref.Kind == scope.Global && ref.Write && ref.Env == nil && ref.Ident.Value == id.Value {
+			return &Location{URI: d.uri, Range: d.rng(ref.Ident.Span())}
+		}
+	}
+	return nil
+}
+
+// hover describes the local or standard library item at p.
+func (d *document) hover(p Position) *Hover {
+	n, parent := d.nodeAt(d.offset(p))
+
+	name := ""
+	switch nn := n.(type) {
+	case *ast.ConstIdent:
+		if b := d.info.Lookup(nn); b != nil {
+			return d.hoverLocal(nn, b)
+		}
+		if d.isStdGlobal(nn) {
+			name = nn.Value
+		}
+	case *ast.ConstString:
+		// A library function, like string.sub.
+		ta, ok := parent.(*ast.TableAccessor)
+		if !ok || ta.Key != ast.Expr(nn) {
+			return nil
+		}
+		if lib, ok := ta.Obj.(*ast.ConstIdent); ok && d.isStdGlobal(lib) {
+			name = lib.Value + "." + nn.Value
+		}
+	}
+
+	item := luastd.Items[name]
+	if item == nil {
+		return nil
+	}
+	r := d.rng(n.Span())
+	return &Hover{Contents: itemDoc(item), Range: &r}
+}
+
+func (d *document) hoverLocal(id *ast.ConstIdent, b *scope.Binding) *Hover {
+	what := "local"
+	switch b.Kind {
+	case scope.DeclLocalFunc:
+		what = "local function"
+	case scope.DeclParam:
+		what = "parameter"
+	case scope.DeclFor:
+		what = "loop variable"
+	}
+	line := d.position(d.nameSpan(b).Start.Offset).Line + 1
+
+	r := d.rng(id.Span())
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: fmt.Sprintf("```lua\n(%v) %v\n```\n\nDeclared on line %v.", what, b.Name, line)},
+		Range:    &r,
+	}
+}
+
+// isStdGlobal returns true if id refers to a global in the default environment that is part of the standard
+// library.
+func (d *document) isStdGlobal(id *ast.ConstIdent) bool {
+	ref := d.info.Uses[id]
+	return ref != nil && ref.Kind == scope.Global && ref.Env == nil && luastd.Items[id.Value] != nil
+}
+
+func itemDoc(item *luastd.Item) MarkupContent {
+	sig := item.Sig
+	if !item.Func {
+		sig = item.Name + ": " + item.Sig
+	}
+	return MarkupContent{Kind: "markdown", Value: "```lua\n" + sig + "\n```\n\n" + item.Doc}
+}
+
+func itemKind(item *luastd.Item) int {
+	switch {
+	case item.Func:
+		return CompletionFunction
+	case item.Sig == "table" && !strings.Contains(item.Name, "."):
+		return CompletionModule
+	case strings.Contains(item.Name, "."):
+		return CompletionField
+	}
+	return CompletionVariable
+}
+
+var memberPrefix = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\s*\.\s*[A-Za-z0-9_]*$`)
+var namePrefix = regexp.MustCompile(`(^|[^A-Za-z0-9_.:])[A-Za-z0-9_]*$`)
+
+// complete lists the members of a standard library table after "lib.", or the standard globals and visible locals
+// anywhere else a name is expected.
+func (d *document) complete(p Position, cfg *config) *CompletionList {
+	offset := d.offset(p)
+	line := ""
+	if p.Line >= 0 && p.Line < len(d.lines) {
+		line = d.text[d.lines[p.Line]:offset]
+	}
+
+	locals := d.info.Visible(ast.Position{Offset: offset})
+	list := &CompletionList{Items: []CompletionItem{}}
+	if m := memberPrefix.FindStringSubmatch(line); m != nil {
+		for _, b := range locals {
+			if b.Name == m[1] {
+				return list // Not the standard library table.
+			}
+		}
+		for name, item := range luastd.Items {
+			if strings.HasPrefix(name, m[1]+".") {
+				list.Items = append(list.Items, completion(name[len(m[1])+1:], item))
+			}
+		}
+	} else if namePrefix.MatchString(line) {
+		seen := map[string]bool{}
+		for _, b := range locals {
+			seen[b.Name] = true
+			list.Items = append(list.Items, CompletionItem{Label: b.Name, Kind: CompletionVariable, Detail: "local"})
+		}
+		for name, item := range luastd.Items {
+			if !strings.Contains(name, ".") && !seen[name] {
+				seen[name] = true
+				list.Items = append(list.Items, completion(name, item))
+			}
+		}
+		for name := range cfg.globals {
+			if !seen[name] {
+				list.Items = append(list.Items, CompletionItem{Label: name, Kind: CompletionVariable, Detail: "global"})
+			}
+		}
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Label < list.Items[j].Label })
+	return list
+}
+
+func completion(label string, item *luastd.Item) CompletionItem {
+	doc := itemDoc(item)
+	return CompletionItem{Label: label, Kind: itemKind(item), Detail: item.Sig, Documentation: &doc}
+}
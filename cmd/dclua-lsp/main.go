@@ -0,0 +1,78 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+Dclua-lsp is a Language Server Protocol server for Lua scripts written for this VM.
+
+Usage:
+
+	dclua-lsp [flags]
+
+The server talks to the editor over standard input and output. Configure your editor to start it for Lua files.
+
+The server provides:
+
+	Diagnostics     Syntax and compile errors, reads of undefined globals, and unused locals, updated on every change.
+	Symbols         Functions (including methods and functions in table constructors) and variables.
+	Definition      Go to the declaration of a local, or the first assignment to a global.
+	Hover           Signatures and documentation for the standard library, including the extensions provided by
+	                this VM (getiter, the string extensions, etc).
+	Completion      Standard globals and visible locals, and the members of the standard library tables after
+	                "string." and the like.
+
+Flags:
+
+	-globals list  Comma separated list of extra globals that scripts may read without setting.
+	-continue      Allow the "continue" keyword.
+
+Errors are logged to standard error.
+*/
+package main
+
+import "flag"
+import "log"
+import "os"
+import "strings"
+
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/cmd/internal/luastd"
+
+func main() {
+	globals := flag.String("globals", "", "Comma separated list of extra globals to allow.")
+	cont := flag.Bool("continue", false, "Allow the \"continue\" keyword.")
+	flag.Parse()
+
+	cfg := &config{opts: ast.ParseOptions{Continue: *cont}}
+	cfg.globals, _ = luastd.Globals()
+	for _, name := range strings.Split(*globals, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.globals[name] = true
+		}
+	}
+
+	log.SetPrefix("dclua-lsp: ")
+	err := newServer(os.Stdin, os.Stdout, cfg).run()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "bufio"
+import "encoding/json"
+import "io"
+import "log"
+
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/cmd/internal/baseproto"
+
+type config struct {
+	globals map[string]bool // Globals that may be read without being set.
+	opts    ast.ParseOptions
+}
+
+type server struct {
+	cfg  *config
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*document
+
+	shutdown bool
+}
+
+func newServer(in io.Reader, out io.Writer, cfg *config) *server {
+	return &server{
+		cfg:  cfg,
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: map[string]*document{},
+	}
+}
+
+// run handles messages until the client sends "exit" or closes the connection. The returned error is nil only if
+// the client asked the server to shut down first.
+func (s *server) run() error {
+	for {
+		req, err := readMessage(s.in)
+		if err != nil {
+			if rerr, ok := err.(*rpcError); ok {
+				s.reply(json.RawMessage("null"), nil, rerr)
+				continue
+			}
+			return err
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		if req.ID == nil {
+			s.notify(req)
+			continue
+		}
+
+		result, rerr := s.call(req)
+		s.reply(*req.ID, result, rerr)
+	}
+}
+
+func (s *server) reply(id json.RawMessage, result interface{}, rerr *rpcError) {
+	var err error
+	if rerr != nil {
+		err = baseproto.Write(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+	} else {
+		err = baseproto.Write(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *server) send(method string, params interface{}) {
+	err := baseproto.Write(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// params decodes the parameters for a request.
+func params(req *message, v interface{}) *rpcError {
+	err := json.Unmarshal(req.Params, v)
+	if err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// call handles a request.
+func (s *server) call(req *message) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // Full
+				"documentSymbolProvider": true,
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]interface{}{
+				"name": "dclua-lsp",
+			},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/documentSymbol":
+		p := DocumentSymbolParams{}
+		if err := params(req, &p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return []DocumentSymbol{}, nil
+		}
+		return d.symbols(), nil
+	case "textDocument/definition":
+		p := TextDocumentPositionParams{}
+		if err := params(req, &p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return nil, nil
+		}
+		return d.definition(p.Position), nil
+	case "textDocument/hover":
+		p := TextDocumentPositionParams{}
+		if err := params(req, &p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return nil, nil
+		}
+		return d.hover(p.Position), nil
+	case "textDocument/completion":
+		p := TextDocumentPositionParams{}
+		if err := params(req, &p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return nil, nil
+		}
+		return d.complete(p.Position, s.cfg), nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "Method not found: " + req.Method}
+}
+
+// notify handles a notification. Errors are logged, since there is no way to report them to the client.
+func (s *server) notify(req *message) {
+	switch req.Method {
+	case "textDocument/didOpen":
+		p := DidOpenTextDocumentParams{}
+		if err := params(req, &p); err != nil {
+			log.Println(err)
+			return
+		}
+		d := newDocument(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text, s.cfg)
+		s.docs[d.uri] = d
+		s.publish(d)
+	case "textDocument/didChange":
+		p := DidChangeTextDocumentParams{}
+		if err := params(req, &p); err != nil {
+			log.Println(err)
+			return
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			log.Println("Change to unknown document:", p.TextDocument.URI)
+			return
+		}
+		for _, change := range p.ContentChanges {
+			d.edit(change, s.cfg)
+		}
+		d.version = p.TextDocument.Version
+		s.publish(d)
+	case "textDocument/didClose":
+		p := DidCloseTextDocumentParams{}
+		if err := params(req, &p); err != nil {
+			log.Println(err)
+			return
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	}
+}
+
+func (s *server) publish(d *document) {
+	s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: d.uri, Version: d.version, Diagnostics: d.diags})
+}
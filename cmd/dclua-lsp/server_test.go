@@ -0,0 +1,259 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "testing"
+import "bufio"
+import "encoding/json"
+import "fmt"
+import "io"
+import "strings"
+
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/cmd/internal/baseproto"
+import "github.com/milochristiansen/lua/cmd/internal/luastd"
+
+// client talks to a server over a pair of pipes, the same way an editor would over stdio.
+type client struct {
+	t      *testing.T
+	w      io.Writer
+	msgs   chan *message
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	globals, _ := luastd.Globals()
+	cfg := &config{globals: globals, opts: ast.ParseOptions{}}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, msgs: make(chan *message, 16), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- newServer(inR, outW, cfg).run()
+		outW.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			msg, err := readMessage(r)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) notify(method string, params interface{}) {
+	err := baseproto.Write(c.w, notification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and decodes the result into v. Notifications sent by the server in the mean time are
+// ignored.
+func (c *client) call(method string, params, v interface{}) *rpcError {
+	c.nextID++
+	id := json.RawMessage(fmt.Sprint(c.nextID))
+	err := baseproto.Write(c.w, map[string]interface{}{"jsonrpc": "2.0", "id": &id, "method": method, "params": params})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for msg := range c.msgs {
+		if msg.ID == nil || string(*msg.ID) != string(id) {
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if v != nil {
+			if err := json.Unmarshal(msg.Result, v); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return nil
+	}
+	c.t.Fatal("Server closed the connection.")
+	return nil
+}
+
+// diagnostics waits for the next set of diagnostics.
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	for msg := range c.msgs {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			p := PublishDiagnosticsParams{}
+			if err := json.Unmarshal(msg.Params, &p); err != nil {
+				c.t.Fatal(err)
+			}
+			return p
+		}
+	}
+	c.t.Fatal("Server closed the connection.")
+	return PublishDiagnosticsParams{}
+}
+
+// at returns the position of the first occurrence of marker in src, plus offset characters. src must be ASCII.
+func at(src, marker string, offset int) Position {
+	i := strings.Index(src, marker) + offset
+	line := strings.Count(src[:i], "\n")
+	return Position{Line: line, Character: i - strings.LastIndex(src[:i], "\n") - 1}
+}
+
+const uri = "file:///test.lua"
+
+const src = `local M = {}
+
+function M:greet(name)
+	local msg = string.format("Hello %s", name)
+	return msg
+end
+
+local function helper(x)
+	return M.greet(x)
+end
+
+print(helpr(1))
+`
+
+func TestServer(t *testing.T) {
+	c := newClient(t)
+
+	caps := map[string]interface{}{}
+	if err := c.call("initialize", map[string]interface{}{}, &caps); err != nil {
+		t.Fatal(err)
+	}
+	if caps["capabilities"] == nil {
+		t.Fatal("No capabilities.")
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	// Diagnostics
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, Version: 1, Text: src}})
+	diags := c.diagnostics()
+	if len(diags.Diagnostics) != 2 ||
+		diags.Diagnostics[0].Message != "undefined global helpr" || diags.Diagnostics[0].Range.Start != at(src, "helpr", 0) ||
+		diags.Diagnostics[1].Message != "unused helper" || diags.Diagnostics[1].Range.Start != at(src, "helper", 0) {
+		t.Errorf("Wrong diagnostics: %+v", diags.Diagnostics)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentItem{URI: uri, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "x = = 1"}},
+	})
+	diags = c.diagnostics()
+	if len(diags.Diagnostics) != 1 || diags.Diagnostics[0].Severity != SeverityError || diags.Version != 2 {
+		t.Errorf("Wrong diagnostics for syntax error: %+v", diags)
+	}
+
+	// Incremental changes work too.
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentItem{URI: uri, Version: 3},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: src}, {Range: &Range{at(src, "helpr", 0), at(src, "helpr", 5)}, Text: "helper"}},
+	})
+	if diags = c.diagnostics(); len(diags.Diagnostics) != 0 {
+		t.Errorf("Wrong diagnostics after fix: %+v", diags.Diagnostics)
+	}
+	fixed := strings.Replace(src, "helpr", "helper", 1)
+
+	// Symbols
+	syms := []DocumentSymbol{}
+	if err := c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &syms); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, s := range syms {
+		got = append(got, fmt.Sprintf("%v %v %v", s.Name, s.Kind, len(s.Children)))
+	}
+	if expect := "M 13 0, M:greet 6 1, helper 12 0"; strings.Join(got, ", ") != expect {
+		t.Errorf("Wrong symbols: %v\nExpected: %v", strings.Join(got, ", "), expect)
+	}
+
+	// Definition
+	loc := &Location{}
+	if err := c.call("textDocument/definition", TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: at(fixed, "M.greet(x)", 0)}, loc); err != nil {
+		t.Fatal(err)
+	}
+	if loc.Range.Start != at(fixed, "M = {}", 0) {
+		t.Errorf("Wrong definition for M: %+v", loc)
+	}
+	if err := c.call("textDocument/definition", TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: at(fixed, "(x)\nend", 1)}, loc); err != nil {
+		t.Fatal(err)
+	}
+	if loc.Range.Start != at(fixed, "helper(x)", 7) {
+		t.Errorf("Wrong definition for parameter x: %+v", loc)
+	}
+
+	// Hover
+	hover := &Hover{}
+	if err := c.call("textDocument/hover", TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: at(fixed, "format", 2)}, hover); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(hover.Contents.Value, "string.format(format, ...)") {
+		t.Errorf("Wrong hover for string.format: %v", hover.Contents.Value)
+	}
+
+	// Completion
+	list := &CompletionList{}
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentItem{URI: uri, Version: 4},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "local s = 1\nstring.tr"}},
+	})
+	c.diagnostics()
+	if err := c.call("textDocument/completion", TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{Line: 1, Character: 9}}, list); err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, item := range list.Items {
+		found[item.Label] = true
+	}
+	if !found["trim"] || !found["hasprefix"] || found["print"] {
+		t.Errorf("Wrong completions for string members: %v", list.Items)
+	}
+	if err := c.call("textDocument/completion", TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{Line: 1, Character: 0}}, list); err != nil {
+		t.Fatal(err)
+	}
+	found = map[string]bool{}
+	for _, item := range list.Items {
+		found[item.Label] = true
+	}
+	if !found["s"] || !found["getiter"] || !found["string"] {
+		t.Errorf("Wrong completions for globals: %v", list.Items)
+	}
+
+	// Unknown methods are errors, shutdown and exit stop the server.
+	if err := c.call("workspace/symbol", map[string]interface{}{}, nil); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("Expected method not found, got: %v", err)
+	}
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("Server did not exit cleanly: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "bytes"
+import "fmt"
+import "go/format"
+import "strings"
+
+// generate writes the bindings for a package.
+func generate(pkg *pkgInfo, open string) ([]byte, error) {
+	g := &generator{pkg: pkg, lua: pkg.luaPath}
+
+	g.printf("// %v creates the metatables for the exported types and returns a table holding the exported functions.\n", open)
+	g.printf("func %v(l *%v.State) int {\n", open, g.lua)
+	for _, t := range pkg.types {
+		g.printf("luabindMeta%v(l)\n", t.name)
+	}
+	g.printf("l.NewTable(0, %v)\n", len(pkg.funcs))
+	if len(pkg.funcs) > 0 {
+		g.printf("l.SetTableFunctions(-1, map[string]%v.NativeFunction{\n", g.lua)
+		for _, f := range pkg.funcs {
+			g.printf("%q: luabindFunc%v,\n", f.luaName, f.name)
+		}
+		g.printf("})\n")
+	}
+	g.printf("return 1\n}\n\n")
+
+	for _, f := range pkg.funcs {
+		g.function(f)
+	}
+	for _, t := range pkg.types {
+		g.userType(t)
+	}
+
+	g.printf(`func luabindArgError(l *%v.State, n int, fname, expected string) {
+	got := "no value"
+	if n <= l.AbsIndex(-1) {
+		got = l.TypeOf(n).String()
+	}
+	l.Push(fmt.Sprintf("bad argument #%%d to '%%s' (%%s expected, got %%s)", n, fname, expected, got))
+	l.Error()
+}
+`, g.lua)
+	if g.uints {
+		g.printf(`
+func luabindPushUint(l *%v.State, v uint64) {
+	if v > math.MaxInt64 {
+		l.Push(fmt.Sprintf("result %%d does not fit in a Lua integer", v))
+		l.Error()
+	}
+	l.Push(int64(v))
+}
+`, g.lua)
+	}
+
+	// The imports depend on what was used, so the header is written last.
+	body := g.buf.String()
+	g.buf.Reset()
+	g.printf("// Code generated by dcluabind. DO NOT EDIT.\n\n")
+	g.printf("package %v\n\n", pkg.name)
+	g.printf("import \"fmt\"\n")
+	if g.math {
+		g.printf("import \"math\"\n")
+	}
+	if g.lua == "lua" {
+		g.printf("\nimport %q\n\n", luaImport)
+	} else {
+		g.printf("\nimport %v %q\n\n", g.lua, luaImport)
+	}
+	g.buf.WriteString(body)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Generated invalid code (this is a bug): %v", err)
+	}
+	return src, nil
+}
+
+type generator struct {
+	buf bytes.Buffer
+	pkg *pkgInfo
+	lua string
+
+	math  bool // The math package is needed.
+	uints bool // luabindPushUint is needed.
+}
+
+func (g *generator) printf(format string, v ...interface{}) {
+	fmt.Fprintf(&g.buf, format, v...)
+}
+
+// function writes the NativeFunction wrapper for a function or method.
+func (g *generator) function(f *boundFunc) {
+	fname := f.luaName
+	call := f.name
+	n := 1
+	if f.recv != nil {
+		fname = f.recv.luaName + ":" + f.luaName
+		g.printf("func luabindMethod%v%v(l *%v.State) int {\n", f.recv.name, f.name, g.lua)
+		g.printf("self := luabindCheck%v(l, 1, %q)\n", f.recv.name, fname)
+		call = "self." + f.name
+		n = 2
+	} else {
+		g.printf("func luabindFunc%v(l *%v.State) int {\n", f.name, g.lua)
+	}
+
+	args := []string{}
+	for i, p := range f.params {
+		arg := fmt.Sprintf("a%v", i)
+		if p.kind == kState {
+			args = append(args, "l")
+			continue
+		}
+		g.check(p, arg, n, fmt.Sprintf("%q", fname))
+		args = append(args, arg)
+		n++
+	}
+
+	rtns := []string{}
+	for i := range f.results {
+		rtns = append(rtns, fmt.Sprintf("r%v", i))
+	}
+	if f.err {
+		rtns = append(rtns, "err")
+	}
+	if len(rtns) > 0 {
+		g.printf("%v := ", strings.Join(rtns, ", "))
+	}
+	g.printf("%v(%v)\n", call, strings.Join(args, ", "))
+	if f.err {
+		g.printf("if err != nil {\nl.Push(err.Error())\nl.Error()\n}\n")
+	}
+	for i, r := range f.results {
+		g.push(r, fmt.Sprintf("r%v", i))
+	}
+	g.printf("return %v\n}\n\n", len(f.results))
+}
+
+// check writes code that checks the Lua value at index n and stores it in a new variable.
+func (g *generator) check(t goType, v string, n interface{}, fname string) {
+	switch t.kind {
+	case kBool:
+		g.printf("if l.TypeOf(%v) != %v.TypBool {\n", n, g.lua)
+		g.printf("luabindArgError(l, %v, %v, \"boolean\")\n}\n", n, fname)
+		g.printf("%v := %v\n", v, conv(t, "bool", fmt.Sprintf("l.ToBool(%v)", n)))
+	case kString, kBytes:
+		g.printf("if t := l.TypeOf(%v); t != %v.TypString && t != %v.TypNumber {\n", n, g.lua, g.lua)
+		g.printf("luabindArgError(l, %v, %v, \"string\")\n}\n", n, fname)
+		canon := "string"
+		if t.kind == kBytes {
+			canon = "[]byte"
+		}
+		g.printf("%v := %v\n", v, conv(t, canon, conv(goType{expr: canon}, "string", fmt.Sprintf("l.ToString(%v)", n))))
+	case kInt, kUint:
+		// Converting back catches values that do not fit in the parameter type.
+		g.printf("%vv, ok := l.TryInt(%v)\n", v, n)
+		g.printf("%v := %v(%vv)\n", v, t.expr, v)
+		if t.kind == kUint {
+			g.printf("if !ok || %vv < 0 || int64(%v) != %vv {\n", v, v, v)
+		} else {
+			g.printf("if !ok || int64(%v) != %vv {\n", v, v)
+		}
+		g.printf("luabindArgError(l, %v, %v, \"integer\")\n}\n", n, fname)
+	case kFloat:
+		// Only float32 can overflow to infinity.
+		g.math = true
+		g.printf("%vv, ok := l.TryFloat(%v)\n", v, n)
+		g.printf("%v := %v(%vv)\n", v, t.expr, v)
+		g.printf("if !ok || math.IsInf(float64(%v), 0) && !math.IsInf(%vv, 0) {\n", v, v)
+		g.printf("luabindArgError(l, %v, %v, \"number\")\n}\n", n, fname)
+	case kUser:
+		g.printf("%v := luabindCheck%v(l, %v, %v)\n", v, t.user.name, n, fname)
+	case kUserVal:
+		g.printf("%v := *luabindCheck%v(l, %v, %v)\n", v, t.user.name, n, fname)
+	}
+}
+
+// push writes code that pushes a Go value.
+func (g *generator) push(t goType, v string) {
+	switch t.kind {
+	case kBool:
+		g.printf("l.Push(%v)\n", conv(goType{expr: "bool"}, t.expr, v))
+	case kString:
+		g.printf("l.Push(%v)\n", conv(goType{expr: "string"}, t.expr, v))
+	case kBytes:
+		g.printf("l.Push(string(%v))\n", v)
+	case kInt:
+		g.printf("l.Push(int64(%v))\n", v)
+	case kUint:
+		g.math, g.uints = true, true
+		g.printf("luabindPushUint(l, uint64(%v))\n", v)
+	case kFloat:
+		g.printf("l.Push(float64(%v))\n", v)
+	case kUser:
+		g.printf("luabindPush%v(l, %v)\n", t.user.name, v)
+	case kUserVal:
+		g.printf("luabindPush%v(l, &%v)\n", t.user.name, v)
+	}
+}
+
+// userType writes the metatable, check, and push functions for a type, as well as wrappers for its methods.
+func (g *generator) userType(t *boundType) {
+	key := fmt.Sprintf("dcluabind.%v.%v", g.pkg.name, t.name)
+
+	g.printf("func luabindMeta%v(l *%v.State) {\n", t.name, g.lua)
+	g.printf("l.Push(%q)\n", key)
+	g.printf("l.NewTable(0, 3)\n")
+	g.printf("l.SetTableFunctions(-1, map[string]%v.NativeFunction{\n", g.lua)
+	g.printf("\"__index\": luabindIndex%v,\n", t.name)
+	g.printf("\"__newindex\": luabindNewIndex%v,\n", t.name)
+	g.printf("\"__tostring\": func(l *%v.State) int {\n", g.lua)
+	g.printf("l.Push(fmt.Sprintf(\"%v: %%p\", luabindCheck%v(l, 1, \"__tostring\")))\nreturn 1\n},\n", t.luaName, t.name)
+	g.printf("})\n")
+	g.printf("l.SetTableRaw(%v.RegistryIndex)\n}\n\n", g.lua)
+
+	g.printf("func luabindPush%v(l *%v.State, v *%v) {\n", t.name, g.lua, t.name)
+	g.printf("if v == nil {\nl.Push(nil)\nreturn\n}\n")
+	g.printf("l.Push(v)\nl.Push(%q)\nl.GetTableRaw(%v.RegistryIndex)\nl.SetMetaTable(-2)\n}\n\n", key, g.lua)
+
+	g.printf("func luabindCheck%v(l *%v.State, n int, fname string) *%v {\n", t.name, g.lua, t.name)
+	g.printf("if l.TypeOf(n) == %v.TypUserData {\n", g.lua)
+	g.printf("if v, ok := l.ToUser(n).(*%v); ok {\nreturn v\n}\n}\n", t.name)
+	g.printf("luabindArgError(l, n, fname, %q)\nreturn nil\n}\n\n", t.luaName)
+
+	g.printf("func luabindIndex%v(l *%v.State) int {\n", t.name, g.lua)
+	g.printf("self := luabindCheck%v(l, 1, \"__index\")\n", t.name)
+	g.printf("switch l.ToString(2) {\n")
+	for _, m := range t.methods {
+		g.printf("case %q:\nl.Push(luabindMethod%v%v)\nreturn 1\n", m.luaName, t.name, m.name)
+	}
+	for _, f := range t.fields {
+		g.printf("case %q:\n", f.name)
+		g.push(f.typ, "self."+f.name)
+		g.printf("return 1\n")
+	}
+	g.printf("}\n")
+	if len(t.fields) == 0 {
+		g.printf("_ = self\n")
+	}
+	g.printf("return 0\n}\n\n")
+
+	g.printf("func luabindNewIndex%v(l *%v.State) int {\n", t.name, g.lua)
+	g.printf("self := luabindCheck%v(l, 1, \"__newindex\")\n", t.name)
+	g.printf("switch k := l.ToString(2); k {\n")
+	for _, f := range t.fields {
+		g.printf("case %q:\n", f.name)
+		g.check(f.typ, "v", 3, fmt.Sprintf("%q", t.luaName+"."+f.name))
+		g.printf("self.%v = v\nreturn 0\n", f.name)
+	}
+	g.printf("default:\n")
+	if len(t.fields) == 0 {
+		g.printf("_ = self\n")
+	}
+	g.printf("l.Push(fmt.Sprintf(\"%v has no field named %%q.\", k))\nl.Error()\n}\nreturn 0\n}\n\n", t.luaName)
+
+	for _, m := range t.methods {
+		g.function(m)
+	}
+}
+
+// conv wraps an expression of type from in a conversion to t, if needed.
+func conv(t goType, from, v string) string {
+	if t.expr == from {
+		return v
+	}
+	return fmt.Sprintf("%v(%v)", t.expr, v)
+}
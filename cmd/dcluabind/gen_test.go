@@ -0,0 +1,69 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "bytes"
+import "os"
+import "testing"
+
+// The example package's bindings are checked in, so this makes sure they are up to date (and therefore that the
+// tests in the example package test the current generator).
+func TestGenerateExample(t *testing.T) {
+	pkg, err := parsePackage("internal/example", "lua_bind.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.warnings) != 1 {
+		t.Errorf("Expected one warning (for Counter.Each), got: %q", pkg.warnings)
+	}
+
+	src, err := generate(pkg, "OpenLua")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := os.ReadFile("internal/example/lua_bind.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, old) {
+		t.Error("internal/example/lua_bind.go is out of date, run go generate in internal/example.")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/bad.go", []byte(`package bad
+
+//lua:export
+func Bad(f func()) {}
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parsePackage(dir, "lua_bind.go")
+	if err == nil {
+		t.Error("Expected an error for an unsupported parameter type.")
+	}
+}
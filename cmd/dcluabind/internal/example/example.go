@@ -0,0 +1,96 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package example is used to test dcluabind. The bindings in lua_bind.go are generated from this file.
+package example
+
+import "errors"
+import "strings"
+
+import "github.com/milochristiansen/lua"
+
+//go:generate go run github.com/milochristiansen/lua/cmd/dcluabind
+
+// Celsius is a named basic type, it is passed to and from Lua as a number.
+type Celsius float64
+
+// Counter is exported to Lua as userdata.
+//
+//lua:export
+type Counter struct {
+	N     int
+	Label string
+	Temp  Celsius
+	Level uint8
+	Ratio float32
+	Hits  uint64
+	On    bool
+
+	hidden int
+}
+
+// NewCounter creates a new Counter.
+//
+//lua:export new_counter
+func NewCounter(start int, label string) *Counter {
+	return &Counter{N: start, Label: label}
+}
+
+// Add adds to the counter and returns the new value.
+func (c *Counter) Add(n int) int {
+	c.N += n
+	return c.N
+}
+
+// Check returns an error if the counter is over max.
+func (c *Counter) Check(max int) (bool, error) {
+	if c.N > max {
+		return false, errors.New("counter too big")
+	}
+	return true, nil
+}
+
+// Copy returns a copy of the counter.
+func (c Counter) Copy() Counter {
+	return c
+}
+
+// Each is skipped, since it takes a function.
+func (c *Counter) Each(f func(int)) {}
+
+// Split returns the parts of a string and how many there were.
+//
+//lua:export split
+func Split(s, sep string) (string, string, int) {
+	parts := strings.SplitN(s, sep, 2)
+	if len(parts) == 1 {
+		return parts[0], "", 1
+	}
+	return parts[0], parts[1], 2
+}
+
+// Stack returns the number of values on the caller's stack.
+//
+//lua:export
+func Stack(l *lua.State, b []byte) int {
+	return l.AbsIndex(-1) + len(b)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package example_test
+
+import "testing"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/cmd/dcluabind/internal/example"
+import "github.com/milochristiansen/lua/testhelp"
+
+func TestBindings(t *testing.T) {
+	l := testhelp.MkState()
+	l.Require("example", example.OpenLua, true)
+
+	testhelp.AssertBlock(t, l, `
+local c = example.new_counter(5, "apples")
+assert(c.N == 5 and c.Label == "apples")
+assert(c:Add(2) == 7)
+assert(c:Check(10) == true)
+
+local ok, err = pcall(c.Check, c, 1)
+assert(not ok and err:find("counter too big"))
+
+c.Temp = 21.5
+assert(c.Temp == 21.5)
+assert(c.hidden == nil)
+assert(not pcall(function() c.hidden = 1 end))
+
+c.Level = 255
+assert(c.Level == 255)
+local ok, err = pcall(function() c.Level = 256 end)
+assert(not ok and err:find("bad argument #3 to 'Counter.Level' (integer expected, got number)"))
+assert(not pcall(function() c.Level = -1 end))
+assert(c.Level == 255)
+
+c.Ratio = 0.5
+assert(c.Ratio == 0.5)
+local ok, err = pcall(function() c.Ratio = 1e300 end)
+assert(not ok and err:find("bad argument #3 to 'Counter.Ratio' (number expected, got number)"))
+
+c.On = true
+assert(c.On == true)
+local ok, err = pcall(function() c.On = 1 end)
+assert(not ok and err:find("bad argument #3 to 'Counter.On' (boolean expected, got number)"))
+assert(c.On == true)
+
+local d = c:Copy()
+d:Add(1)
+assert(c.N == 7 and d.N == 8)
+assert(tostring(c):find("Counter: 0x") == 1)
+
+local a, b, n = example.split("a=b", "=")
+assert(a == "a" and b == "b" and n == 2)
+
+local ok, err = pcall(example.new_counter, "x")
+assert(not ok and err:find("bad argument #1 to 'new_counter' (integer expected, got string)"))
+local ok, err = pcall(c.Add)
+assert(not ok and err:find("Counter expected, got no value"))
+
+return example.Stack("abc")
+	`, 4)
+}
+
+func TestUintResult(t *testing.T) {
+	l := testhelp.MkState()
+	l.Require("example", example.OpenLua, true)
+
+	testhelp.AssertBlock(t, l, `
+c = example.new_counter(0, "")
+return c.Hits
+	`, 0)
+
+	l.Push("c")
+	l.GetTable(lua.GlobalsIndex)
+	l.ToUser(-1).(*example.Counter).Hits = 1 << 63
+	testhelp.AssertBlock(t, l, `
+local ok, err = pcall(function() return c.Hits end)
+return not ok and err:find("does not fit in a Lua integer") ~= nil
+	`, true)
+}
@@ -0,0 +1,249 @@
+// Code generated by dcluabind. DO NOT EDIT.
+
+package example
+
+import "fmt"
+import "math"
+
+import "github.com/milochristiansen/lua"
+
+// OpenLua creates the metatables for the exported types and returns a table holding the exported functions.
+func OpenLua(l *lua.State) int {
+	luabindMetaCounter(l)
+	l.NewTable(0, 3)
+	l.SetTableFunctions(-1, map[string]lua.NativeFunction{
+		"new_counter": luabindFuncNewCounter,
+		"split":       luabindFuncSplit,
+		"Stack":       luabindFuncStack,
+	})
+	return 1
+}
+
+func luabindFuncNewCounter(l *lua.State) int {
+	a0v, ok := l.TryInt(1)
+	a0 := int(a0v)
+	if !ok || int64(a0) != a0v {
+		luabindArgError(l, 1, "new_counter", "integer")
+	}
+	if t := l.TypeOf(2); t != lua.TypString && t != lua.TypNumber {
+		luabindArgError(l, 2, "new_counter", "string")
+	}
+	a1 := l.ToString(2)
+	r0 := NewCounter(a0, a1)
+	luabindPushCounter(l, r0)
+	return 1
+}
+
+func luabindFuncSplit(l *lua.State) int {
+	if t := l.TypeOf(1); t != lua.TypString && t != lua.TypNumber {
+		luabindArgError(l, 1, "split", "string")
+	}
+	a0 := l.ToString(1)
+	if t := l.TypeOf(2); t != lua.TypString && t != lua.TypNumber {
+		luabindArgError(l, 2, "split", "string")
+	}
+	a1 := l.ToString(2)
+	r0, r1, r2 := Split(a0, a1)
+	l.Push(r0)
+	l.Push(r1)
+	l.Push(int64(r2))
+	return 3
+}
+
+func luabindFuncStack(l *lua.State) int {
+	if t := l.TypeOf(1); t != lua.TypString && t != lua.TypNumber {
+		luabindArgError(l, 1, "Stack", "string")
+	}
+	a1 := []byte(l.ToString(1))
+	r0 := Stack(l, a1)
+	l.Push(int64(r0))
+	return 1
+}
+
+func luabindMetaCounter(l *lua.State) {
+	l.Push("dcluabind.example.Counter")
+	l.NewTable(0, 3)
+	l.SetTableFunctions(-1, map[string]lua.NativeFunction{
+		"__index":    luabindIndexCounter,
+		"__newindex": luabindNewIndexCounter,
+		"__tostring": func(l *lua.State) int {
+			l.Push(fmt.Sprintf("Counter: %p", luabindCheckCounter(l, 1, "__tostring")))
+			return 1
+		},
+	})
+	l.SetTableRaw(lua.RegistryIndex)
+}
+
+func luabindPushCounter(l *lua.State, v *Counter) {
+	if v == nil {
+		l.Push(nil)
+		return
+	}
+	l.Push(v)
+	l.Push("dcluabind.example.Counter")
+	l.GetTableRaw(lua.RegistryIndex)
+	l.SetMetaTable(-2)
+}
+
+func luabindCheckCounter(l *lua.State, n int, fname string) *Counter {
+	if l.TypeOf(n) == lua.TypUserData {
+		if v, ok := l.ToUser(n).(*Counter); ok {
+			return v
+		}
+	}
+	luabindArgError(l, n, fname, "Counter")
+	return nil
+}
+
+func luabindIndexCounter(l *lua.State) int {
+	self := luabindCheckCounter(l, 1, "__index")
+	switch l.ToString(2) {
+	case "Add":
+		l.Push(luabindMethodCounterAdd)
+		return 1
+	case "Check":
+		l.Push(luabindMethodCounterCheck)
+		return 1
+	case "Copy":
+		l.Push(luabindMethodCounterCopy)
+		return 1
+	case "N":
+		l.Push(int64(self.N))
+		return 1
+	case "Label":
+		l.Push(self.Label)
+		return 1
+	case "Temp":
+		l.Push(float64(self.Temp))
+		return 1
+	case "Level":
+		luabindPushUint(l, uint64(self.Level))
+		return 1
+	case "Ratio":
+		l.Push(float64(self.Ratio))
+		return 1
+	case "Hits":
+		luabindPushUint(l, uint64(self.Hits))
+		return 1
+	case "On":
+		l.Push(self.On)
+		return 1
+	}
+	return 0
+}
+
+func luabindNewIndexCounter(l *lua.State) int {
+	self := luabindCheckCounter(l, 1, "__newindex")
+	switch k := l.ToString(2); k {
+	case "N":
+		vv, ok := l.TryInt(3)
+		v := int(vv)
+		if !ok || int64(v) != vv {
+			luabindArgError(l, 3, "Counter.N", "integer")
+		}
+		self.N = v
+		return 0
+	case "Label":
+		if t := l.TypeOf(3); t != lua.TypString && t != lua.TypNumber {
+			luabindArgError(l, 3, "Counter.Label", "string")
+		}
+		v := l.ToString(3)
+		self.Label = v
+		return 0
+	case "Temp":
+		vv, ok := l.TryFloat(3)
+		v := Celsius(vv)
+		if !ok || math.IsInf(float64(v), 0) && !math.IsInf(vv, 0) {
+			luabindArgError(l, 3, "Counter.Temp", "number")
+		}
+		self.Temp = v
+		return 0
+	case "Level":
+		vv, ok := l.TryInt(3)
+		v := uint8(vv)
+		if !ok || vv < 0 || int64(v) != vv {
+			luabindArgError(l, 3, "Counter.Level", "integer")
+		}
+		self.Level = v
+		return 0
+	case "Ratio":
+		vv, ok := l.TryFloat(3)
+		v := float32(vv)
+		if !ok || math.IsInf(float64(v), 0) && !math.IsInf(vv, 0) {
+			luabindArgError(l, 3, "Counter.Ratio", "number")
+		}
+		self.Ratio = v
+		return 0
+	case "Hits":
+		vv, ok := l.TryInt(3)
+		v := uint64(vv)
+		if !ok || vv < 0 || int64(v) != vv {
+			luabindArgError(l, 3, "Counter.Hits", "integer")
+		}
+		self.Hits = v
+		return 0
+	case "On":
+		if l.TypeOf(3) != lua.TypBool {
+			luabindArgError(l, 3, "Counter.On", "boolean")
+		}
+		v := l.ToBool(3)
+		self.On = v
+		return 0
+	default:
+		l.Push(fmt.Sprintf("Counter has no field named %q.", k))
+		l.Error()
+	}
+	return 0
+}
+
+func luabindMethodCounterAdd(l *lua.State) int {
+	self := luabindCheckCounter(l, 1, "Counter:Add")
+	a0v, ok := l.TryInt(2)
+	a0 := int(a0v)
+	if !ok || int64(a0) != a0v {
+		luabindArgError(l, 2, "Counter:Add", "integer")
+	}
+	r0 := self.Add(a0)
+	l.Push(int64(r0))
+	return 1
+}
+
+func luabindMethodCounterCheck(l *lua.State) int {
+	self := luabindCheckCounter(l, 1, "Counter:Check")
+	a0v, ok := l.TryInt(2)
+	a0 := int(a0v)
+	if !ok || int64(a0) != a0v {
+		luabindArgError(l, 2, "Counter:Check", "integer")
+	}
+	r0, err := self.Check(a0)
+	if err != nil {
+		l.Push(err.Error())
+		l.Error()
+	}
+	l.Push(r0)
+	return 1
+}
+
+func luabindMethodCounterCopy(l *lua.State) int {
+	self := luabindCheckCounter(l, 1, "Counter:Copy")
+	r0 := self.Copy()
+	luabindPushCounter(l, &r0)
+	return 1
+}
+
+func luabindArgError(l *lua.State, n int, fname, expected string) {
+	got := "no value"
+	if n <= l.AbsIndex(-1) {
+		got = l.TypeOf(n).String()
+	}
+	l.Push(fmt.Sprintf("bad argument #%d to '%s' (%s expected, got %s)", n, fname, expected, got))
+	l.Error()
+}
+
+func luabindPushUint(l *lua.State, v uint64) {
+	if v > math.MaxInt64 {
+		l.Push(fmt.Sprintf("result %d does not fit in a Lua integer", v))
+		l.Error()
+	}
+	l.Push(int64(v))
+}
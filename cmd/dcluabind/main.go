@@ -0,0 +1,103 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+Dcluabind generates type-safe native function bindings for a Go package.
+
+Usage:
+
+	dcluabind [flags] [dir]
+
+It is meant to be run by "go generate", add a line like the following to one of the package's files:
+
+	//go:generate dcluabind
+
+Functions and types are bound by marking them with a "lua:export" comment (optionally followed by the name to use
+in Lua, the default is the Go name):
+
+	//lua:export new_counter
+	func NewCounter(start int) *Counter { ... }
+
+	//lua:export
+	type Counter struct { ... }
+
+For each function a NativeFunction wrapper is generated that checks and converts its arguments, calls the
+function, and pushes its results. Multiple results are supported, and if the last result is an error it is raised
+as a Lua error instead of returned. Parameters and results may be bools, strings, byte slices (as Lua strings),
+integers, floats, named types defined in the package with one of those as the underlying type, or exported struct
+types (by value or pointer). A *lua.State parameter gets the State the function was called from and does not use up
+a script argument. Values that don't fit in the Go type (or, for unsigned results, in a Lua integer) are errors.
+
+Exported types become userdata with a metatable that allows scripts to call the type's exported methods with
+"obj:Method()" and to read and set the type's exported fields (if the field types are supported). Methods with
+unsupported signatures are skipped with a warning.
+
+The generated loader function (OpenLua by default) creates the type metatables and returns a table with all the
+exported functions, so it can be used with State.Require or State.Preload:
+
+	l.Require("counter", example.OpenLua, false)
+
+Flags:
+
+	-o file     The output file name (default "lua_bind.go").
+	-open name  The name of the generated loader function (default "OpenLua").
+
+Errors are logged to standard error.
+*/
+package main
+
+import "flag"
+import "log"
+import "os"
+import "path/filepath"
+
+func main() {
+	out := flag.String("o", "lua_bind.go", "The output file name.")
+	open := flag.String("open", "OpenLua", "The name of the generated loader function.")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("dcluabind: ")
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	pkg, err := parsePackage(dir, *out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, w := range pkg.warnings {
+		log.Print(w)
+	}
+
+	src, err := generate(pkg, *open)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, *out), src, 0666)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
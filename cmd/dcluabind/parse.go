@@ -0,0 +1,431 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "go/ast"
+import "go/parser"
+import "go/token"
+import "go/types"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+
+// kind is the category of a Go type as far as the bindings are concerned.
+type kind int
+
+const (
+	kBool kind = iota
+	kString
+	kBytes
+	kInt
+	kUint
+	kFloat
+	kError
+	kState   // *lua.State
+	kUser    // *T for an exported type
+	kUserVal // T for an exported type
+)
+
+// goType is a resolved parameter or result type.
+type goType struct {
+	kind kind
+	expr string     // The type as written in the source.
+	user *boundType // For kUser and kUserVal.
+}
+
+// boundFunc is a function or method to bind.
+type boundFunc struct {
+	name    string // Go name
+	luaName string
+	recv    *boundType // nil for functions
+	params  []goType
+	results []goType // Not including a trailing error.
+	err     bool     // The last result is an error.
+}
+
+// boundField is an exported struct field with a supported type.
+type boundField struct {
+	name string
+	typ  goType
+}
+
+// boundType is an exported type.
+type boundType struct {
+	name    string
+	luaName string
+	methods []*boundFunc
+	fields  []boundField
+}
+
+// pkgInfo is everything needed to generate the bindings for a package.
+type pkgInfo struct {
+	name     string
+	luaPath  string // The import name used for the lua package.
+	funcs    []*boundFunc
+	types    []*boundType
+	warnings []string
+}
+
+const luaImport = "github.com/milochristiansen/lua"
+
+// parsePackage reads the Go package in dir (ignoring tests and the output file) and finds everything marked for
+// export.
+func parsePackage(dir, out string) (*pkgInfo, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != filepath.Base(out)
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("Expected exactly one package in %v, found %v.", dir, len(pkgs))
+	}
+
+	var files []*ast.File
+	info := &pkgInfo{}
+	for name, p := range pkgs {
+		info.name = name
+		for _, f := range p.Files {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return fset.Position(files[i].Pos()).Filename < fset.Position(files[j].Pos()).Filename
+	})
+
+	r := &resolver{
+		fset:   fset,
+		basics: map[string]kind{},
+		named:  map[string]ast.Expr{},
+		types:  map[string]*boundType{},
+		info:   info,
+	}
+
+	// First pass: find types.
+	structs := map[string]*ast.StructType{}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				r.named[ts.Name.Name] = ts.Type
+
+				doc := ts.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				name, ok := exportName(doc)
+				if !ok {
+					continue
+				}
+				if !ts.Name.IsExported() {
+					return nil, r.errorf(ts.Pos(), "Type %v is marked for export but is not exported.", ts.Name.Name)
+				}
+				if _, ok := ts.Type.(*ast.StructType); !ok {
+					return nil, r.errorf(ts.Pos(), "Type %v is marked for export but is not a struct.", ts.Name.Name)
+				}
+				if name == "" {
+					name = ts.Name.Name
+				}
+				bt := &boundType{name: ts.Name.Name, luaName: name}
+				r.types[bt.name] = bt
+				info.types = append(info.types, bt)
+				structs[bt.name] = ts.Type.(*ast.StructType)
+			}
+		}
+	}
+
+	// Second pass: functions and methods.
+	for _, f := range files {
+		for _, imp := range f.Imports {
+			if strings.Trim(imp.Path.Value, `"`) == luaImport {
+				r.luaNames = append(r.luaNames, importName(imp))
+			}
+		}
+
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+
+			if fd.Recv != nil {
+				bt := r.types[recvName(fd.Recv.List[0].Type)]
+				if bt == nil || !fd.Name.IsExported() {
+					continue
+				}
+				bf, err := r.function(fd, bt)
+				if err != nil {
+					info.warnings = append(info.warnings, fmt.Sprintf("Skipping method %v.%v: %v", bt.name, fd.Name.Name, err))
+					continue
+				}
+				bt.methods = append(bt.methods, bf)
+				continue
+			}
+
+			name, ok := exportName(fd.Doc)
+			if !ok {
+				continue
+			}
+			if !fd.Name.IsExported() {
+				return nil, r.errorf(fd.Pos(), "Function %v is marked for export but is not exported.", fd.Name.Name)
+			}
+			bf, err := r.function(fd, nil)
+			if err != nil {
+				return nil, r.errorf(fd.Pos(), "Cannot bind function %v: %v", fd.Name.Name, err)
+			}
+			if name != "" {
+				bf.luaName = name
+			}
+			info.funcs = append(info.funcs, bf)
+		}
+	}
+
+	// Fields last, so that all types are known.
+	for _, bt := range info.types {
+		st := structs[bt.name]
+		if st == nil {
+			continue
+		}
+		for _, fld := range st.Fields.List {
+			for _, n := range fld.Names {
+				if !n.IsExported() {
+					continue
+				}
+				t, err := r.resolve(fld.Type)
+				if err != nil || t.kind == kError || t.kind == kState {
+					continue
+				}
+				bt.fields = append(bt.fields, boundField{name: n.Name, typ: t})
+			}
+		}
+	}
+
+	if len(info.funcs) == 0 && len(info.types) == 0 {
+		return nil, fmt.Errorf("Nothing in package %v is marked with a lua:export comment.", info.name)
+	}
+	info.luaPath = "lua"
+	if len(r.luaNames) > 0 {
+		info.luaPath = r.luaNames[0]
+	}
+	return info, nil
+}
+
+// exportName checks a doc comment for a lua:export line, returning the Lua name (if any) and true if found.
+func exportName(doc *ast.CommentGroup) (string, bool) {
+	if doc == nil {
+		return "", false
+	}
+	for _, c := range doc.List {
+		if c.Text == "//lua:export" {
+			return "", true
+		}
+		if strings.HasPrefix(c.Text, "//lua:export ") {
+			return strings.TrimSpace(strings.TrimPrefix(c.Text, "//lua:export ")), true
+		}
+	}
+	return "", false
+}
+
+func importName(imp *ast.ImportSpec) string {
+	if imp.Name != nil {
+		return imp.Name.Name
+	}
+	return "lua"
+}
+
+func recvName(e ast.Expr) string {
+	if s, ok := e.(*ast.StarExpr); ok {
+		e = s.X
+	}
+	if id, ok := e.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+type resolver struct {
+	fset     *token.FileSet
+	basics   map[string]kind       // Resolved named basic types.
+	named    map[string]ast.Expr   // All type declarations in the package.
+	types    map[string]*boundType // Exported types.
+	luaNames []string              // Names the lua package is imported as.
+	info     *pkgInfo
+}
+
+func (r *resolver) errorf(pos token.Pos, format string, v ...interface{}) error {
+	return fmt.Errorf("%v: %v", r.fset.Position(pos), fmt.Sprintf(format, v...))
+}
+
+// function resolves the signature of a function or method.
+func (r *resolver) function(fd *ast.FuncDecl, recv *boundType) (*boundFunc, error) {
+	bf := &boundFunc{name: fd.Name.Name, luaName: fd.Name.Name, recv: recv}
+
+	for _, p := range fd.Type.Params.List {
+		if _, ok := p.Type.(*ast.Ellipsis); ok {
+			return nil, fmt.Errorf("variadic functions are not supported")
+		}
+		t, err := r.resolve(p.Type)
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == kError {
+			return nil, fmt.Errorf("error parameters are not supported")
+		}
+		n := len(p.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			bf.params = append(bf.params, t)
+		}
+	}
+
+	if fd.Type.Results != nil {
+		for _, p := range fd.Type.Results.List {
+			t, err := r.resolve(p.Type)
+			if err != nil {
+				return nil, err
+			}
+			if t.kind == kState {
+				return nil, fmt.Errorf("*lua.State results are not supported")
+			}
+			n := len(p.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				bf.results = append(bf.results, t)
+			}
+		}
+	}
+	for i, t := range bf.results {
+		if t.kind != kError {
+			continue
+		}
+		if i != len(bf.results)-1 {
+			return nil, fmt.Errorf("only the last result may be an error")
+		}
+		bf.err = true
+		bf.results = bf.results[:i]
+	}
+	return bf, nil
+}
+
+var builtins = map[string]kind{
+	"bool":    kBool,
+	"string":  kString,
+	"int":     kInt,
+	"int8":    kInt,
+	"int16":   kInt,
+	"int32":   kInt,
+	"int64":   kInt,
+	"rune":    kInt,
+	"uint":    kUint,
+	"uint8":   kUint,
+	"uint16":  kUint,
+	"uint32":  kUint,
+	"uint64":  kUint,
+	"uintptr": kUint,
+	"byte":    kUint,
+	"float32": kFloat,
+	"float64": kFloat,
+}
+
+// resolve works out the kind of a type expression.
+func (r *resolver) resolve(e ast.Expr) (goType, error) {
+	expr := types.ExprString(e)
+
+	switch t := e.(type) {
+	case *ast.Ident:
+		if t.Name == "error" {
+			return goType{kind: kError, expr: expr}, nil
+		}
+		if bt := r.types[t.Name]; bt != nil {
+			return goType{kind: kUserVal, expr: expr, user: bt}, nil
+		}
+		k, ok := r.basic(t.Name, 0)
+		if !ok {
+			return goType{}, fmt.Errorf("unsupported type %v", expr)
+		}
+		return goType{kind: k, expr: expr}, nil
+	case *ast.StarExpr:
+		if id, ok := t.X.(*ast.Ident); ok && r.types[id.Name] != nil {
+			return goType{kind: kUser, expr: expr, user: r.types[id.Name]}, nil
+		}
+		if sel, ok := t.X.(*ast.SelectorExpr); ok && sel.Sel.Name == "State" {
+			if pkg, ok := sel.X.(*ast.Ident); ok && r.isLua(pkg.Name) {
+				return goType{kind: kState, expr: expr}, nil
+			}
+		}
+	case *ast.ArrayType:
+		if t.Len == nil {
+			if id, ok := t.Elt.(*ast.Ident); ok && (id.Name == "byte" || id.Name == "uint8") {
+				return goType{kind: kBytes, expr: expr}, nil
+			}
+		}
+	}
+	return goType{}, fmt.Errorf("unsupported type %v", expr)
+}
+
+// basic resolves a builtin type name or a named type in the package with a basic underlying type.
+func (r *resolver) basic(name string, depth int) (kind, bool) {
+	if k, ok := r.basics[name]; ok {
+		return k, true
+	}
+	if k, ok := builtins[name]; ok {
+		return k, true
+	}
+	if depth > 100 {
+		return 0, false
+	}
+
+	e, ok := r.named[name]
+	if !ok {
+		return 0, false
+	}
+	id, ok := e.(*ast.Ident)
+	if !ok {
+		return 0, false
+	}
+	k, ok := r.basic(id.Name, depth+1)
+	if ok {
+		r.basics[name] = k
+	}
+	return k, ok
+}
+
+func (r *resolver) isLua(name string) bool {
+	for _, n := range r.luaNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
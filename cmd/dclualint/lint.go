@@ -0,0 +1,368 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "math"
+import "sort"
+import "strconv"
+
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/ast/scope"
+import "github.com/milochristiansen/lua/cmd/internal/luastd"
+import "github.com/milochristiansen/lua/luautil"
+
+// The names of the checks, used in the output and with -disable.
+const (
+	chkSyntax      = "syntax"
+	chkUndefined   = "undefined-global"
+	chkUnused      = "unused"
+	chkShadow      = "shadow"
+	chkUnreachable = "unreachable"
+	chkDupKey      = "duplicate-key"
+	chkArgCount    = "arg-count"
+)
+
+var allChecks = []string{chkSyntax, chkUndefined, chkUnused, chkShadow, chkUnreachable, chkDupKey, chkArgCount}
+
+// Diagnostic is a single problem found in a file.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Col     int
+	EndLine int
+	EndCol  int
+	Check   string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v:%v:%v: %v (%v)", d.File, d.Line, d.Col, d.Message, d.Check)
+}
+
+type config struct {
+	globals  map[string]bool            // Globals that may be read without being set.
+	fields   map[string]map[string]bool // Known fields in global tables.
+	disabled map[string]bool
+	opts     ast.ParseOptions
+}
+
+type linter struct {
+	cfg   *config
+	file  string
+	info  *scope.Info
+	diags []Diagnostic
+}
+
+func (l *linter) report(check string, span ast.Span, format string, a ...interface{}) {
+	if l.cfg.disabled[check] {
+		return
+	}
+	l.diags = append(l.diags, Diagnostic{
+		File:    l.file,
+		Line:    span.Start.Line,
+		Col:     span.Start.Col,
+		EndLine: span.End.Line,
+		EndCol:  span.End.Col,
+		Check:   check,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// lint checks a single file. Files with syntax errors are not checked any further, the partial AST produced by
+// the parser would result in lots of bogus warnings.
+func lint(file, src string, cfg *config) []Diagnostic {
+	l := &linter{cfg: cfg, file: file}
+
+	opts := cfg.opts
+	opts.Recover = true
+	block, err := ast.Parse(src, 1, opts)
+	if err != nil {
+		if errs, ok := err.(ast.ErrorList); ok {
+			for _, e := range errs {
+				l.report(chkSyntax, ast.Span{Start: e.Pos, End: e.Pos}, "%v", e.Msg)
+			}
+		} else {
+			l.report(chkSyntax, ast.Span{}, "%v", err)
+		}
+		return l.diags
+	}
+
+	l.info = scope.Analyze(block)
+	l.undefined(block)
+	l.unused()
+	l.shadowed()
+	l.unreachable(block)
+	for _, s := range block {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch nn := n.(type) {
+			case *ast.TableConstructor:
+				l.duplicateKeys(nn)
+			case *ast.FuncCall:
+				l.argCount(nn)
+			case *ast.DoBlock:
+				l.unreachable(nn.Block)
+			case *ast.If:
+				l.unreachable(nn.Then)
+				l.unreachable(nn.Else)
+			case *ast.WhileLoop:
+				l.unreachable(nn.Block)
+			case *ast.RepeatUntilLoop:
+				l.unreachable(nn.Block)
+			case *ast.ForLoopNumeric:
+				l.unreachable(nn.Block)
+			case *ast.ForLoopGeneric:
+				l.unreachable(nn.Block)
+			case *ast.FuncDecl:
+				l.unreachable(nn.Block)
+			}
+			return true
+		})
+	}
+
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i], l.diags[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	return l.diags
+}
+
+// undefined reports reads of unknown globals and unknown fields in the standard library tables.
+func (l *linter) undefined(block []ast.Stmt) {
+	for _, ref := range l.info.Undefined(l.cfg.globals) {
+		l.report(chkUndefined, ref.Ident.Span(), "undefined global %v", ref.Ident.Value)
+	}
+
+	// Fields may be added to the library tables by the script.
+	set := map[string]bool{}
+	for _, s := range block {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if a, ok := n.(*ast.Assign); ok && !a.LocalDecl && !a.LocalFunc {
+				for _, t := range a.Targets {
+					if name, ok := l.libField(t); ok {
+						set[name] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	for _, s := range block {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if name, ok := l.libField(n); ok && !set[name] {
+				lib, key := l.splitField(n.(*ast.TableAccessor))
+				if !l.cfg.fields[lib][key] {
+					l.report(chkUndefined, n.Span(), "undefined field %v", name)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// libField returns "lib.name" if n is a constant index into one of the standard library tables.
+func (l *linter) libField(n ast.Node) (string, bool) {
+	ta, ok := n.(*ast.TableAccessor)
+	if !ok {
+		return "", false
+	}
+	lib, key := l.splitField(ta)
+	if lib == "" || l.cfg.fields[lib] == nil {
+		return "", false
+	}
+	return lib + "." + key, true
+}
+
+func (l *linter) splitField(ta *ast.TableAccessor) (lib, key string) {
+	obj, ok1 := ta.Obj.(*ast.ConstIdent)
+	k, ok2 := ta.Key.(*ast.ConstString)
+	if !ok1 || !ok2 {
+		return "", ""
+	}
+	ref := l.info.Uses[obj]
+	if ref == nil || ref.Kind != scope.Global || ref.Env != nil {
+		return "", ""
+	}
+	return obj.Value, k.Value
+}
+
+func (l *linter) unused() {
+	for _, b := range l.info.Unused() {
+		what := "local"
+		switch b.Kind {
+		case scope.DeclLocalFunc:
+			what = "local function"
+		case scope.DeclParam:
+			what = "parameter"
+		case scope.DeclFor:
+			what = "loop variable"
+		}
+		l.report(chkUnused, declSpan(b), "unused %v %v", what, b.Name)
+	}
+}
+
+func (l *linter) shadowed() {
+	for _, b := range l.info.Shadowed() {
+		if b.Name[0] == '_' {
+			continue
+		}
+		l.report(chkShadow, declSpan(b), "%v shadows the local declared on line %v", b.Name, declSpan(b.Shadows).Start.Line)
+	}
+}
+
+func declSpan(b *scope.Binding) ast.Span {
+	if b.Ident != nil {
+		return b.Ident.Span()
+	}
+	return b.Decl.Span()
+}
+
+// unreachable reports the first statement after a statement that always jumps (return, break, goto, etc).
+// Labels may be the target of a goto, so they start a new reachable section.
+func (l *linter) unreachable(block []ast.Stmt) {
+	dead, reported := false, false
+	for _, s := range block {
+		if d, ok := s.(*ast.DoBlock); ok && d.Block == nil {
+			continue // Empty statement
+		}
+		if _, ok := s.(*ast.Label); ok {
+			dead, reported = false, false
+			continue
+		}
+		if dead && !reported {
+			l.report(chkUnreachable, s.Span(), "unreachable code")
+			reported = true
+		}
+		if jumps(s) {
+			dead = true
+		}
+	}
+}
+
+// jumps returns true if control never continues to the statement after s.
+func jumps(s ast.Stmt) bool {
+	switch nn := s.(type) {
+	case *ast.Return, *ast.Goto:
+		return true
+	case *ast.DoBlock:
+		return len(nn.Block) > 0 && jumps(nn.Block[len(nn.Block)-1])
+	case *ast.If:
+		return len(nn.Then) > 0 && len(nn.Else) > 0 && jumps(nn.Then[len(nn.Then)-1]) && jumps(nn.Else[len(nn.Else)-1])
+	}
+	return false
+}
+
+// duplicateKeys reports constant keys that appear more than once in a table constructor, including explicit
+// integer keys that collide with positional items.
+func (l *linter) duplicateKeys(t *ast.TableConstructor) {
+	seen := map[string]ast.Node{}
+	next := int64(1)
+	for i, k := range t.Keys {
+		var key string
+		var at ast.Node
+		if k == nil {
+			key, at = "n:"+strconv.FormatInt(next, 10), t.Vals[i]
+			next++
+		} else {
+			key, at = constKey(k), k
+		}
+		if key == "" {
+			continue
+		}
+		if first, ok := seen[key]; ok {
+			l.report(chkDupKey, at.Span(), "duplicate table key %v (first used on line %v)", key[2:], first.Span().Start.Line)
+			continue
+		}
+		seen[key] = at
+	}
+}
+
+// constKey returns a string that uniquely identifies a constant table key, or "" if the key is not a constant.
+// Floats with integer values are the same key as the integer.
+func constKey(k ast.Expr) string {
+	switch kk := k.(type) {
+	case *ast.ConstString:
+		return "s:" + strconv.Quote(kk.Value)
+	case *ast.ConstBool:
+		return "b:" + strconv.FormatBool(kk.Value)
+	case *ast.ConstInt:
+		if ok, _, i, _ := luautil.ConvNumber(kk.Value, true, false); ok {
+			return "n:" + strconv.FormatInt(i, 10)
+		}
+	case *ast.ConstFloat:
+		if ok, _, _, f := luautil.ConvNumber(kk.Value, false, true); ok {
+			if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+				return "n:" + strconv.FormatInt(int64(f), 10)
+			}
+			return "n:" + strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+	return ""
+}
+
+// argCount reports calls to standard functions with the wrong number of arguments. If the last argument is a
+// function call or "..." it may expand to any number of values, so only the minimum is known.
+func (l *linter) argCount(c *ast.FuncCall) {
+	if c.Receiver != nil {
+		return
+	}
+
+	name := ""
+	switch f := c.Function.(type) {
+	case *ast.ConstIdent:
+		if ref := l.info.Uses[f]; ref != nil && ref.Kind == scope.Global && ref.Env == nil {
+			name = f.Value
+		}
+	case *ast.TableAccessor:
+		name, _ = l.libField(f)
+	}
+	want := luastd.Items[name]
+	if want == nil || !want.Func {
+		return
+	}
+
+	got, open := len(c.Args), false
+	if got > 0 {
+		switch c.Args[got-1].(type) {
+		case *ast.FuncCall, *ast.ConstVariadic:
+			got, open = got-1, true
+		}
+	}
+
+	switch {
+	case got < want.Min && !open:
+		l.report(chkArgCount, c.Span(), "not enough arguments in call to %v (got %v, want %v)", name, got, arity(want))
+	case got > want.Max && want.Max >= 0:
+		l.report(chkArgCount, c.Span(), "too many arguments in call to %v (got %v, want %v)", name, got, arity(want))
+	}
+}
+
+func arity(f *luastd.Item) string {
+	switch {
+	case f.Max < 0:
+		return fmt.Sprintf("at least %v", f.Min)
+	case f.Min == f.Max:
+		return strconv.Itoa(f.Min)
+	}
+	return fmt.Sprintf("%v to %v", f.Min, f.Max)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "testing"
+
+import "github.com/milochristiansen/lua/cmd/internal/luastd"
+
+func TestLint(t *testing.T) {
+	globals, fields := luastd.Globals()
+	cfg := &config{globals: globals, fields: fields, disabled: map[string]bool{}}
+
+	src := `local unused = 1
+local function f(a, _b)
+	return a
+end
+local t = {1, 2, [1] = 3, x = 1, x = 2, [2.0] = 4}
+print(prnt, string.fromat("%d", 1), string.sub("x"))
+string.extra = 1
+print(string.extra, math.pi, tostring(1, 2), select("#", f()), t)
+while true do
+	break
+	print("dead")
+	::label::
+	print("alive")
+end
+do
+	local f = 1
+	print(f)
+end
+`
+	expect := []string{
+		"test.lua:1:7: unused local unused (unused)",
+		"test.lua:5:19: duplicate table key 1 (first used on line 5) (duplicate-key)",
+		"test.lua:5:34: duplicate table key \"x\" (first used on line 5) (duplicate-key)",
+		"test.lua:5:42: duplicate table key 2 (first used on line 5) (duplicate-key)",
+		"test.lua:6:7: undefined global prnt (undefined-global)",
+		"test.lua:6:13: undefined field string.fromat (undefined-global)",
+		"test.lua:6:37: not enough arguments in call to string.sub (got 1, want 2 to 3) (arg-count)",
+		"test.lua:8:30: too many arguments in call to tostring (got 2, want 1) (arg-count)",
+		"test.lua:11:2: unreachable code (unreachable)",
+		"test.lua:16:8: f shadows the local declared on line 2 (shadow)",
+	}
+
+	diags := lint("test.lua", src, cfg)
+	for i := 0; i < len(diags) || i < len(expect); i++ {
+		got, want := "<none>", "<none>"
+		if i < len(diags) {
+			got = diags[i].String()
+		}
+		if i < len(expect) {
+			want = expect[i]
+		}
+		if got != want {
+			t.Errorf("Problem %v:\n  got:  %v\n  want: %v", i, got, want)
+		}
+	}
+
+	// Disabled checks and syntax errors.
+	cfg.disabled[chkUndefined] = true
+	diags = lint("test.lua", "x = y\nx = = 1\n", cfg)
+	if len(diags) != 1 || diags[0].Check != chkSyntax || diags[0].Line != 2 {
+		t.Errorf("Wrong problems for syntax error: %v", diags)
+	}
+	if diags = lint("test.lua", "x = y", cfg); len(diags) != 0 {
+		t.Errorf("Disabled check was reported: %v", diags)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+Dclualint checks Lua scripts for common mistakes without running them.
+
+Usage:
+
+	dclualint [flags] [path ...]
+
+Each path may be a file or a directory, directories are searched for ".lua" files. If no paths are given the
+script is read from standard input.
+
+The following checks are done:
+
+	syntax            Syntax errors. Files with syntax errors are not checked any further.
+	undefined-global  Reads of globals that are never set, and of unknown fields in the standard library tables.
+	unused            Locals, local functions, parameters, and loop variables that are never read.
+	shadow            Locals that hide another local with the same name.
+	unreachable       Code after return, break, goto, or continue.
+	duplicate-key     Constant keys that appear more than once in a table constructor.
+	arg-count         Calls to standard library functions with the wrong number of arguments.
+
+Locals with names that start with an underscore are never reported as unused or shadowed.
+
+By default the globals defined by the lmod* packages (including the string extensions) are the only globals a
+script may read without setting them first. More may be added with -globals, or the defaults may be dropped with
+-std=false.
+
+Each problem is printed on its own line as "file:line:column: message (check)". With -json the problems are
+printed as a JSON array of objects with the fields File, Line, Col, EndLine, EndCol, Check, and Message.
+
+The exit status is 1 if any problems were found, and 2 if there was an error reading the input.
+
+Flags:
+
+	-globals list  Comma separated list of extra globals to allow.
+	-std           Allow the standard globals (default true).
+	-disable list  Comma separated list of checks to skip.
+	-continue      Allow the "continue" keyword.
+	-json          Print the problems as JSON.
+*/
+package main
+
+import "encoding/json"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/cmd/internal/luastd"
+
+func main() {
+	globals := flag.String("globals", "", "Comma separated list of extra globals to allow.")
+	std := flag.Bool("std", true, "Allow the standard globals.")
+	disable := flag.String("disable", "", "Comma separated list of checks to skip ("+strings.Join(allChecks, ", ")+").")
+	cont := flag.Bool("continue", false, "Allow the \"continue\" keyword.")
+	asJSON := flag.Bool("json", false, "Print the problems as JSON.")
+	flag.Parse()
+
+	cfg := &config{
+		globals:  map[string]bool{},
+		fields:   map[string]map[string]bool{},
+		disabled: map[string]bool{},
+		opts:     ast.ParseOptions{Continue: *cont},
+	}
+	if *std {
+		cfg.globals, cfg.fields = luastd.Globals()
+	}
+	for _, name := range split(*globals) {
+		cfg.globals[name] = true
+	}
+	for _, name := range split(*disable) {
+		cfg.disabled[name] = true
+	}
+
+	diags := []Diagnostic{}
+	failed := false
+	if flag.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		diags = append(diags, lint("<stdin>", string(src), cfg)...)
+	}
+	for _, path := range flag.Args() {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || file != path && filepath.Ext(file) != ".lua" {
+				return nil
+			}
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			diags = append(diags, lint(file, string(src), cfg)...)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.Encode(diags)
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+
+	switch {
+	case failed:
+		os.Exit(2)
+	case len(diags) > 0:
+		os.Exit(1)
+	}
+}
+
+func split(list string) []string {
+	rtn := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			rtn = append(rtn, item)
+		}
+	}
+	return rtn
+}
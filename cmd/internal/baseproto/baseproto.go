@@ -0,0 +1,61 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// The message framing shared by the Language Server Protocol and the Debug Adapter Protocol, for use by the tools in
+// cmd. Each message is a set of headers (only Content-Length is used) followed by a JSON body.
+package baseproto
+
+import "bufio"
+import "encoding/json"
+import "fmt"
+import "io"
+import "net/textproto"
+import "strconv"
+
+// Read reads a single message and returns its body.
+func Read(r *bufio.Reader) ([]byte, error) {
+	hdr, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(hdr.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Content-Length header: %v", err)
+	}
+
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Write encodes msg as JSON and writes it with the required headers.
+func Write(w io.Writer, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %v\r\n\r\n%s", len(body), body)
+	return err
+}
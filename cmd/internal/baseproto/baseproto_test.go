@@ -0,0 +1,49 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package baseproto
+
+import "bufio"
+import "bytes"
+import "testing"
+
+func TestRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	for _, msg := range []string{"a", "ü"} {
+		if err := Write(buf, map[string]string{"v": msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := bufio.NewReader(buf)
+	for _, want := range []string{`{"v":"a"}`, `{"v":"ü"}`} {
+		body, err := Read(r)
+		if err != nil || string(body) != want {
+			t.Errorf("Read: %q %v, want %q", body, err, want)
+		}
+	}
+
+	_, err := Read(bufio.NewReader(bytes.NewBufferString("Content-Length: x\r\n\r\n")))
+	if err == nil {
+		t.Errorf("Bad Content-Length not detected")
+	}
+}
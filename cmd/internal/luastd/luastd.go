@@ -0,0 +1,197 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Information about the standard library provided by the lmod* packages, for use by the tools in cmd.
+package luastd
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/lmodbase"
+import "github.com/milochristiansen/lua/lmodmath"
+import "github.com/milochristiansen/lua/lmodpackage"
+import "github.com/milochristiansen/lua/lmodstring"
+import "github.com/milochristiansen/lua/lmodtable"
+import "github.com/milochristiansen/lua/lmodutf8"
+
+// Globals returns the names of every global defined by the standard modules, along with the names of the
+// fields in every global table (string, math, etc). The string extensions are included.
+//
+// Rather than keep a list that can go out of date, this loads the modules into a new State and looks.
+func Globals() (globals map[string]bool, fields map[string]map[string]bool) {
+	l := lua.NewState()
+	for _, open := range []lua.NativeFunction{lmodbase.Open, lmodpackage.Open, lmodstring.Open, lmodtable.Open, lmodmath.Open, lmodutf8.Open} {
+		l.Push(open)
+		l.Call(0, 0)
+	}
+
+	globals = map[string]bool{}
+	fields = map[string]map[string]bool{}
+	l.PushIndex(lua.GlobalsIndex)
+	l.ForEachRaw(-1, func() bool {
+		if l.TypeOf(-2) != lua.TypString {
+			return true
+		}
+		name := l.ToString(-2)
+		globals[name] = true
+
+		// _G is left out, every global is a field in _G.
+		if l.TypeOf(-1) == lua.TypTable && name != "_G" {
+			fields[name] = map[string]bool{}
+			l.ForEachRaw(-1, func() bool {
+				if l.TypeOf(-2) == lua.TypString {
+					fields[name][l.ToString(-2)] = true
+				}
+				return true
+			})
+		}
+		return true
+	})
+	l.Pop(1)
+	return globals, fields
+}
+
+// Item describes a standard function or value.
+type Item struct {
+	Name string // The full name, for example "string.sub".
+	Sig  string // The signature for functions, or the type for values. Optional arguments are in brackets.
+	Doc  string
+
+	// The number of arguments a function takes. A Max of -1 means any number of extra arguments are allowed.
+	// Functions that ignore missing arguments (most of the string functions treat them as "") are still listed
+	// with the arguments they need to do anything useful.
+	Min, Max int
+
+	Func bool
+}
+
+// Items lists every standard function and value by full name. Library tables ("string", "math", etc) are
+// included as well.
+var Items = map[string]*Item{}
+
+func fn(name string, min, max int, sig, doc string) {
+	Items[name] = &Item{Name: name, Sig: sig, Doc: doc, Min: min, Max: max, Func: true}
+}
+
+func val(name, typ, doc string) {
+	Items[name] = &Item{Name: name, Sig: typ, Doc: doc}
+}
+
+func init() {
+	val("_G", "table", "The global environment.")
+	val("package", "table", "The package library, used by require.")
+	val("string", "table", "The string library. Strings also use this table for methods, so s:upper() is string.upper(s).")
+	val("table", "table", "The table library.")
+	val("math", "table", "The math library.")
+	val("utf8", "table", "The UTF-8 library.")
+
+	fn("assert", 1, -1, "assert(v [, message])", "Raises an error if v is false or nil, otherwise returns all of its arguments.")
+	fn("error", 1, 2, "error(message [, level])", "Raises an error with the given value. The level is currently ignored.")
+	fn("getiter", 1, 1, "getiter(t)", "Returns an iterator function for the table t. Each call returns the next key and value. Unlike next, the iterator is reentrant. This is not a standard Lua function.")
+	fn("getmetatable", 1, 1, "getmetatable(v)", "Returns the metatable of v, or its __metatable field if it has one.")
+	fn("ipairs", 1, 1, "ipairs(t)", "Returns an iterator over the integer keys of t, from 1 to the first nil value.")
+	fn("load", 1, 4, "load(chunk [, name [, mode [, env]]])", "Compiles a chunk given as a string or a function that returns pieces of it. Returns the compiled function, or nil and an error message.")
+	fn("next", 1, 2, "next(t [, key])", "Returns the key and value after key in t. Not reentrant, prefer pairs or getiter.")
+	fn("pairs", 1, 1, "pairs(t)", "Returns an iterator over every key and value in t, using the __pairs metamethod if there is one.")
+	fn("pcall", 1, -1, "pcall(f, ...)", "Calls f in protected mode. Returns true and the results of f, or false and the error message.")
+	fn("print", 0, -1, "print(...)", "Prints its arguments, converted with tostring and separated by tabs.")
+	fn("rawequal", 2, 2, "rawequal(a, b)", "Compares a and b without using the __eq metamethod.")
+	fn("rawget", 2, 2, "rawget(t, key)", "Gets t[key] without using the __index metamethod.")
+	fn("rawlen", 1, 1, "rawlen(v)", "Returns the length of a table or string without using the __len metamethod.")
+	fn("rawset", 3, 3, "rawset(t, key, value)", "Sets t[key] to value without using the __newindex metamethod.")
+	fn("require", 1, 1, "require(name)", "Loads the named module if it is not already loaded and returns it.")
+	fn("select", 1, -1, "select(n, ...)", "Returns all arguments after argument number n, or the number of extra arguments if n is \"#\".")
+	fn("setmetatable", 2, 2, "setmetatable(t, mt)", "Sets the metatable of t and returns t.")
+	fn("tonumber", 1, 1, "tonumber(v)", "Converts v to a number, returns nil if it cannot be converted. The base argument is not supported.")
+	fn("tostring", 1, 1, "tostring(v)", "Converts v to a string, using the __tostring metamethod if there is one.")
+	fn("type", 1, 1, "type(v)", "Returns the type of v as a string.")
+
+	val("package.loaded", "table", "Modules that have already been loaded, by name.")
+	val("package.preload", "table", "Loader functions for modules, by name.")
+	val("package.searchers", "table", "The functions require uses to find modules.")
+
+	val("math.huge", "number", "Positive infinity.")
+	val("math.maxinteger", "integer", "The largest integer.")
+	val("math.mininteger", "integer", "The smallest integer.")
+	val("math.pi", "number", "The value of pi.")
+	fn("math.abs", 1, 1, "math.abs(x)", "Returns the absolute value of x.")
+	fn("math.acos", 1, 1, "math.acos(x)", "Returns the arc cosine of x, in radians.")
+	fn("math.asin", 1, 1, "math.asin(x)", "Returns the arc sine of x, in radians.")
+	fn("math.atan", 1, 2, "math.atan(y [, x])", "Returns the arc tangent of y/x, in radians, using the signs of both to find the quadrant.")
+	fn("math.ceil", 1, 1, "math.ceil(x)", "Returns the smallest integer larger than or equal to x.")
+	fn("math.cos", 1, 1, "math.cos(x)", "Returns the cosine of x (in radians).")
+	fn("math.deg", 1, 1, "math.deg(x)", "Converts x from radians to degrees.")
+	fn("math.exp", 1, 1, "math.exp(x)", "Returns e raised to the power of x.")
+	fn("math.floor", 1, 1, "math.floor(x)", "Returns the largest integer smaller than or equal to x.")
+	fn("math.fmod", 2, 2, "math.fmod(x, y)", "Returns the remainder of dividing x by y, rounding the quotient towards zero.")
+	fn("math.log", 1, 2, "math.log(x [, base])", "Returns the logarithm of x in the given base (default e).")
+	fn("math.max", 1, -1, "math.max(x, ...)", "Returns the largest argument.")
+	fn("math.min", 1, -1, "math.min(x, ...)", "Returns the smallest argument.")
+	fn("math.modf", 1, 1, "math.modf(x)", "Returns the integral and fractional parts of x.")
+	fn("math.rad", 1, 1, "math.rad(x)", "Converts x from degrees to radians.")
+	fn("math.random", 0, 2, "math.random([m [, n]])", "Returns a random float in [0, 1), a random integer in [1, m], or a random integer in [m, n].")
+	fn("math.randomseed", 1, 1, "math.randomseed(x)", "Seeds the random number generator.")
+	fn("math.sin", 1, 1, "math.sin(x)", "Returns the sine of x (in radians).")
+	fn("math.sqrt", 1, 1, "math.sqrt(x)", "Returns the square root of x.")
+	fn("math.tan", 1, 1, "math.tan(x)", "Returns the tangent of x (in radians).")
+	fn("math.tointeger", 1, 1, "math.tointeger(x)", "Converts x to an integer if it can be represented exactly, otherwise returns nil.")
+	fn("math.type", 1, 1, "math.type(x)", "Returns \"integer\", \"float\", or nil if x is not a number.")
+	fn("math.ult", 2, 2, "math.ult(m, n)", "Returns true if m is less than n when compared as unsigned integers.")
+
+	fn("string.byte", 1, 3, "string.byte(s [, i [, j]])", "Returns the bytes s[i] through s[j] as integers.")
+	fn("string.char", 0, -1, "string.char(...)", "Returns a string made from the given bytes.")
+	fn("string.dump", 1, 2, "string.dump(f [, strip])", "Returns the binary chunk for the Lua function f.")
+	fn("string.find", 2, 3, "string.find(s, sub [, init])", "Returns the start and end of the first occurrence of sub in s, starting at init. Patterns are not supported.")
+	fn("string.format", 1, -1, "string.format(format, ...)", "Formats the arguments. Uses the format verbs from Go's fmt package, not the C ones.")
+	fn("string.len", 1, 1, "string.len(s)", "Returns the length of s in bytes.")
+	fn("string.lower", 1, 1, "string.lower(s)", "Returns s in lower case.")
+	fn("string.rep", 2, 3, "string.rep(s, n [, sep])", "Returns n copies of s, separated by sep.")
+	fn("string.reverse", 1, 1, "string.reverse(s)", "Returns s reversed.")
+	fn("string.sub", 2, 3, "string.sub(s, i [, j])", "Returns the substring from i to j (inclusive). Negative indexes count from the end.")
+	fn("string.upper", 1, 1, "string.upper(s)", "Returns s in upper case.")
+	fn("string.count", 2, 2, "string.count(s, sub)", "Returns the number of non-overlapping occurrences of sub in s. This is an extension.")
+	fn("string.hasprefix", 2, 2, "string.hasprefix(s, prefix)", "Returns true if s starts with prefix. This is an extension.")
+	fn("string.hassuffix", 2, 2, "string.hassuffix(s, suffix)", "Returns true if s ends with suffix. This is an extension.")
+	fn("string.join", 1, 2, "string.join(t [, sep])", "Joins the values in t with sep (default \", \"). This is an extension.")
+	fn("string.replace", 3, 4, "string.replace(s, old, new [, n])", "Replaces n occurrences of old with new in s. If n < 0 (the default) every occurrence is replaced. This is an extension.")
+	fn("string.split", 2, 3, "string.split(s, sep [, n])", "Splits s at every occurrence of sep, into at most n pieces if n > 0. This is an extension.")
+	fn("string.splitafter", 2, 3, "string.splitafter(s, sep [, n])", "Like string.split, but sep is kept at the end of each piece. This is an extension.")
+	fn("string.title", 1, 1, "string.title(s)", "Returns s with the first letter of every word in title case. This is an extension.")
+	fn("string.trim", 2, 2, "string.trim(s, cut)", "Returns s with any chars in cut removed from the beginning and end. This is an extension.")
+	fn("string.trimprefix", 2, 2, "string.trimprefix(s, prefix)", "Returns s without prefix, if it starts with prefix. This is an extension.")
+	fn("string.trimspace", 1, 1, "string.trimspace(s)", "Returns s with white space removed from the beginning and end. This is an extension.")
+	fn("string.trimsuffix", 2, 2, "string.trimsuffix(s, suffix)", "Returns s without suffix, if it ends with suffix. This is an extension.")
+	fn("string.unquote", 1, 1, "string.unquote(s)", "Interprets s as a quoted string literal (using Go syntax) and returns its value. This is an extension.")
+
+	fn("table.concat", 1, 4, "table.concat(t [, sep [, i [, j]]])", "Returns t[i] through t[j] joined with sep.")
+	fn("table.insert", 2, 3, "table.insert(t, [pos,] value)", "Inserts value into t at pos, or at the end.")
+	fn("table.move", 4, 5, "table.move(a1, f, e, t [, a2])", "Copies a1[f] through a1[e] to a2 starting at t, and returns a2 (default a1).")
+	fn("table.pack", 0, -1, "table.pack(...)", "Returns a new table with the arguments as items, and the number of arguments in the field n.")
+	fn("table.remove", 1, 2, "table.remove(t [, pos])", "Removes and returns the item at pos (default the last item).")
+	fn("table.sort", 1, 2, "table.sort(t [, comp])", "Sorts t in place, using comp(a, b) instead of < if given.")
+	fn("table.unpack", 1, 3, "table.unpack(t [, i [, j]])", "Returns t[i] through t[j].")
+
+	val("utf8.charpattern", "string", "A pattern that matches exactly one UTF-8 sequence.")
+	fn("utf8.char", 0, -1, "utf8.char(...)", "Returns a string made from the given code points.")
+	fn("utf8.codepoint", 1, 3, "utf8.codepoint(s [, i [, j]])", "Returns the code points of the characters that start between bytes i and j.")
+	fn("utf8.codes", 1, 1, "utf8.codes(s)", "Returns an iterator over the byte position and code point of each character in s.")
+	fn("utf8.len", 1, 3, "utf8.len(s [, i [, j]])", "Returns the number of characters that start between bytes i and j.")
+	fn("utf8.offset", 2, 3, "utf8.offset(s, n [, i])", "Returns the byte position where the nth character (counting from position i) starts.")
+}
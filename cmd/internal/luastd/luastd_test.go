@@ -0,0 +1,52 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package luastd
+
+import "testing"
+
+func TestItems(t *testing.T) {
+	globals, fields := Globals()
+	for name := range globals {
+		if Items[name] == nil {
+			t.Errorf("No information for %v", name)
+		}
+	}
+	for lib, fs := range fields {
+		for name := range fs {
+			if Items[lib+"."+name] == nil {
+				t.Errorf("No information for %v.%v", lib, name)
+			}
+		}
+	}
+	for name := range Items {
+		lib, field := name, ""
+		for i := range name {
+			if name[i] == '.' {
+				lib, field = name[:i], name[i+1:]
+			}
+		}
+		if field == "" && !globals[lib] || field != "" && !fields[lib][field] {
+			t.Errorf("%v is not defined by the standard modules", name)
+		}
+	}
+}
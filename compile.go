@@ -149,7 +149,16 @@ func (p patchList) loop(f *funcProto, pc, reg int) {
 	}
 }
 
-func compSource(source, name string, line int) (f *funcProto, err error) {
+func compSource(source, name string, line int, opts ast.ParseOptions) (*funcProto, error) {
+	block, err := ast.Parse(source, line, opts)
+	if err != nil {
+		return nil, err
+	}
+	return compAST(block, name)
+}
+
+// compAST compiles a block that has already been parsed (or validated) into the main function of a chunk.
+func compAST(block []ast.Stmt, name string) (f *funcProto, err error) {
 	// Quick-and-dirty error trapping.
 	defer func() {
 		if x := recover(); x != nil {
@@ -170,10 +179,6 @@ func compSource(source, name string, line int) (f *funcProto, err error) {
 	}()
 	//_ = fmt.Print
 
-	block, err := ast.Parse(source, line)
-	if err != nil {
-		return nil, err
-	}
 	return compile(&ast.FuncDecl{Source: name, IsVariadic: true, Block: block}, nil), nil
 }
 
@@ -581,11 +586,11 @@ func statement(n ast.Stmt, state *compState) {
 		state.breaks = append(state.breaks, patchList([]int{}))
 		state.continues = append(state.continues, patchList([]int{}))
 		preppedBlock(nn.Block, state, 2)
-		state.addInst(createABC(opTForCall, initReg, 0, len(nn.Locals)), nn.Line())
-		lbottom := len(state.f.code)
 		tmp := state.continues[len(state.continues)-1]
 		state.continues = state.continues[:len(state.continues)-1]
-		tmp.loop(state.f, len(state.f.code), state.nextReg+1)
+		tmp.loop(state.f, len(state.f.code), state.nextReg+1) // Continue needs to run the iterator, so jump to the TFORCALL.
+		state.addInst(createABC(opTForCall, initReg, 0, len(nn.Locals)), nn.Line())
+		lbottom := len(state.f.code)
 		state.addInst(createAsBx(opTForLoop, initReg+2, mkoffset(lbottom, ltop)), nn.Line())
 		tmp = state.breaks[len(state.breaks)-1]
 		state.breaks = state.breaks[:len(state.breaks)-1]
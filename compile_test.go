@@ -0,0 +1,133 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua_test
+
+import "testing"
+import "math"
+import "strings"
+
+import "github.com/milochristiansen/lua/ast"
+import "github.com/milochristiansen/lua/testhelp"
+
+// The tests in this file exercise compiler and parser features that are not part of standard Lua.
+
+func TestContinue(t *testing.T) {
+	l := testhelp.MkState()
+
+	err := l.LoadText(strings.NewReader(`
+local r = ""
+for i = 1, 6 do
+	if i % 2 == 0 then continue end
+	r = r..i
+end
+
+local i = 0
+while i < 6 do
+	i = i + 1
+	if i == 3 then continue end
+	r = r..i
+end
+
+for k, v in ipairs({"a", "b", "c"}) do
+	if v == "b" then continue end
+	r = r..v
+end
+
+local j = 0
+repeat
+	j = j + 1
+	if j == 2 then continue end
+	r = r..j
+until j >= 3
+return r
+	`), "continue", 0, ast.ParseOptions{Continue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.PCall(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testhelp.Assertf(t, l.ToString(-1) == "13512456ac13", "Wrong result: %v", l.ToString(-1))
+	l.Pop(1)
+
+	// Without the option "continue" is just a name.
+	testhelp.AssertBlock(t, l, `
+local continue = 5
+return continue
+	`, 5)
+
+	err = l.LoadText(strings.NewReader(`local continue = 5`), "continue", 0, ast.ParseOptions{Continue: true})
+	testhelp.Assert(t, err != nil, "Expected syntax error when using continue as a name.")
+
+	err = l.LoadText(strings.NewReader(`continue`), "continue", 0, ast.ParseOptions{Continue: true})
+	testhelp.Assert(t, err != nil, "Expected error for continue outside of a loop.")
+
+}
+
+func TestLoadAST(t *testing.T) {
+	l := testhelp.MkState()
+
+	// local function sum(...)
+	// 	local r = 0
+	// 	for _, v in ipairs({...}) do r = r + v end
+	// 	return r
+	// end
+	// return sum(1, 2, 3), <min int>, <inf>, <nan>
+	block := []ast.Stmt{
+		ast.NewLocalFunction("sum", ast.NewFunction(nil, true, []ast.Stmt{
+			ast.NewLocal([]string{"r"}, []ast.Expr{ast.NewInt(0)}),
+			ast.NewForGeneric([]string{"_", "v"}, []ast.Expr{
+				ast.NewCall(ast.NewIdent("ipairs"), ast.NewTable([]ast.Expr{nil}, []ast.Expr{ast.NewVariadic()})),
+			}, []ast.Stmt{
+				ast.NewAssign([]ast.Expr{ast.NewIdent("r")}, []ast.Expr{ast.NewBinaryOp(ast.OpAdd, ast.NewIdent("r"), ast.NewIdent("v"))}),
+			}),
+			ast.NewReturn(ast.NewIdent("r")),
+		})),
+		ast.NewReturn(
+			ast.NewCall(ast.NewIdent("sum"), ast.NewInt(1), ast.NewInt(2), ast.NewInt(3)),
+			ast.NewInt(math.MinInt64),
+			ast.NewFloat(math.Inf(-1)),
+			ast.NewFloat(math.NaN()),
+		),
+	}
+
+	err := l.LoadAST(block, "ast", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.PCall(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testhelp.Assertf(t, l.ToInt(-4) == 6, "Wrong result: %v", l.ToString(-4))
+	testhelp.Assertf(t, l.ToInt(-3) == math.MinInt64, "Wrong min int: %v", l.ToString(-3))
+	testhelp.Assertf(t, math.IsInf(l.ToFloat(-2), -1), "Wrong infinity: %v", l.ToString(-2))
+	testhelp.Assertf(t, math.IsNaN(l.ToFloat(-1)), "Wrong NaN: %v", l.ToString(-1))
+	l.Pop(4)
+
+	// Invalid trees are not compiled.
+	err = l.LoadAST([]ast.Stmt{&ast.Goto{Label: "break", IsBreak: true}}, "ast", 0)
+	_, ok := err.(ast.ValidationErrors)
+	testhelp.Assertf(t, ok, "Expected validation error, got: %v", err)
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua
+
+import "sort"
+
+// Coverage collects line and branch coverage for Lua code. Create one with NewCoverage and attach it to one or
+// more States with SetCoverage, then use Files to get the results (the coverage package can write them out in
+// several common formats).
+//
+// Only code loaded while a Coverage is attached is included in the results, so attach it before loading scripts.
+// A Coverage may be shared by several States, but they must not run at the same time.
+type Coverage struct {
+	funcs   map[*instruction]*funcCov // By the function's first instruction, since function values are copied.
+	sources map[string][]*funcCov
+}
+
+type funcCov struct {
+	proto    *funcProto
+	hits     []int64    // By pc.
+	branches [][2]int64 // By pc, only for branch instructions.
+}
+
+// FileCoverage is the coverage for one source (chunk name).
+type FileCoverage struct {
+	Source   string
+	Lines    []LineCoverage   // Every line with code, in order.
+	Branches []BranchCoverage // Every branch, in order by line.
+}
+
+// LineCoverage is the number of times a line was run.
+type LineCoverage struct {
+	Line int
+	Hits int64
+}
+
+// BranchCoverage is the number of times each way of a branch was taken.
+//
+// Branches are created by conditions (if, while, repeat, and, or, comparisons) and loops. For conditions Taken[0]
+// counts the times the condition jumped and Taken[1] the times it fell through (which is which depends on how the
+// compiler arranged the code). For loops Taken[0] counts the times the loop ran again and Taken[1] the times it
+// exited.
+type BranchCoverage struct {
+	Line  int
+	Block int // The index of the branch on its line, starting from 0.
+	Taken [2]int64
+}
+
+// Covered returns true if both ways of the branch were taken.
+func (b BranchCoverage) Covered() bool {
+	return b.Taken[0] > 0 && b.Taken[1] > 0
+}
+
+// NewCoverage creates a new, empty, Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		funcs:   map[*instruction]*funcCov{},
+		sources: map[string][]*funcCov{},
+	}
+}
+
+// SetCoverage attaches a Coverage to the State, or removes the current one if c is nil.
+func (l *State) SetCoverage(c *Coverage) {
+	l.coverage = c
+	l.setHooks()
+}
+
+// loaded is called by the State when a new chunk is loaded.
+func (c *Coverage) loaded(p *funcProto) {
+	if len(p.code) == 0 {
+		return
+	}
+	if _, ok := c.funcs[&p.code[0]]; ok {
+		return
+	}
+
+	fc := &funcCov{proto: p, hits: make([]int64, len(p.code)), branches: make([][2]int64, len(p.code))}
+	c.funcs[&p.code[0]] = fc
+	c.sources[p.source] = append(c.sources[p.source], fc)
+
+	for i := range p.prototypes {
+		c.loaded(&p.prototypes[i])
+	}
+}
+
+// isBranch returns true for instructions that are counted as branches.
+func isBranch(op opCode) bool {
+	switch op {
+	case opTest, opTestSet, OpEqual, OpLessThan, OpLessOrEqual, opForLoop, opTForLoop:
+		return true
+	}
+	return false
+}
+
+// hook is called by the VM before every instruction.
+func (c *Coverage) hook(fr *callFrame) {
+	fc := fr.cov
+	if fc == nil || fr.covFn != fr.fn {
+		fc = c.funcs[&fr.fn.proto.code[0]]
+		if fc == nil {
+			return // Loaded before the Coverage was attached.
+		}
+		fr.cov, fr.covFn, fr.covBranch = fc, fr.fn, 0
+	}
+
+	idx := fr.pc - 1
+	if fr.covBranch != 0 {
+		// The last instruction in this frame was a branch, see which way it went. Conditions skip the following
+		// jump if they fall through, loops fall through when they exit.
+		b := fr.covBranch - 1
+		way := 0
+		switch fc.proto.code[b].getOpCode() {
+		case opForLoop, opTForLoop:
+			if idx == b+1 {
+				way = 1
+			}
+		default:
+			if idx == b+2 {
+				way = 1
+			}
+		}
+		fc.branches[b][way]++
+		fr.covBranch = 0
+	}
+
+	fc.hits[idx]++
+	if isBranch(fc.proto.code[idx].getOpCode()) {
+		fr.covBranch = idx + 1
+	}
+}
+
+// Files returns the coverage for every source, sorted by name. Coverage for the same source loaded into several
+// States (or loaded more than once) is merged.
+func (c *Coverage) Files() []FileCoverage {
+	names := []string{}
+	for name := range c.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := []FileCoverage{}
+	for _, name := range names {
+		// Functions are identified by where they are defined, so copies of the same function can be merged.
+		type funcKey struct{ start, end int }
+		type branchKey struct {
+			fn funcKey
+			pc int
+		}
+		lines := map[int]int64{}
+		branches := map[branchKey]*BranchCoverage{}
+		for _, fc := range c.sources[name] {
+			p := fc.proto
+			fk := funcKey{p.lineDefined, p.lastLineDefined}
+
+			// The implicit return at the end of a function is not a line of code unless it is run.
+			last := len(p.code) - 1
+			implicit := p.code[last].getOpCode() == opReturn && p.code[last].b() == 1 && fc.hits[last] == 0
+
+			// A line was run as many times as its most run instruction. Copies of the function are added together.
+			fhits := map[int]int64{}
+			for pc, line := range p.lineInfo {
+				if line <= 0 || pc == last && implicit {
+					continue
+				}
+				if hits, ok := fhits[line]; !ok || fc.hits[pc] > hits {
+					fhits[line] = fc.hits[pc]
+				}
+
+				if !isBranch(p.code[pc].getOpCode()) {
+					continue
+				}
+				bk := branchKey{fk, pc}
+				b := branches[bk]
+				if b == nil {
+					b = &BranchCoverage{Line: line}
+					branches[bk] = b
+				}
+				b.Taken[0] += fc.branches[pc][0]
+				b.Taken[1] += fc.branches[pc][1]
+			}
+			for line, hits := range fhits {
+				lines[line] += hits
+			}
+		}
+
+		fcov := FileCoverage{Source: name}
+		for line, hits := range lines {
+			fcov.Lines = append(fcov.Lines, LineCoverage{Line: line, Hits: hits})
+		}
+		sort.Slice(fcov.Lines, func(i, j int) bool { return fcov.Lines[i].Line < fcov.Lines[j].Line })
+
+		keys := []branchKey{}
+		for k := range branches {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := keys[i], keys[j]
+			switch {
+			case branches[a].Line != branches[b].Line:
+				return branches[a].Line < branches[b].Line
+			case a.fn != b.fn:
+				return a.fn.start < b.fn.start || a.fn.start == b.fn.start && a.fn.end < b.fn.end
+			}
+			return a.pc < b.pc
+		})
+		for i, k := range keys {
+			b := *branches[k]
+			if i > 0 && fcov.Branches[i-1].Line == b.Line {
+				b.Block = fcov.Branches[i-1].Block + 1
+			}
+			fcov.Branches = append(fcov.Branches, b)
+		}
+
+		files = append(files, fcov)
+	}
+	return files
+}
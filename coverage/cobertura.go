@@ -0,0 +1,150 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package coverage
+
+import "encoding/xml"
+import "fmt"
+import "io"
+import "path"
+import "strings"
+import "time"
+
+import "github.com/milochristiansen/lua"
+
+type coberturaReport struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        float64            `xml:"line-rate,attr"`
+	BranchRate      float64            `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   float64          `xml:"line-rate,attr"`
+	BranchRate float64          `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   float64         `xml:"line-rate,attr"`
+	BranchRate float64         `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int64  `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+// WriteCobertura writes a report in the Cobertura XML format. Each directory is a package and each file a
+// class.
+func WriteCobertura(w io.Writer, files []lua.FileCoverage) error {
+	total := Summarize(files)
+	r := &coberturaReport{
+		LineRate:        total.LineRate(),
+		BranchRate:      total.BranchRate(),
+		LinesCovered:    total.LinesHit,
+		LinesValid:      total.Lines,
+		BranchesCovered: total.BranchesHit,
+		BranchesValid:   total.Branches,
+		Version:         "1.0",
+		Timestamp:       time.Now().UnixNano() / int64(time.Millisecond),
+		Sources:         []string{"."},
+	}
+
+	pkgs := map[string]int{}
+	pkgFiles := [][]lua.FileCoverage{}
+	for _, f := range files {
+		dir := path.Dir(strings.Replace(f.Source, "\\", "/", -1))
+		i, ok := pkgs[dir]
+		if !ok {
+			i = len(r.Packages)
+			pkgs[dir] = i
+			r.Packages = append(r.Packages, coberturaPackage{Name: dir})
+			pkgFiles = append(pkgFiles, nil)
+		}
+		pkgFiles[i] = append(pkgFiles[i], f)
+
+		s := summarizeFile(f)
+		c := coberturaClass{
+			Name:       strings.TrimSuffix(path.Base(f.Source), ".lua"),
+			Filename:   f.Source,
+			LineRate:   s.LineRate(),
+			BranchRate: s.BranchRate(),
+		}
+
+		byLine := map[int][2]int{} // Branch directions taken and total.
+		for _, b := range f.Branches {
+			v := byLine[b.Line]
+			v[1] += 2
+			for _, n := range b.Taken {
+				if n > 0 {
+					v[0]++
+				}
+			}
+			byLine[b.Line] = v
+		}
+		for _, l := range f.Lines {
+			cl := coberturaLine{Number: l.Line, Hits: l.Hits}
+			if v, ok := byLine[l.Line]; ok {
+				cl.Branch = true
+				cl.ConditionCoverage = fmt.Sprintf("%v%% (%v/%v)", v[0]*100/v[1], v[0], v[1])
+			}
+			c.Lines = append(c.Lines, cl)
+		}
+		r.Packages[i].Classes = append(r.Packages[i].Classes, c)
+	}
+	for i := range r.Packages {
+		s := Summarize(pkgFiles[i])
+		r.Packages[i].LineRate = s.LineRate()
+		r.Packages[i].BranchRate = s.BranchRate()
+	}
+
+	_, err := io.WriteString(w, xml.Header+`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n")
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err = enc.Encode(r)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+Coverage reports for Lua code.
+
+Collect coverage with lua.Coverage, then write the results with one of the functions in this package:
+
+	cov := lua.NewCoverage()
+	l.SetCoverage(cov)
+	// Load and run scripts...
+	err := coverage.WriteLCOV(w, cov.Files())
+
+LCOV and Cobertura are understood by most CI systems and coverage services, the HTML report is for people.
+Summarize gives the totals, for failing a build when coverage is too low.
+
+Paths in the reports are the chunk names the scripts were loaded with, so load scripts with their path (relative
+to the root of your project) as the name.
+*/
+package coverage
+
+import "bufio"
+import "fmt"
+import "io"
+
+import "github.com/milochristiansen/lua"
+
+// Summary is the totals for a set of files. Each branch counts as two, one for each way it can go.
+type Summary struct {
+	Lines, LinesHit       int
+	Branches, BranchesHit int
+}
+
+// Summarize adds up the coverage for a set of files.
+func Summarize(files []lua.FileCoverage) Summary {
+	s := Summary{}
+	for _, f := range files {
+		fs := summarizeFile(f)
+		s.Lines += fs.Lines
+		s.LinesHit += fs.LinesHit
+		s.Branches += fs.Branches
+		s.BranchesHit += fs.BranchesHit
+	}
+	return s
+}
+
+func summarizeFile(f lua.FileCoverage) Summary {
+	s := Summary{Lines: len(f.Lines), Branches: len(f.Branches) * 2}
+	for _, l := range f.Lines {
+		if l.Hits > 0 {
+			s.LinesHit++
+		}
+	}
+	for _, b := range f.Branches {
+		for _, n := range b.Taken {
+			if n > 0 {
+				s.BranchesHit++
+			}
+		}
+	}
+	return s
+}
+
+// LineRate returns the fraction of lines that were run, 1 if there are no lines.
+func (s Summary) LineRate() float64 {
+	return rate(s.LinesHit, s.Lines)
+}
+
+// BranchRate returns the fraction of branch directions that were taken, 1 if there are no branches.
+func (s Summary) BranchRate() float64 {
+	return rate(s.BranchesHit, s.Branches)
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%.1f%% of lines (%v/%v), %.1f%% of branches (%v/%v)",
+		s.LineRate()*100, s.LinesHit, s.Lines, s.BranchRate()*100, s.BranchesHit, s.Branches)
+}
+
+func rate(hit, total int) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(hit) / float64(total)
+}
+
+// WriteLCOV writes a report in the LCOV tracefile format (as read by genhtml and most coverage services).
+func WriteLCOV(w io.Writer, files []lua.FileCoverage) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range files {
+		fmt.Fprintf(bw, "TN:\nSF:%v\n", f.Source)
+
+		hit := map[int]bool{}
+		for _, l := range f.Lines {
+			hit[l.Line] = l.Hits > 0
+		}
+		for _, b := range f.Branches {
+			for i, n := range b.Taken {
+				if !hit[b.Line] {
+					fmt.Fprintf(bw, "BRDA:%v,%v,%v,-\n", b.Line, b.Block, i)
+					continue
+				}
+				fmt.Fprintf(bw, "BRDA:%v,%v,%v,%v\n", b.Line, b.Block, i, n)
+			}
+		}
+		s := summarizeFile(f)
+		fmt.Fprintf(bw, "BRF:%v\nBRH:%v\n", s.Branches, s.BranchesHit)
+
+		for _, l := range f.Lines {
+			fmt.Fprintf(bw, "DA:%v,%v\n", l.Line, l.Hits)
+		}
+		fmt.Fprintf(bw, "LF:%v\nLH:%v\nend_of_record\n", s.Lines, s.LinesHit)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package coverage
+
+import "bytes"
+import "encoding/xml"
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua"
+
+var testFiles = []lua.FileCoverage{{
+	Source: "scripts/test.lua",
+	Lines:  []lua.LineCoverage{{Line: 1, Hits: 1}, {Line: 2, Hits: 3}, {Line: 3, Hits: 0}},
+	Branches: []lua.BranchCoverage{
+		{Line: 2, Block: 0, Taken: [2]int64{3, 0}},
+		{Line: 3, Block: 0, Taken: [2]int64{0, 0}},
+	},
+}}
+
+func TestSummary(t *testing.T) {
+	s := Summarize(testFiles)
+	if s != (Summary{Lines: 3, LinesHit: 2, Branches: 4, BranchesHit: 1}) {
+		t.Errorf("Unexpected summary: %+v", s)
+	}
+	if s.String() != "66.7% of lines (2/3), 25.0% of branches (1/4)" {
+		t.Errorf("Unexpected summary string: %v", s)
+	}
+}
+
+func TestLCOV(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteLCOV(buf, testFiles); err != nil {
+		t.Fatal(err)
+	}
+	expected := `TN:
+SF:scripts/test.lua
+BRDA:2,0,0,3
+BRDA:2,0,1,0
+BRDA:3,0,0,-
+BRDA:3,0,1,-
+BRF:4
+BRH:1
+DA:1,1
+DA:2,3
+DA:3,0
+LF:3
+LH:2
+end_of_record
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected LCOV output:\n%v", buf)
+	}
+}
+
+func TestCobertura(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteCobertura(buf, testFiles); err != nil {
+		t.Fatal(err)
+	}
+
+	r := coberturaReport{}
+	if err := xml.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Packages) != 1 || r.Packages[0].Name != "scripts" || len(r.Packages[0].Classes) != 1 {
+		t.Fatalf("Unexpected packages: %+v", r.Packages)
+	}
+	c := r.Packages[0].Classes[0]
+	if c.Name != "test" || c.Filename != "scripts/test.lua" || len(c.Lines) != 3 {
+		t.Fatalf("Unexpected class: %+v", c)
+	}
+	if l := c.Lines[1]; !l.Branch || l.ConditionCoverage != "50% (1/2)" {
+		t.Errorf("Unexpected line: %+v", l)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteHTML(buf, testFiles, func(string) ([]byte, error) {
+		return []byte("local x = 1\nif x < 2 then\n\tx = <2>\nend\n"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, s := range []string{`<tr class="hit"><td class="num">1</td>`, `<tr class="partial" title="branch 0: 3/0`, `<tr class="miss"`, `x = &lt;2&gt;`, `<td class="num">4</td><td class="hits"></td><td>end</td>`} {
+		if !strings.Contains(out, s) {
+			t.Errorf("HTML output does not contain %q.", s)
+		}
+	}
+}
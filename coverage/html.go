@@ -0,0 +1,146 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package coverage
+
+import "fmt"
+import "html/template"
+import "io"
+import "os"
+import "strings"
+
+import "github.com/milochristiansen/lua"
+
+type htmlFile struct {
+	ID      int
+	Source  string
+	Summary Summary
+	Missing string // Why the source could not be read, if it could not be.
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	Class  string // "", "hit", "miss", or "partial".
+	Hits   string
+	Title  string
+}
+
+// WriteHTML writes a single page report with a summary and the source of every file, lines colored by whether
+// they were run. Lines with branches that only went one way are marked as partially covered.
+//
+// read is used to get the source for a file, if nil the source name is treated as a path and read with
+// os.ReadFile.
+func WriteHTML(w io.Writer, files []lua.FileCoverage, read func(source string) ([]byte, error)) error {
+	if read == nil {
+		read = os.ReadFile
+	}
+
+	data := struct {
+		Total Summary
+		Files []htmlFile
+	}{Total: Summarize(files)}
+
+	for i, f := range files {
+		hf := htmlFile{ID: i, Source: f.Source, Summary: summarizeFile(f)}
+
+		hits := map[int]int64{}
+		for _, l := range f.Lines {
+			hits[l.Line] = l.Hits
+		}
+		branches := map[int][]lua.BranchCoverage{}
+		for _, b := range f.Branches {
+			branches[b.Line] = append(branches[b.Line], b)
+		}
+
+		src, err := read(f.Source)
+		var text []string
+		if err != nil {
+			hf.Missing = err.Error()
+			for _, l := range f.Lines {
+				for len(text) < l.Line {
+					text = append(text, "")
+				}
+			}
+		} else {
+			text = strings.Split(strings.TrimSuffix(strings.Replace(string(src), "\r\n", "\n", -1), "\n"), "\n")
+		}
+
+		for n, t := range text {
+			hl := htmlLine{Number: n + 1, Text: t}
+			if h, ok := hits[n+1]; ok {
+				hl.Hits = fmt.Sprint(h)
+				hl.Class = "hit"
+				if h == 0 {
+					hl.Class = "miss"
+				}
+			}
+			for _, b := range branches[n+1] {
+				hl.Title += fmt.Sprintf("branch %v: %v/%v\n", b.Block, b.Taken[0], b.Taken[1])
+				if hl.Class == "hit" && !b.Covered() {
+					hl.Class = "partial"
+				}
+			}
+			hf.Lines = append(hf.Lines, hl)
+		}
+		data.Files = append(data.Files, hf)
+	}
+
+	return htmlTemplate.Execute(w, data)
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lua Coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+.summary td, .summary th { padding: 0.2em 1em; text-align: left; }
+.source { font-family: monospace; white-space: pre; width: 100%; }
+.source td { padding: 0 0.5em; }
+.source .num, .source .hits { text-align: right; color: #888; user-select: none; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.partial { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>Lua Coverage</h1>
+<p>Total: {{.Total}}</p>
+<table class="summary">
+<tr><th>File</th><th>Lines</th><th>Branches</th></tr>
+{{range .Files}}<tr><td><a href="#file-{{.ID}}">{{.Source}}</a></td><td>{{.Summary.LinesHit}}/{{.Summary.Lines}}</td><td>{{.Summary.BranchesHit}}/{{.Summary.Branches}}</td></tr>
+{{end}}</table>
+{{range .Files}}
+<h2 id="file-{{.ID}}">{{.Source}}</h2>
+<p>{{.Summary}}</p>
+{{if .Missing}}<p>Source not available: {{.Missing}}</p>{{end}}
+<table class="source">
+{{range .Lines}}<tr class="{{.Class}}"{{if .Title}} title="{{.Title}}"{{end}}><td class="num">{{.Number}}</td><td class="hits">{{.Hits}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
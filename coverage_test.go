@@ -0,0 +1,91 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua_test
+
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/testhelp"
+
+func TestCoverage(t *testing.T) {
+	c := lua.NewCoverage()
+
+	src := `local function never()
+	return 1
+end
+local n = 0
+for i = 1, 3 do
+	if i > 5 then
+		n = n + 100
+	end
+	n = n + i
+end
+return n
+`
+	// Run the same script in two States, the results should be merged.
+	for i := 0; i < 2; i++ {
+		l := testhelp.MkState()
+		l.SetCoverage(c)
+		err := l.LoadText(strings.NewReader(src), "test.lua", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.PCall(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := c.Files()
+	if len(files) != 1 || files[0].Source != "test.lua" {
+		t.Fatalf("Unexpected files: %v", files)
+	}
+
+	hits := map[int]int64{}
+	for _, l := range files[0].Lines {
+		hits[l.Line] = l.Hits
+	}
+	expected := map[int]int64{1: 2, 2: 0, 4: 2, 5: 8, 6: 6, 7: 0, 9: 6, 11: 2}
+	for line, n := range expected {
+		if got, ok := hits[line]; !ok || got != n {
+			t.Errorf("Line %v: expected %v hits, got %v (%v)", line, n, got, ok)
+		}
+	}
+	for _, line := range []int{3, 8, 10} {
+		if _, ok := hits[line]; ok {
+			t.Errorf("Line %v should not have code.", line)
+		}
+	}
+
+	branches := files[0].Branches
+	if len(branches) != 2 {
+		t.Fatalf("Unexpected branches: %+v", branches)
+	}
+	if b := branches[0]; b.Line != 5 || b.Taken != [2]int64{6, 2} {
+		t.Errorf("Unexpected loop branch: %+v", b)
+	}
+	if b := branches[1]; b.Line != 6 || b.Covered() || b.Taken[0]+b.Taken[1] != 6 {
+		t.Errorf("Unexpected if branch: %+v", b)
+	}
+}
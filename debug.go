@@ -0,0 +1,592 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua
+
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "sync/atomic"
+
+import "github.com/milochristiansen/lua/luautil"
+
+// StepMode tells a Debugger what to do when execution resumes.
+type StepMode int
+
+// Step modes, returned from Debugger.Stopped.
+const (
+	StepContinue StepMode = iota // Run until the next breakpoint.
+	StepIn                       // Stop on the next line, even if it is in another function.
+	StepOver                     // Stop on the next line in this function or one of its callers.
+	StepOut                      // Stop on the next line in one of this function's callers.
+)
+
+// StopReason is the reason a Debugger stopped the script.
+type StopReason int
+
+// Reasons passed to Debugger.Stopped.
+const (
+	StopBreakpoint StopReason = iota // A line breakpoint was hit.
+	StopFunction                     // A function breakpoint was hit.
+	StopStep                         // A step finished.
+	StopPause                        // Pause was called.
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopFunction:
+		return "function breakpoint"
+	case StopStep:
+		return "step"
+	case StopPause:
+		return "pause"
+	}
+	return "unknown"
+}
+
+// Debugger adds breakpoints and stepping to a State. Create one with NewDebugger and attach it with
+// State.SetDebugger.
+//
+// When a breakpoint is hit or a step finishes the VM calls Stopped on the goroutine running the script. While
+// Stopped is running the script is suspended, and Frames, Locals, Upvalues, Fields, and Eval may be used (from
+// inside Stopped only!) to look around. When Stopped returns the script resumes using the returned StepMode.
+//
+// Everything else (setting breakpoints, Pause, and Terminate) is safe to call from any goroutine at any time.
+//
+// Breakpoints only work for Lua functions, native functions are invisible to the debugger.
+type Debugger struct {
+	// Stopped is called every time the script stops. If nil the script is resumed with StepContinue.
+	Stopped func(d *Debugger, reason StopReason) StepMode
+
+	l *State
+
+	mu       sync.Mutex
+	requests map[string][]int        // Requested breakpoint lines by source.
+	lines    map[string]map[int]bool // Resolved breakpoint lines by source.
+	funcs    map[string]bool         // Function breakpoints.
+	protos   map[string][]*funcProto // Loaded top level functions by source.
+
+	mode  StepMode
+	depth int // Frame count when the current step started.
+
+	pause int32 // Set by Pause, accessed atomically.
+	kill  int32 // Set by Terminate, accessed atomically.
+
+	stopped    bool
+	evaluating bool
+	refs       []*table
+}
+
+// NewDebugger creates a new Debugger with no breakpoints.
+func NewDebugger() *Debugger {
+	return &Debugger{
+		requests: map[string][]int{},
+		lines:    map[string]map[int]bool{},
+		funcs:    map[string]bool{},
+		protos:   map[string][]*funcProto{},
+	}
+}
+
+// SetDebugger attaches a Debugger to the State, or removes the current one if d is nil. A Debugger may only
+// be attached to one State.
+//
+// Breakpoints can only be resolved for code loaded after the Debugger is attached.
+func (l *State) SetDebugger(d *Debugger) {
+	if l.debugger != nil {
+		l.debugger.l = nil
+	}
+	l.debugger = d
+	if d != nil {
+		d.l = l
+	}
+	l.setHooks()
+}
+
+// SetBreakpoints replaces the line breakpoints for the given source (the name the chunk was loaded with). Each
+// breakpoint is moved to the first line at or after the requested line that has code. The returned slice has
+// the resolved line for each request, or 0 if it could not be resolved (yet).
+//
+// Breakpoints for sources that are not loaded yet are resolved when a chunk with that name is loaded.
+func (d *Debugger) SetBreakpoints(source string, lines []int) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.requests[source] = append([]int(nil), lines...)
+	return d.resolve(source)
+}
+
+// SetFunctionBreakpoints replaces the function breakpoints. The script stops on entry to any Lua function that
+// was called by one of these names, for example "print", "foo" for "t.foo()" or "t:foo()", or the name of a
+// local variable holding the function.
+func (d *Debugger) SetFunctionBreakpoints(names []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.funcs = map[string]bool{}
+	for _, name := range names {
+		d.funcs[name] = true
+	}
+}
+
+// Pause stops the script before the next instruction.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pause, 1)
+}
+
+// Terminate makes the script raise an error before the next instruction. If the script is stopped you still
+// need to return from Stopped.
+func (d *Debugger) Terminate() {
+	atomic.StoreInt32(&d.kill, 1)
+}
+
+// resolve maps the requested lines for a source onto lines with code. The lock must be held.
+func (d *Debugger) resolve(source string) []int {
+	valid := []int{}
+	var walk func(p *funcProto)
+	walk = func(p *funcProto) {
+		valid = append(valid, p.lineInfo...)
+		for i := range p.prototypes {
+			walk(&p.prototypes[i])
+		}
+	}
+	for _, p := range d.protos[source] {
+		walk(p)
+	}
+	sort.Ints(valid)
+
+	resolved := make([]int, len(d.requests[source]))
+	set := map[int]bool{}
+	for i, line := range d.requests[source] {
+		j := sort.SearchInts(valid, line)
+		if j < len(valid) {
+			resolved[i] = valid[j]
+			set[valid[j]] = true
+		}
+	}
+	d.lines[source] = set
+	return resolved
+}
+
+// loaded is called by the State when a new chunk is loaded. Chunks loaded by Eval are not kept, breakpoints
+// can't be set in them anyway.
+func (d *Debugger) loaded(p *funcProto) {
+	if d.evaluating {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.protos[p.source] = append(d.protos[p.source], p)
+	if len(d.requests[p.source]) > 0 {
+		d.resolve(p.source)
+	}
+}
+
+// hook is called by the VM before every instruction.
+func (d *Debugger) hook(fr *callFrame) {
+	if d.evaluating {
+		return
+	}
+	if atomic.LoadInt32(&d.kill) != 0 {
+		luautil.Raise("Script terminated by debugger.", luautil.ErrTypGenRuntime)
+	}
+
+	idx := fr.pc - 1
+	p := &fr.fn.proto
+	line := -1
+	if int(idx) < len(p.lineInfo) {
+		line = p.lineInfo[idx]
+	}
+	newLine := line != fr.dbgLine || idx <= fr.dbgPC
+	fr.dbgLine, fr.dbgPC = line, idx
+
+	depth := len(d.l.stack.frames)
+	switch {
+	case atomic.LoadInt32(&d.pause) != 0:
+		d.stop(StopPause)
+	case idx == 0 && d.funcBreak(len(d.l.stack.frames)-1):
+		d.stop(StopFunction)
+	case !newLine:
+		return
+	case d.lineBreak(p.source, line):
+		d.stop(StopBreakpoint)
+	case d.mode == StepIn, d.mode == StepOver && depth <= d.depth, d.mode == StepOut && depth < d.depth:
+		d.stop(StopStep)
+	}
+}
+
+func (d *Debugger) lineBreak(source string, line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lines[source][line]
+}
+
+func (d *Debugger) funcBreak(k int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.funcs) == 0 {
+		return false
+	}
+	return d.funcs[d.l.callName(k)]
+}
+
+func (d *Debugger) stop(reason StopReason) {
+	atomic.StoreInt32(&d.pause, 0)
+
+	mode := StepContinue
+	if d.Stopped != nil {
+		d.stopped = true
+		mode = d.Stopped(d, reason)
+		d.stopped = false
+		d.refs = nil
+	}
+	d.mode, d.depth = mode, len(d.l.stack.frames)
+
+	if atomic.LoadInt32(&d.kill) != 0 {
+		luautil.Raise("Script terminated by debugger.", luautil.ErrTypGenRuntime)
+	}
+}
+
+// Frame describes one function on the call stack.
+type Frame struct {
+	Name   string // The name the function was called by, "main chunk", or "?" if unknown.
+	Source string // The chunk name, empty for native functions.
+	Line   int    // The current line, -1 if unknown.
+	Native bool
+}
+
+// Variable is a value as seen by the debugger.
+type Variable struct {
+	Name  string
+	Type  string
+	Value string
+
+	// If the value is a table this is a reference for use with Fields, else 0. References are only valid until
+	// the script resumes.
+	Ref int
+}
+
+// Frames returns the call stack, the innermost function first. Frame indexes used by the other methods are
+// indexes into this slice. Only valid while stopped.
+func (d *Debugger) Frames() []Frame {
+	d.mustStop()
+
+	frames := []Frame{}
+	for k := len(d.l.stack.frames) - 1; k >= 0; k-- {
+		fr := d.l.stack.frames[k]
+		if fr.fn == nil {
+			continue
+		}
+		f := Frame{Name: d.l.callName(k), Line: -1, Native: fr.fn.native != nil}
+		if !f.Native {
+			f.Source = fr.fn.proto.source
+			if idx := int(fr.pc) - 1; idx >= 0 && idx < len(fr.fn.proto.lineInfo) {
+				f.Line = fr.fn.proto.lineInfo[idx]
+			}
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// Locals returns the local variables visible in a frame, in declaration order. Only valid while stopped.
+func (d *Debugger) Locals(frame int) []Variable {
+	k := d.frameIndex(frame)
+	vars := []Variable{}
+	for _, lv := range d.l.activeLocals(k) {
+		if strings.HasPrefix(lv.name, "(") {
+			continue // Internal for loop state.
+		}
+		vars = append(vars, d.variable(lv.name, d.l.stack.GetInFrame(k, lv.reg)))
+	}
+	return vars
+}
+
+// Upvalues returns the upvalues of the function running in a frame. Only valid while stopped.
+func (d *Debugger) Upvalues(frame int) []Variable {
+	k := d.frameIndex(frame)
+	fr := d.l.stack.frames[k]
+	vars := []Variable{}
+	if fr.fn.native != nil {
+		return vars
+	}
+	for i, def := range fr.fn.proto.upVals {
+		if def.name == "" || def.name == "_ENV" {
+			continue
+		}
+		vars = append(vars, d.variable(def.name, fr.getUp(i)))
+	}
+	return vars
+}
+
+// Fields returns the contents of a table from a Variable's Ref. Only valid while stopped.
+func (d *Debugger) Fields(ref int) []Variable {
+	d.mustStop()
+	if ref <= 0 || ref > len(d.refs) {
+		luautil.Raise("Invalid variable reference.", luautil.ErrTypGenRuntime)
+	}
+
+	tbl := d.refs[ref-1]
+	vars := []Variable{}
+	k, v := tbl.Next(nil)
+	for k != nil {
+		name, ok := k.(string)
+		if !ok {
+			name = "[" + toString(k) + "]"
+		}
+		vars = append(vars, d.variable(name, v))
+		k, v = tbl.Next(k)
+	}
+	return vars
+}
+
+// Eval evaluates an expression (or failing that, a statement) as if it was part of the function running in a
+// frame. Locals and upvalues may be read and assigned. Only valid while stopped.
+//
+// Breakpoints are ignored while the code runs.
+func (d *Debugger) Eval(frame int, expr string) (v Variable, err error) {
+	k := d.frameIndex(frame)
+	l := d.l
+	top := len(l.stack.data)
+
+	d.evaluating = true
+	defer func() {
+		d.evaluating = false
+		for i := top; i < len(l.stack.data); i++ {
+			l.stack.data[i] = nil
+		}
+		l.stack.data = l.stack.data[:top]
+	}()
+
+	err = l.Protect(func() {
+		l.stack.Push(d.evalEnv(k))
+		envi := l.AbsIndex(-1)
+
+		err := l.LoadText(strings.NewReader("return "+expr), "=(eval)", envi)
+		if err != nil {
+			err = l.LoadText(strings.NewReader(expr), "=(eval)", envi)
+			if err != nil {
+				panic(err)
+			}
+		}
+		l.Call(0, 1)
+		v = d.variable("", l.get(-1))
+	})
+	return v, err
+}
+
+// evalEnv creates the environment used by Eval for a frame.
+func (d *Debugger) evalEnv(k int) *table {
+	l := d.l
+	fr := l.stack.frames[k]
+
+	var env value = l.global
+	if fr.fn.native == nil {
+		for i, def := range fr.fn.proto.upVals {
+			if def.name == "_ENV" {
+				env = fr.getUp(i)
+			}
+		}
+	}
+
+	// find returns the register of a local (or -1) and the index of an upvalue (or -1).
+	find := func(name string) (int, int) {
+		reg := -1
+		for _, lv := range d.l.activeLocals(k) {
+			if lv.name == name {
+				reg = lv.reg // Later locals shadow earlier ones.
+			}
+		}
+		if reg != -1 || fr.fn.native != nil {
+			return reg, -1
+		}
+		for i, def := range fr.fn.proto.upVals {
+			if def.name == name {
+				return -1, i
+			}
+		}
+		return -1, -1
+	}
+
+	meta := newTable(l, 0, 2)
+	l.Push(func(l *State) int {
+		name, ok := l.get(2).(string)
+		if ok {
+			reg, up := find(name)
+			switch {
+			case reg != -1:
+				l.stack.Push(l.stack.GetInFrame(k, reg))
+				return 1
+			case up != -1:
+				l.stack.Push(fr.getUp(up))
+				return 1
+			}
+		}
+		l.stack.Push(l.getTable(env, l.get(2)))
+		return 1
+	})
+	meta.SetRaw("__index", l.get(-1))
+	l.Pop(1)
+	l.Push(func(l *State) int {
+		name, ok := l.get(2).(string)
+		if ok {
+			reg, up := find(name)
+			switch {
+			case reg != -1:
+				if segC, segN := l.stack.bounds(k); segC+reg+1 > segN {
+					luautil.Raise("Cannot assign to "+name+" here.", luautil.ErrTypGenRuntime)
+				}
+				l.stack.SetInFrame(k, reg, l.get(3))
+				return 0
+			case up != -1:
+				fr.setUp(up, l.get(3))
+				return 0
+			}
+		}
+		l.setTable(env, l.get(2), l.get(3))
+		return 0
+	})
+	meta.SetRaw("__newindex", l.get(-1))
+	l.Pop(1)
+
+	tbl := newTable(l, 0, 0)
+	tbl.meta = meta
+	return tbl
+}
+
+type activeLocal struct {
+	name string
+	reg  int
+}
+
+// activeLocals returns the locals active in frame k (an index into stack.frames) along with their registers.
+func (l *State) activeLocals(k int) []activeLocal {
+	fr := l.stack.frames[k]
+	if fr.fn.native != nil {
+		return nil
+	}
+	pc := fr.pc - 1
+	locals := []activeLocal{}
+	for _, lv := range fr.fn.proto.localVars {
+		if lv.sPC <= pc && pc < lv.ePC {
+			locals = append(locals, activeLocal{lv.name, len(locals)})
+		}
+	}
+	return locals
+}
+
+func (d *Debugger) variable(name string, v value) Variable {
+	vr := Variable{Name: name, Type: typeOf(v).String(), Value: toString(v)}
+	switch v2 := v.(type) {
+	case string:
+		vr.Value = strconv.Quote(v2)
+	case *table:
+		d.refs = append(d.refs, v2)
+		vr.Ref = len(d.refs)
+	}
+	return vr
+}
+
+func (d *Debugger) mustStop() {
+	if !d.stopped || d.l == nil {
+		luautil.Raise("Debugger is not stopped.", luautil.ErrTypGenRuntime)
+	}
+}
+
+// frameIndex converts a frame index as used by Frames to an index into stack.frames.
+func (d *Debugger) frameIndex(frame int) int {
+	d.mustStop()
+	n := 0
+	for k := len(d.l.stack.frames) - 1; k >= 0; k-- {
+		if d.l.stack.frames[k].fn == nil {
+			continue
+		}
+		if n == frame {
+			return k
+		}
+		n++
+	}
+	luautil.Raise("Invalid frame index.", luautil.ErrTypGenRuntime)
+	panic("UNREACHABLE")
+}
+
+// callName finds the name the function in frame k (an index into stack.frames) was called by, by looking at the
+// code in the calling frame.
+func (l *State) callName(k int) string {
+	fr := l.stack.frames[k]
+	if fr.fn.native == nil && fr.fn.proto.lineDefined == 0 {
+		return "main chunk"
+	}
+	if k == 0 {
+		return "?"
+	}
+	caller := l.stack.frames[k-1]
+	if caller.fn == nil || caller.fn.native != nil || caller.pc < 1 {
+		return "?"
+	}
+
+	p := &caller.fn.proto
+	call := p.code[caller.pc-1]
+	if op := call.getOpCode(); op != opCall && op != opTailCall {
+		return "?"
+	}
+	a := call.a()
+	for pc := int(caller.pc) - 2; pc >= 0; pc-- {
+		i := p.code[pc]
+		switch i.getOpCode() {
+		case opSetTable, opSetTableUp, opSetUpValue, opJump, OpEqual, OpLessThan, OpLessOrEqual, opTest:
+			continue // These don't write to A.
+		}
+		if i.a() != a {
+			continue
+		}
+
+		switch i.getOpCode() {
+		case opGetTableUp:
+			if p.upVals[i.b()].name == "_ENV" && isK(i.c()) {
+				if s, ok := p.constants[indexK(i.c())].(string); ok {
+					return s
+				}
+			}
+		case opGetTable, opSelf:
+			if isK(i.c()) {
+				if s, ok := p.constants[indexK(i.c())].(string); ok {
+					return s
+				}
+			}
+		case opGetUpValue:
+			return p.upVals[i.b()].name
+		case opMove:
+			for _, lv := range l.activeLocals(k - 1) {
+				if lv.reg == i.b() {
+					return lv.name
+				}
+			}
+		}
+		return "?"
+	}
+	return "?"
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua_test
+
+import "fmt"
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/testhelp"
+
+func TestDebugger(t *testing.T) {
+	l := testhelp.MkState()
+	d := lua.NewDebugger()
+	l.SetDebugger(d)
+
+	log := []string{}
+	modes := []lua.StepMode{lua.StepIn, lua.StepIn, lua.StepOut, lua.StepOver, lua.StepContinue, lua.StepContinue}
+	d.Stopped = func(d *lua.Debugger, reason lua.StopReason) lua.StepMode {
+		f := d.Frames()[0]
+		entry := fmt.Sprintf("%v %v:%v", reason, f.Name, f.Line)
+		for _, v := range d.Locals(0) {
+			if v.Type == "function" {
+				v.Value = "function"
+			}
+			entry += fmt.Sprintf(" %v=%v", v.Name, v.Value)
+		}
+		log = append(log, entry)
+
+		if len(log) == 1 {
+			v, err := d.Eval(0, "a + 1")
+			if err != nil || v.Value != "2" {
+				t.Errorf("Eval: %v %v", v, err)
+			}
+			if _, err := d.Eval(0, "a = 10"); err != nil {
+				t.Errorf("Eval assignment: %v", err)
+			}
+			if lines := d.SetBreakpoints("=(eval)", []int{1}); lines[0] != 0 {
+				t.Errorf("Eval chunks were kept by the debugger")
+			}
+		}
+
+		mode := modes[0]
+		modes = modes[1:]
+		return mode
+	}
+
+	err := l.LoadText(strings.NewReader(`local function add(x, y)
+	local s = x + y
+	return s
+end
+local a = 1
+local b = add(a, 2)
+result = b
+local t = {}
+function t.f() return 1 end
+t.f()
+`), "test.lua", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := d.SetBreakpoints("test.lua", []int{6, 100}); got[0] != 6 || got[1] != 0 {
+		t.Errorf("Unexpected resolved breakpoints: %v", got)
+	}
+	d.SetFunctionBreakpoints([]string{"f"})
+
+	if err := l.PCall(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"breakpoint main chunk:6 add=function a=1",
+		"step add:2 x=10 y=2",
+		"step add:3 x=10 y=2 s=12",
+		"step main chunk:7 add=function a=10 b=12",
+		"step main chunk:8 add=function a=10 b=12",
+		"function breakpoint f:9",
+	}
+	if len(log) != len(expected) {
+		t.Fatalf("Unexpected stops:\n%v", strings.Join(log, "\n"))
+	}
+	for i := range log {
+		if log[i] != expected[i] {
+			t.Errorf("Stop %v: expected %q, got %q", i, expected[i], log[i])
+		}
+	}
+}
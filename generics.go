@@ -0,0 +1,246 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua
+
+import "fmt"
+import "math"
+import "reflect"
+
+import "github.com/milochristiansen/lua/luautil"
+
+// Check reads the value at the given index as a T, raising a standard "bad argument" error if that is not possible.
+// This is meant for reading the arguments of native functions.
+//
+// Bools require a boolean. Strings accept strings and numbers. Integer types (including named types) accept numbers
+// and numeric strings with an integer value that fits in T. Float types accept numbers and numeric strings that fit
+// in T. Any other T requires a userdata value whose data can be assigned to T. For interface{} you get the same result
+// as GetRaw, and a missing value is nil instead of an error.
+func Check[T any](l *State, i int) T {
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	if !l.checkValue(i, rv) {
+		l.argError(i, expectedName(rv.Type()))
+	}
+	return v
+}
+
+// Opt is the same as Check, except the given default is returned if the value is nil or non-existent.
+func Opt[T any](l *State, i int, d T) T {
+	if l.IsNil(i) {
+		return d
+	}
+	return Check[T](l, i)
+}
+
+// PushFunc wraps a Go function as a native function and pushes it. F must be a function type, else this panics.
+//
+// Arguments are read with the same rules as Check (including the errors), and results are pushed with Push, except
+// that nil pointers, maps, and so on are pushed as nil and interfaces as the value they hold. Unsigned results that
+// don't fit in a Lua integer are an error. If the first parameter is a *State it gets the State the function was
+// called with and does not use a script argument. The last parameter may be variadic. If the last result is an error
+// it is raised (if not nil) instead of pushed.
+//
+//	lua.PushFunc(l, func(name string, n int) (string, error) { ... })
+func PushFunc[F any](l *State, f F) {
+	fv := reflect.ValueOf(f)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func {
+		panic("lua.PushFunc requires a function, not a " + ft.String())
+	}
+
+	stateArg := ft.NumIn() > 0 && ft.In(0) == stateType
+	nOut := ft.NumOut()
+	errOut := nOut > 0 && ft.Out(nOut-1) == errorType
+	if errOut {
+		nOut--
+	}
+
+	l.Push(func(l *State) int {
+		args := make([]reflect.Value, 0, ft.NumIn())
+		n := 1
+		for p := 0; p < ft.NumIn(); p++ {
+			t := ft.In(p)
+			switch {
+			case p == 0 && stateArg:
+				args = append(args, reflect.ValueOf(l))
+				continue
+			case p == ft.NumIn()-1 && ft.IsVariadic():
+				t = t.Elem()
+				for top := l.AbsIndex(-1); n <= top; n++ {
+					args = append(args, l.checkArg(n, t))
+				}
+				continue
+			}
+			args = append(args, l.checkArg(n, t))
+			n++
+		}
+
+		rtns := fv.Call(args)
+		if errOut {
+			if err := rtns[nOut]; !err.IsNil() {
+				luautil.Raise(err.Interface().(error).Error(), luautil.ErrTypGenRuntime)
+			}
+		}
+		for _, r := range rtns[:nOut] {
+			l.pushReflect(r)
+		}
+		return nOut
+	})
+}
+
+var stateType = reflect.TypeOf((*State)(nil))
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// checkArg reads an argument as the given type, raising an error if that is not possible.
+func (l *State) checkArg(i int, t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	if !l.checkValue(i, v) {
+		l.argError(i, expectedName(t))
+	}
+	return v
+}
+
+// checkValue stores the value at the given index in v if possible.
+func (l *State) checkValue(i int, v reflect.Value) bool {
+	if i > l.AbsIndex(-1) && i > 0 {
+		// A missing value is nil, which only an empty interface accepts.
+		return v.Kind() == reflect.Interface && v.NumMethod() == 0
+	}
+	raw := l.get(i)
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := raw.(bool)
+		if !ok {
+			return false
+		}
+		v.SetBool(b)
+		return true
+	case reflect.String:
+		if t := typeOf(raw); t != TypString && t != TypNumber {
+			return false
+		}
+		v.SetString(toString(raw))
+		return true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := tryInt(raw)
+		if !ok || v.OverflowInt(n) {
+			return false
+		}
+		v.SetInt(n)
+		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := tryInt(raw)
+		if !ok || n < 0 || v.OverflowUint(uint64(n)) {
+			return false
+		}
+		v.SetUint(uint64(n))
+		return true
+	case reflect.Float32, reflect.Float64:
+		f, ok := tryFloat(raw)
+		if !ok || v.OverflowFloat(f) {
+			return false
+		}
+		v.SetFloat(f)
+		return true
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			x := l.GetRaw(i)
+			if x != nil {
+				v.Set(reflect.ValueOf(x))
+			}
+			return true
+		}
+	}
+
+	ud, ok := raw.(*userData)
+	if !ok || ud.data == nil || !reflect.TypeOf(ud.data).AssignableTo(v.Type()) {
+		return false
+	}
+	v.Set(reflect.ValueOf(ud.data))
+	return true
+}
+
+// pushReflect pushes a function result. Nil values are pushed as nil, and interfaces as the value they hold.
+func (l *State) pushReflect(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			l.Push(nil)
+			return
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		l.pushReflect(v.Elem())
+	case reflect.Bool:
+		l.Push(v.Bool())
+	case reflect.String:
+		l.Push(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		l.Push(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			luautil.Raise(fmt.Sprintf("Number %v does not fit in a Lua integer.", u), luautil.ErrTypGenRuntime)
+		}
+		l.Push(int64(u))
+	case reflect.Float32, reflect.Float64:
+		l.Push(v.Float())
+	default:
+		l.Push(v.Interface())
+	}
+}
+
+// expectedName returns the name used for a type in "bad argument" errors.
+func expectedName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
+
+// argError raises a "bad argument" error for the current native function.
+func (l *State) argError(i int, expected string) {
+	got := "no value"
+	if i <= l.AbsIndex(-1) {
+		got = l.TypeOf(i).String()
+	}
+
+	fn := ""
+	if k := len(l.stack.frames) - 1; k > 0 && l.stack.frames[k].fn != nil {
+		if name := l.callName(k); name != "?" {
+			fn = fmt.Sprintf(" to '%v'", name)
+		}
+	}
+	luautil.Raise(fmt.Sprintf("bad argument #%v%v (%v expected, got %v)", i, fn, expected, got), luautil.ErrTypGenRuntime)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua_test
+
+import "errors"
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/testhelp"
+
+type point struct {
+	X, Y int
+}
+
+func TestCheck(t *testing.T) {
+	l := testhelp.MkState()
+
+	l.Push("f")
+	l.Push(func(l *lua.State) int {
+		s := lua.Check[string](l, 1)
+		n := lua.Opt[int8](l, 2, 10)
+		p := lua.Check[*point](l, 3)
+		l.Push(strings.Repeat(s, int(n)+p.X))
+		return 1
+	})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	l.Push("p")
+	l.Push(&point{X: 1})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(f("a", nil, p) == "aaaaaaaaaaa")
+assert(f(1, 2, p) == "111")
+
+local ok, err = pcall(function() f({}, 1, p) end)
+assert(not ok and err:find("bad argument #1 to 'f' (string expected, got table)"))
+ok, err = pcall(function() f("a", 1000, p) end)
+assert(not ok and err:find("bad argument #2 to 'f' (integer expected, got number)"))
+ok, err = pcall(function() f("a", 1) end)
+assert(not ok and err:find("bad argument #3 to 'f' (*lua_test.point expected, got no value)"))
+	`, nil)
+}
+
+func TestPushFunc(t *testing.T) {
+	l := testhelp.MkState()
+
+	l.Push("div")
+	lua.PushFunc(l, func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	l.Push("sum")
+	lua.PushFunc(l, func(l *lua.State, prefix string, n ...int) (string, int) {
+		total := 0
+		for _, v := range n {
+			total += v
+		}
+		return prefix, total
+	})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	l.Push("mkpoint")
+	lua.PushFunc(l, func(x, y int) *point { return &point{x, y} })
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	l.Push("any")
+	lua.PushFunc(l, func(a, b interface{}) (interface{}, interface{}) {
+		if a == nil {
+			return uint8(7), b
+		}
+		return a, nil
+	})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	l.Push("big")
+	lua.PushFunc(l, func(f float32) uint64 { return uint64(f) << 40 })
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	l.Push("getx")
+	lua.PushFunc(l, func(p *point) int { return p.X })
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(div(1, 4) == 0.25)
+local ok, err = pcall(div, 1, 0)
+assert(not ok and err:find("division by zero"))
+
+local p, n = sum("total", 1, 2, 3)
+assert(p == "total" and n == 6)
+
+ok, err = pcall(function() sum("x", 1, "y") end)
+assert(not ok and err:find("bad argument #3 to 'sum' (integer expected, got string)"))
+
+local a, b = any()
+assert(a == 7 and math.type(a) == "integer" and b == nil)
+a, b = any(nil, "x")
+assert(a == 7 and b == "x")
+assert(any("y") == "y")
+
+assert(big(1) == 1 << 40)
+ok, err = pcall(function() big(1e300) end)
+assert(not ok and err:find("bad argument #1 to 'big' (number expected, got number)"))
+ok, err = pcall(function() big(1 << 23) end)
+assert(not ok and err:find("does not fit in a Lua integer"))
+
+return getx(mkpoint(7, 8))
+	`, 7)
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+// Package fields finds the fields of struct types as seen from Lua.
+//
+// This is shared by supermeta and lua.Marshal/Unmarshal, so struct tags mean the same thing to both.
+package fields
+
+import "reflect"
+import "sort"
+import "strings"
+import "sync"
+
+// Field describes a struct field, or a field promoted from an embedded struct.
+type Field struct {
+	Name  string
+	Index []int
+	Type  reflect.Type
+	Pos   int // Index in Struct.List
+
+	Exported  bool
+	Embedded  bool // An embedded struct, its fields are promoted as well.
+	ReadOnly  bool // Unexported, tagged readonly, or promoted from a readonly embedded struct.
+	OmitEmpty bool
+}
+
+// Struct holds the fields of a struct type.
+type Struct struct {
+	ByName map[string]*Field
+	List   []*Field // In declaration order
+}
+
+type cacheKey struct {
+	t    reflect.Type
+	json bool
+}
+
+var cache sync.Map // cacheKey -> *Struct
+
+// Of returns the (cached) fields of a struct type.
+//
+// Field names may be changed with a `lua:"name"` tag, `lua:"-"` hides a field, and the options "readonly" and
+// "omitempty" set ReadOnly and OmitEmpty. If json is true fields with no lua tag use their json tag instead.
+//
+// Fields of embedded structs are promoted, shallower fields win, and like encoding/json a single tagged field wins
+// over untagged ones at the same depth. Other ambiguous names are hidden.
+func Of(t reflect.Type, json bool) *Struct {
+	key := cacheKey{t, json}
+	if s, ok := cache.Load(key); ok {
+		return s.(*Struct)
+	}
+	s, _ := cache.LoadOrStore(key, build(t, json))
+	return s.(*Struct)
+}
+
+func build(t reflect.Type, json bool) *Struct {
+	type embedded struct {
+		typ      reflect.Type
+		index    []int
+		readOnly bool
+	}
+	type candidate struct {
+		f      *Field
+		tagged bool
+	}
+
+	s := &Struct{ByName: map[string]*Field{}}
+	taken := map[string]bool{}
+	visited := map[reflect.Type]bool{}
+
+	next := []embedded{{typ: t}}
+	for len(next) > 0 {
+		current := next
+		next = nil
+
+		found := map[string][]candidate{}
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+
+				tag, ok := sf.Tag.Lookup("lua")
+				if !ok && json {
+					tag = sf.Tag.Get("json")
+				}
+				if tag == "-" {
+					continue
+				}
+				name, opts := tag, ""
+				if c := strings.Index(tag, ","); c != -1 {
+					name, opts = tag[:c], tag[c+1:]
+				}
+
+				index := make([]int, len(e.index)+1)
+				copy(index, e.index)
+				index[len(e.index)] = i
+
+				f := &Field{
+					Name:     name,
+					Index:    index,
+					Type:     sf.Type,
+					Exported: sf.PkgPath == "",
+					ReadOnly: e.readOnly || sf.PkgPath != "",
+				}
+				tagRO := false
+				for _, o := range strings.Split(opts, ",") {
+					switch o {
+					case "readonly":
+						tagRO = true
+					case "omitempty":
+						f.OmitEmpty = true
+					}
+				}
+				f.ReadOnly = f.ReadOnly || tagRO
+
+				if sf.Anonymous && name == "" {
+					et := sf.Type
+					if et.Kind() == reflect.Ptr {
+						et = et.Elem()
+					}
+					if et.Kind() == reflect.Struct {
+						f.Embedded = true
+						next = append(next, embedded{et, index, e.readOnly || tagRO})
+					}
+				}
+
+				if f.Name == "" {
+					f.Name = sf.Name
+				}
+				found[f.Name] = append(found[f.Name], candidate{f, name != ""})
+			}
+		}
+
+		for name, cs := range found {
+			if taken[name] {
+				continue
+			}
+			taken[name] = true
+
+			var pick []*Field
+			for _, c := range cs {
+				if c.tagged {
+					pick = append(pick, c.f)
+				}
+			}
+			if len(pick) == 0 {
+				for _, c := range cs {
+					pick = append(pick, c.f)
+				}
+			}
+			if len(pick) == 1 {
+				s.ByName[name] = pick[0]
+				s.List = append(s.List, pick[0])
+			}
+		}
+	}
+
+	sort.Slice(s.List, func(i, j int) bool {
+		a, b := s.List[i].Index, s.List[j].Index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	for i, f := range s.List {
+		f.Pos = i
+	}
+	return s
+}
+
+// Value returns the value of a field, following embedded pointers. If a nil embedded pointer is found it is
+// allocated if alloc is true, else an invalid value is returned.
+func Value(v reflect.Value, f *Field, alloc bool) reflect.Value {
+	for i, x := range f.Index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package fields
+
+import "reflect"
+import "testing"
+
+type base struct {
+	ID   int `lua:"id,omitempty,readonly"`
+	Name string
+	Both int
+}
+
+type Other struct {
+	Both int
+	Tag  int `json:"name"`
+}
+
+type outer struct {
+	base
+	*Other
+	Title  string `lua:"title"`
+	Secret string `lua:"-"`
+	hidden int
+}
+
+func TestFields(t *testing.T) {
+	s := Of(reflect.TypeOf(outer{}), false)
+
+	names := []string{}
+	for _, f := range s.List {
+		names = append(names, f.Name)
+	}
+	want := []string{"base", "id", "Name", "Other", "Tag", "title", "hidden"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("Wrong fields: %v, want %v", names, want)
+	}
+
+	if f := s.ByName["id"]; !f.ReadOnly || !f.OmitEmpty || !f.Exported {
+		t.Errorf("Wrong options for id: %+v", f)
+	}
+	if f := s.ByName["hidden"]; !f.ReadOnly || f.Exported {
+		t.Errorf("Wrong options for hidden: %+v", f)
+	}
+	if f := s.ByName["base"]; !f.Embedded || f.Exported {
+		t.Errorf("Wrong options for base: %+v", f)
+	}
+
+	// With json tags Other.Tag is tagged "name", so it wins over the untagged base.Name at the same depth.
+	s = Of(reflect.TypeOf(outer{}), true)
+	if f := s.ByName["name"]; f == nil || !reflect.DeepEqual(f.Index, []int{1, 1}) {
+		t.Errorf("Wrong field for name: %+v", f)
+	}
+	if s.ByName["Both"] != nil {
+		t.Errorf("Ambiguous field not hidden")
+	}
+}
+
+func TestValue(t *testing.T) {
+	v := reflect.ValueOf(&outer{}).Elem()
+	f := Of(v.Type(), false).ByName["Tag"]
+
+	if Value(v, f, false).IsValid() {
+		t.Errorf("Nil embedded pointer not detected")
+	}
+	Value(v, f, true).SetInt(5)
+	if o := v.Interface().(outer); o.Other == nil || o.Tag != 5 {
+		t.Errorf("Value not set through allocated pointer")
+	}
+}
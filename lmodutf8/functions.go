@@ -72,7 +72,6 @@ var functions = map[string]lua.NativeFunction{
 				return 0
 			} else if r == utf8.RuneError {
 				panic("invalid utf8 code at " + strconv.Itoa(k))
-				return 0
 			} else {
 				l.Push(k)
 				l.Push(r)
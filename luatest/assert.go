@@ -0,0 +1,334 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package luatest
+
+import "fmt"
+import "math"
+import "regexp"
+import "sort"
+import "strings"
+
+import "github.com/milochristiansen/lua"
+
+// The assert library is written in Go so that it can use regular expressions (the string library does not
+// support Lua patterns) and give useful descriptions of differences between tables.
+
+// maxDiffs is the most differences deep_equal will list.
+const maxDiffs = 10
+
+// OpenAssert replaces the global "assert" with a version that also has the assertion helpers as fields. Calling
+// it works the same as the standard assert.
+//
+// All the helpers take an optional message as their last argument, which is added to the start of the error.
+//
+//	assert.equal(actual, expected [, msg])             Compares with ==.
+//	assert.not_equal(actual, expected [, msg])         Compares with ~=.
+//	assert.deep_equal(actual, expected [, msg])        Compares tables by content (recursively), ignoring metatables.
+//	assert.near(actual, expected, tolerance [, msg])   Checks that two numbers are within tolerance of each other.
+//	assert.error_matches(f, regexp [, msg])            Calls f and checks that it raises an error matching a Go
+//	                                                   regular expression.
+//	assert.fail([msg])                                 Always fails.
+func OpenAssert(l *lua.State) {
+	l.Push("assert")
+	l.NewTable(0, 8)
+	l.SetTableFunctions(-1, map[string]lua.NativeFunction{
+		"equal": func(l *lua.State) int {
+			if !l.Compare(1, 2, lua.OpEqual) {
+				fail(l, 3, "values are not equal\n  expected: %v\n  actual:   %v", describe(l, 2), describe(l, 1))
+			}
+			return 0
+		},
+		"not_equal": func(l *lua.State) int {
+			if l.Compare(1, 2, lua.OpEqual) {
+				fail(l, 3, "values are equal: %v", describe(l, 1))
+			}
+			return 0
+		},
+		"deep_equal": func(l *lua.State) int {
+			a, e := capture(l, 1, map[string]*snapshot{}), capture(l, 2, map[string]*snapshot{})
+			diffs := []string{}
+			compare(a, e, "", &diffs, map[[2]*snapshot]bool{})
+			if len(diffs) == 0 {
+				return 0
+			}
+			if len(diffs) > maxDiffs {
+				diffs = append(diffs[:maxDiffs], fmt.Sprintf("... and %v more", len(diffs)-maxDiffs))
+			}
+			fail(l, 3, "values are not deeply equal\n  expected: %v\n  actual:   %v\n  differences:\n    %v",
+				format(e, 2), format(a, 2), strings.Join(diffs, "\n    "))
+			return 0
+		},
+		"near": func(l *lua.State) int {
+			a, e, tol := l.ToFloat(1), l.ToFloat(2), l.ToFloat(3)
+			if math.IsNaN(a) || math.IsNaN(e) || math.Abs(a-e) > tol {
+				fail(l, 4, "values are not within %v of each other\n  expected: %v\n  actual:   %v\n  difference: %v",
+					tol, describe(l, 2), describe(l, 1), math.Abs(a-e))
+			}
+			return 0
+		},
+		"error_matches": func(l *lua.State) int {
+			re, err := regexp.Compile(l.ToString(2))
+			if err != nil {
+				fail(l, 3, "invalid regular expression: %v", err)
+			}
+			l.PushIndex(1)
+			err = l.PCall(0, 0)
+			if err == nil {
+				fail(l, 3, "function did not raise an error, expected one matching %q", re)
+			}
+			if !re.MatchString(errorMessage(err)) {
+				fail(l, 3, "error does not match %q\n  error: %v", re, errorMessage(err))
+			}
+			return 0
+		},
+		"fail": func(l *lua.State) int {
+			fail(l, 1, "assertion failed")
+			return 0
+		},
+	})
+
+	// Keep assert(v, msg) working.
+	l.NewTable(0, 1)
+	l.Push("__call")
+	l.Push(func(l *lua.State) int {
+		if !l.ToBool(2) {
+			l.Push(l.OptString(3, "assertion failed!"))
+			l.Error()
+		}
+		return l.AbsIndex(-1) - 1
+	})
+	l.SetTableRaw(-3)
+	l.SetMetaTable(-2)
+
+	l.SetTableRaw(lua.GlobalsIndex)
+}
+
+// fail raises an error with the given message, prefixed with the user's message (at msgIdx) if there is one.
+func fail(l *lua.State, msgIdx int, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if !l.IsNil(msgIdx) {
+		msg = l.ToString(msgIdx) + ": " + msg
+	}
+	l.Push(msg)
+	l.Error()
+}
+
+// errorMessage strips the stack trace from an error.
+func errorMessage(err error) string {
+	msg := err.Error()
+	if i := strings.Index(msg, "\n  Stack Trace:"); i != -1 {
+		msg = msg[:i]
+	}
+	return msg
+}
+
+// describe formats a value on the stack for an error message.
+func describe(l *lua.State, i int) string {
+	return format(capture(l, i, map[string]*snapshot{}), 2)
+}
+
+// snapshot is a copy of a Lua value, so it can be compared and formatted without juggling the stack.
+type snapshot struct {
+	typ    lua.TypeID
+	scalar interface{} // Strings, numbers, and booleans as their Go values, other types by identity.
+	keys   []*snapshot // Sorted, tables only.
+	fields map[snapKey]*snapshot
+}
+
+type snapKey struct {
+	typ lua.TypeID
+	v   interface{}
+}
+
+func (s *snapshot) key() snapKey {
+	if f, ok := s.scalar.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return snapKey{lua.TypNumber, int64(f)} // 1.0 and 1 are the same key.
+	}
+	return snapKey{s.typ, s.scalar}
+}
+
+// capture makes a snapshot of the value at index i.
+func capture(l *lua.State, i int, seen map[string]*snapshot) *snapshot {
+	i = l.AbsIndex(i)
+	s := &snapshot{typ: l.TypeOf(i), scalar: l.GetRaw(i)}
+	if s.typ != lua.TypTable {
+		return s
+	}
+
+	id := s.scalar.(string)
+	if old, ok := seen[id]; ok {
+		return old
+	}
+	seen[id] = s
+
+	s.fields = map[snapKey]*snapshot{}
+	l.ForEachRaw(i, func() bool {
+		k := capture(l, -2, seen)
+		s.keys = append(s.keys, k)
+		s.fields[k.key()] = capture(l, -1, seen)
+		return true
+	})
+	sort.Slice(s.keys, func(a, b int) bool { return keyLess(s.keys[a], s.keys[b]) })
+	return s
+}
+
+// keyLess sorts numbers first, then strings, then everything else.
+func keyLess(a, b *snapshot) bool {
+	rank := func(s *snapshot) int {
+		switch s.typ {
+		case lua.TypNumber:
+			return 0
+		case lua.TypString:
+			return 1
+		}
+		return 2
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra < rb
+	}
+	if a.typ == lua.TypNumber {
+		return toFloat(a.scalar) < toFloat(b.scalar)
+	}
+	return fmt.Sprint(a.scalar) < fmt.Sprint(b.scalar)
+}
+
+func toFloat(v interface{}) float64 {
+	switch v2 := v.(type) {
+	case int64:
+		return float64(v2)
+	case float64:
+		return v2
+	}
+	return 0
+}
+
+// equal compares two non-table values the way == does (without meta methods).
+func (s *snapshot) equal(o *snapshot) bool {
+	if s.typ != o.typ {
+		return false
+	}
+	if s.typ == lua.TypNumber {
+		ai, aInt := s.scalar.(int64)
+		bi, bInt := o.scalar.(int64)
+		if aInt && bInt {
+			return ai == bi
+		}
+		return toFloat(s.scalar) == toFloat(o.scalar)
+	}
+	return s.scalar == o.scalar
+}
+
+// compare adds a line to diffs for every difference between actual and expected.
+func compare(a, e *snapshot, path string, diffs *[]string, visited map[[2]*snapshot]bool) {
+	if a.typ != lua.TypTable || e.typ != lua.TypTable {
+		if !a.equal(e) {
+			*diffs = append(*diffs, fmt.Sprintf("%v: expected %v, got %v", rootPath(path), format(e, 1), format(a, 1)))
+		}
+		return
+	}
+	if visited[[2]*snapshot{a, e}] {
+		return
+	}
+	visited[[2]*snapshot{a, e}] = true
+
+	keys := append([]*snapshot{}, e.keys...)
+	for _, k := range a.keys {
+		if _, ok := e.fields[k.key()]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+
+	for _, k := range keys {
+		kpath := path + keyPath(k)
+		av, aok := a.fields[k.key()]
+		ev, eok := e.fields[k.key()]
+		switch {
+		case !aok:
+			*diffs = append(*diffs, fmt.Sprintf("%v: missing, expected %v", kpath, format(ev, 1)))
+		case !eok:
+			*diffs = append(*diffs, fmt.Sprintf("%v: unexpected %v", kpath, format(av, 1)))
+		default:
+			compare(av, ev, kpath, diffs, visited)
+		}
+	}
+}
+
+func rootPath(path string) string {
+	if path == "" {
+		return "value"
+	}
+	return path
+}
+
+var identRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func keyPath(k *snapshot) string {
+	if s, ok := k.scalar.(string); ok && k.typ == lua.TypString && identRE.MatchString(s) {
+		return "." + s
+	}
+	return "[" + format(k, 0) + "]"
+}
+
+// format converts a snapshot to a string, showing the contents of tables up to the given depth.
+func format(s *snapshot, depth int) string {
+	switch s.typ {
+	case lua.TypNil:
+		return "nil"
+	case lua.TypString:
+		if str, ok := s.scalar.(string); ok {
+			return fmt.Sprintf("%q", str)
+		}
+	case lua.TypNumber:
+		if f, ok := s.scalar.(float64); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return fmt.Sprintf("%.1f", f) // Make floats obvious, since 1 and 1.0 are different types in 5.3.
+		}
+		return fmt.Sprint(s.scalar)
+	case lua.TypTable:
+		if depth <= 0 || len(s.keys) == 0 {
+			if len(s.keys) == 0 {
+				return "{}"
+			}
+			return "{...}"
+		}
+		parts := []string{}
+		for i, k := range s.keys {
+			if i == 8 {
+				parts = append(parts, "...")
+				break
+			}
+			v := format(s.fields[k.key()], depth-1)
+			if n, ok := k.scalar.(int64); ok && n == int64(i+1) {
+				parts = append(parts, v) // Part of the sequence.
+				continue
+			}
+			if str, ok := k.scalar.(string); ok && identRE.MatchString(str) {
+				parts = append(parts, str+" = "+v)
+				continue
+			}
+			parts = append(parts, "["+format(k, 0)+"] = "+v)
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return fmt.Sprint(s.scalar)
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+/*
+Run Lua tests as Go tests.
+
+Write tests in Lua as global functions with names starting with "test_" (or put them in a table and return it
+from the file), then run the file from a normal Go test:
+
+	func TestScripts(t *testing.T) {
+		luatest.Run(t, "testdata/*_test.lua", nil)
+	}
+
+Each file is a subtest, and each Lua test is a subtest of its file, so "go test -run" works as usual. Tests run in
+order by name. A failed test does not stop the others.
+
+Each test function is passed a test object with the following fields:
+
+	t.name          The name of the test.
+	t:log(...)      Logs the arguments (with tostring) to the Go test log.
+	t:skip([msg])   Stops the test and marks it as skipped.
+
+If there is a function named "setup" it is called before every test, and "teardown" is called after every test
+(even if the test or setup failed). Both are passed the test object.
+
+A test may also be a table of cases, with a "cases" table and a "run" function. Each case becomes a subtest
+(named after the case's "name" field, its key, or its index), and run is called with the test object and the
+case:
+
+	test_add = {
+		cases = {
+			{name = "small", a = 1, b = 2, sum = 3},
+			{name = "negative", a = -1, b = -2, sum = -3},
+		},
+		run = function(t, c)
+			assert.equal(c.a + c.b, c.sum)
+		end,
+	}
+
+The global assert is replaced with a version that has extra helpers, see OpenAssert.
+
+All the tests in a file share a State, so globals set by one test can be seen by later tests.
+*/
+package luatest
+
+import "fmt"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/lmodbase"
+import "github.com/milochristiansen/lua/lmodmath"
+import "github.com/milochristiansen/lua/lmodpackage"
+import "github.com/milochristiansen/lua/lmodstring"
+import "github.com/milochristiansen/lua/lmodtable"
+import "github.com/milochristiansen/lua/lmodutf8"
+
+// Options changes how tests are run. A nil *Options is the same as the zero value.
+type Options struct {
+	// NewState is used to create the State for each file, if nil a State with the whole standard library is used.
+	// This is where you add your own API. The assert library is added after this is called.
+	NewState func() *lua.State
+
+	// If not nil, Coverage is attached to every State.
+	Coverage *lua.Coverage
+}
+
+// Run runs the tests in every file matching a pattern (as used by filepath.Glob). It is an error for the pattern
+// to match nothing.
+func Run(t *testing.T, pattern string, opts *Options) {
+	t.Helper()
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("No files match %q.", pattern)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			RunFile(t, file, opts)
+		})
+	}
+}
+
+// RunFile runs the tests in a single file.
+func RunFile(t *testing.T, path string, opts *Options) {
+	t.Helper()
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunSource(t, filepath.ToSlash(path), string(src), opts)
+}
+
+// RunSource runs the tests in a string of Lua code. name is used as the chunk name.
+func RunSource(t *testing.T, name, src string, opts *Options) {
+	t.Helper()
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var l *lua.State
+	if opts.NewState != nil {
+		l = opts.NewState()
+	} else {
+		l = lua.NewState()
+		for _, open := range []lua.NativeFunction{lmodbase.Open, lmodpackage.Open, lmodstring.Open, lmodtable.Open, lmodmath.Open, lmodutf8.Open} {
+			l.Push(open)
+			l.Call(0, 0)
+		}
+	}
+	OpenAssert(l)
+	if opts.Coverage != nil {
+		l.SetCoverage(opts.Coverage)
+	}
+
+	r := &runner{l: l}
+	l.Output = r
+
+	err := l.LoadText(strings.NewReader(src), name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.t = t
+	err = l.PCall(0, 1)
+	r.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tests are either in the returned table or global.
+	if l.TypeOf(-1) != lua.TypTable {
+		l.Pop(1)
+		l.PushIndex(lua.GlobalsIndex)
+	}
+	r.tests = l.AbsIndex(-1)
+
+	names := []string{}
+	l.ForEachRaw(r.tests, func() bool {
+		name, ok := l.GetRaw(-2).(string)
+		if ok && l.TypeOf(-2) == lua.TypString && strings.HasPrefix(name, "test_") {
+			switch l.TypeOf(-1) {
+			case lua.TypFunction, lua.TypTable:
+				names = append(names, name)
+			}
+		}
+		return true
+	})
+	sort.Strings(names)
+	if len(names) == 0 {
+		t.Errorf("No tests found in %v.", name)
+	}
+
+	for _, name := range names {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			l.Push(name)
+			l.GetTableRaw(r.tests)
+			defer l.Pop(1)
+
+			if l.TypeOf(-1) == lua.TypFunction {
+				r.run(t, name, l.AbsIndex(-1), nil)
+				return
+			}
+			r.runCases(t, name, l.AbsIndex(-1))
+		})
+	}
+	l.Pop(1)
+}
+
+type runner struct {
+	l     *lua.State
+	t     *testing.T
+	tests int // Index of the table with the tests.
+
+	out     []byte // Unfinished line of output.
+	skipped *string
+}
+
+// Write sends output from the script to the log of the current test.
+func (r *runner) Write(p []byte) (int, error) {
+	r.out = append(r.out, p...)
+	if i := strings.LastIndexByte(string(r.out), '\n'); i != -1 {
+		r.t.Log(string(r.out[:i]))
+		r.out = r.out[i+1:]
+	}
+	return len(p), nil
+}
+
+func (r *runner) flush() {
+	if len(r.out) > 0 {
+		r.t.Log(string(r.out))
+		r.out = nil
+	}
+}
+
+// runCases runs a table driven test. The test table is at index tbl.
+func (r *runner) runCases(t *testing.T, name string, tbl int) {
+	l := r.l
+
+	l.Push("run")
+	l.GetTable(tbl)
+	run := l.AbsIndex(-1)
+	defer l.Pop(1)
+	l.Push("cases")
+	l.GetTable(tbl)
+	cases := l.AbsIndex(-1)
+	defer l.Pop(1)
+	if l.TypeOf(run) != lua.TypFunction || l.TypeOf(cases) != lua.TypTable {
+		t.Fatal("Table driven tests need a \"cases\" table and a \"run\" function.")
+	}
+
+	// Cases in the sequence go first, in order, then the rest sorted by name.
+	type tcase struct {
+		name string
+		key  interface{}
+	}
+	list := []tcase{}
+	n := l.LengthRaw(cases)
+	for i := 1; i <= n; i++ {
+		list = append(list, tcase{fmt.Sprintf("case_%v", i), int64(i)})
+	}
+	named := []tcase{}
+	l.ForEachRaw(cases, func() bool {
+		if l.TypeOf(-2) == lua.TypString {
+			named = append(named, tcase{l.ToString(-2), l.ToString(-2)})
+		}
+		return true
+	})
+	sort.Slice(named, func(i, j int) bool { return named[i].name < named[j].name })
+	list = append(list, named...)
+
+	for _, c := range list {
+		c := c
+		l.Push(c.key)
+		l.GetTableRaw(cases)
+		tc := l.AbsIndex(-1)
+		if l.TypeOf(-1) == lua.TypTable {
+			l.Push("name")
+			l.GetTableRaw(-2)
+			if l.TypeOf(-1) == lua.TypString {
+				c.name = l.ToString(-1)
+			}
+			l.Pop(1)
+		}
+
+		t.Run(c.name, func(t *testing.T) {
+			r.run(t, name+"/"+c.name, run, func() { l.PushIndex(tc) })
+		})
+		l.Pop(1)
+	}
+}
+
+// run runs one test. The function is at index fn, if pushArgs is not nil it is called to push extra arguments
+// after the test object.
+func (r *runner) run(t *testing.T, name string, fn int, pushArgs func()) {
+	l := r.l
+	r.t = t
+	defer r.flush()
+
+	var skipped *string
+	r.skipped = nil
+
+	// The test object.
+	l.NewTable(0, 3)
+	obj := l.AbsIndex(-1)
+	defer l.Pop(1)
+	l.Push("name")
+	l.Push(name)
+	l.SetTableRaw(obj)
+	l.SetTableFunctions(obj, map[string]lua.NativeFunction{
+		"log": func(l *lua.State) int {
+			parts := []string{}
+			for i := 2; i <= l.AbsIndex(-1); i++ {
+				parts = append(parts, l.ToString(i))
+			}
+			r.flush()
+			t.Log(strings.Join(parts, "\t"))
+			return 0
+		},
+		"skip": func(l *lua.State) int {
+			msg := l.OptString(2, "skipped")
+			r.skipped = &msg
+			l.Push("test skipped: " + msg)
+			l.Error()
+			return 0
+		},
+	})
+
+	// call calls a function with the test object, returning false if it failed or skipped.
+	call := func(what string, push func()) bool {
+		top := l.AbsIndex(-1)
+		push()
+		if l.TypeOf(top+1) != lua.TypFunction {
+			l.Pop(l.AbsIndex(-1) - top)
+			return true
+		}
+		l.PushIndex(obj)
+		if pushArgs != nil && what == "" {
+			pushArgs()
+		}
+		err := l.PCall(l.AbsIndex(-1)-top-1, 0)
+		if r.skipped != nil {
+			if skipped == nil {
+				skipped = r.skipped
+			}
+			return false
+		}
+		if err != nil {
+			r.flush()
+			if what != "" {
+				t.Errorf("%v failed: %v", what, err)
+			} else {
+				t.Error(err)
+			}
+			return false
+		}
+		return true
+	}
+	global := func(name string) func() {
+		return func() {
+			l.Push(name)
+			l.GetTableRaw(r.tests)
+		}
+	}
+
+	if call("setup", global("setup")) {
+		call("", func() { l.PushIndex(fn) })
+	}
+	call("teardown", global("teardown"))
+
+	if skipped != nil {
+		r.flush()
+		t.Skip(*skipped)
+	}
+}
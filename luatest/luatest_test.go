@@ -0,0 +1,92 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package luatest
+
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/testhelp"
+
+func TestRun(t *testing.T) {
+	var l *lua.State
+	Run(t, "testdata/*_test.lua", &Options{NewState: func() *lua.State {
+		l = testhelp.MkState()
+		return l
+	}})
+
+	// Make sure the table driven cases ran with the right names.
+	l.Push("counts")
+	l.GetTableRaw(lua.GlobalsIndex)
+	for _, name := range []string{"test_table/case_1", "test_table/negative", "test_table/zero"} {
+		l.Push(name)
+		l.GetTableRaw(-2)
+		if !l.ToBool(-1) {
+			t.Errorf("Case %v did not run.", name)
+		}
+		l.Pop(1)
+	}
+}
+
+func TestAssertFailures(t *testing.T) {
+	cases := []struct {
+		code, msg string
+	}{
+		{`assert(false, "plain")`, "plain"},
+		{`assert.equal(1, 2)`, "values are not equal\n  expected: 2\n  actual:   1"},
+		{`assert.equal("a", 1, "my message")`, "my message: values are not equal\n  expected: 1\n  actual:   \"a\""},
+		{`assert.not_equal(1.5, 1.5)`, "values are equal: 1.5"},
+		{`assert.near(1, 1.2, 0.1)`, "values are not within 0.1 of each other"},
+		{`assert.error_matches(function() end, "x")`, "function did not raise an error, expected one matching \"x\""},
+		{`assert.error_matches(function() error("boom") end, "^bang")`, "error does not match \"^bang\"\n  error: boom"},
+		{`assert.fail("nope")`, "nope: assertion failed"},
+		{
+			`assert.deep_equal({1, 2, {x = 1, y = 2}, z = 3.0}, {1, 3, {x = 1}, z = 3.0, w = "w"})`,
+			"values are not deeply equal\n" +
+				"  expected: {1, 3, {x = 1}, w = \"w\", z = 3.0}\n" +
+				"  actual:   {1, 2, {x = 1, y = 2}, z = 3.0}\n" +
+				"  differences:\n" +
+				"    [2]: expected 3, got 2\n" +
+				"    [3].y: unexpected 2\n" +
+				"    .w: missing, expected \"w\"",
+		},
+		{`local t = {} t.t = t assert.deep_equal(t, {t = 1})`, "    .t: expected 1, got {t = {...}}"},
+	}
+
+	for _, c := range cases {
+		l := testhelp.MkState()
+		OpenAssert(l)
+		err := l.LoadText(strings.NewReader(c.code), "test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.PCall(0, 0)
+		if err == nil {
+			t.Errorf("%v: did not fail", c.code)
+			continue
+		}
+		if msg := errorMessage(err); !strings.Contains(msg, c.msg) {
+			t.Errorf("%v:\nexpected: %q\ngot:      %q", c.code, c.msg, msg)
+		}
+	}
+}
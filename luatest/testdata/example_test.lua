@@ -0,0 +1,50 @@
+-- Tests for the luatest package, run by luatest_test.go.
+
+local setups, teardowns = 0, 0
+counts = {}
+
+function setup(t)
+	setups = setups + 1
+	counts.current = t.name
+end
+
+function teardown(t)
+	teardowns = teardowns + 1
+end
+
+function test_assert(t)
+	assert(true)
+	assert.equal(1 + 1, 2)
+	assert.equal(1, 1.0)
+	assert.not_equal("a", "b")
+	assert.deep_equal({1, 2, {x = "y"}}, {1, 2, {x = "y"}})
+	assert.near(0.1 + 0.2, 0.3, 1e-9)
+	assert.error_matches(function() error("bad value: 42") end, "value: [0-9]+$")
+	t:log("logged", 1)
+end
+
+function test_setup(t)
+	assert.equal(counts.current, "test_setup")
+	assert.equal(setups, teardowns + 1)
+end
+
+function test_skip(t)
+	t:skip("not ready")
+	error("unreachable")
+end
+
+test_table = {
+	cases = {
+		{a = 1, b = 2, sum = 3},
+		{name = "negative", a = -1, b = -2, sum = -3},
+		zero = {a = 0, b = 0, sum = 0},
+	},
+	run = function(t, c)
+		counts[t.name] = true
+		assert.equal(c.a + c.b, c.sum)
+	end,
+}
+
+function not_a_test()
+	error("should not run")
+end
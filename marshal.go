@@ -0,0 +1,577 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua
+
+import "encoding"
+import "fmt"
+import "math"
+import "reflect"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+
+import "github.com/milochristiansen/lua/internal/fields"
+
+// LuaMarshaler is implemented by types that know how to convert themselves to a Lua value. MarshalLua must push
+// exactly one value (unless it returns an error, in which case it must push nothing).
+type LuaMarshaler interface {
+	MarshalLua(l *State) error
+}
+
+// LuaUnmarshaler is implemented by types that know how to read themselves from a Lua value. The value is at the
+// given (absolute) stack index, and must be left where it is.
+type LuaUnmarshaler interface {
+	UnmarshalLua(l *State, idx int) error
+}
+
+// MarshalError is returned by Marshal for values that cannot be converted.
+type MarshalError struct {
+	Path string // The path to the value, for example "servers[2].port"
+	Msg  string
+}
+
+func (err *MarshalError) Error() string {
+	if err.Path == "" {
+		return "lua: " + err.Msg
+	}
+	return "lua: " + err.Path + ": " + err.Msg
+}
+
+// UnmarshalError is returned by Unmarshal for Lua values that cannot be stored in the given Go value.
+type UnmarshalError struct {
+	Path string // The path to the value, for example "servers[2].port"
+	Msg  string
+}
+
+func (err *UnmarshalError) Error() string {
+	if err.Path == "" {
+		return "lua: " + err.Msg
+	}
+	return "lua: " + err.Path + ": " + err.Msg
+}
+
+// Marshal pushes a copy of the given Go value as plain Lua values. This is much like encoding/json: structs and maps
+// become tables with string keys, slices and arrays become sequences, byte slices and encoding.TextMarshalers become
+// strings, and nil pointers, interfaces, maps, and slices become nil. Types that implement LuaMarshaler convert
+// themselves.
+//
+// Struct fields are named by a `lua:"name"` tag, or a `json:"name"` tag if there is no lua tag, or else the field
+// name. A name of "-" skips the field, and the "omitempty" option skips it if it has an empty value. Fields of
+// embedded structs are promoted like they are with encoding/json. These are the same rules supermeta uses, except
+// that supermeta ignores json tags and also shows unexported fields (read-only).
+//
+// If there is an error nothing is pushed.
+func Marshal(l *State, v interface{}) error {
+	val, err := l.marshal(reflect.ValueOf(v), "", 0)
+	if err != nil {
+		return err
+	}
+	l.stack.Push(val)
+	return nil
+}
+
+// Unmarshal stores a copy of the Lua value at the given index in the value pointed to by v. This is the reverse of
+// Marshal: tables may be stored in structs, maps, slices, and arrays, and strings in byte slices and
+// encoding.TextUnmarshalers. Types that implement LuaUnmarshaler read themselves.
+//
+// Table keys are matched to struct fields the same way Marshal names them, falling back to a case-insensitive match
+// if there is no exact match (more than one case-insensitive match is an error). Keys that do not match any field are
+// ignored, as are fields with no matching key. Numbers that do not fit in the destination type are an error.
+// Pointers are allocated as needed. Values stored in an empty interface are converted to nil, bool, int64, float64,
+// string, []interface{} (for sequences), or map[string]interface{} (for other tables, including empty ones).
+//
+// Errors are *UnmarshalError values with the path to the bad value.
+func Unmarshal(l *State, idx int, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &UnmarshalError{Msg: "Unmarshal requires a non-nil pointer."}
+	}
+	return l.unmarshal(l.get(l.AbsIndex(idx)), rv.Elem(), "")
+}
+
+var (
+	luaMarshalerType   = reflect.TypeOf((*LuaMarshaler)(nil)).Elem()
+	luaUnmarshalerType = reflect.TypeOf((*LuaUnmarshaler)(nil)).Elem()
+	textMarshalerType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Marshal/Unmarshal
+
+// marshalDepth is the maximum nesting depth for Marshal, past this the value is assumed to be cyclic.
+const marshalDepth = 1000
+
+func (l *State) marshal(rv reflect.Value, path string, depth int) (value, error) {
+	if depth > marshalDepth {
+		return nil, &MarshalError{path, "Value is nested too deeply (is it cyclic?)."}
+	}
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			return nil, nil
+		}
+	}
+
+	if rv.Kind() != reflect.Ptr && rv.CanAddr() && rv.Addr().CanInterface() {
+		if m, ok := rv.Addr().Interface().(LuaMarshaler); ok {
+			return l.marshalCustom(m, path)
+		}
+	}
+	if rv.CanInterface() {
+		switch m := rv.Interface().(type) {
+		case LuaMarshaler:
+			return l.marshalCustom(m, path)
+		case encoding.TextMarshaler:
+			b, err := m.MarshalText()
+			if err != nil {
+				return nil, &MarshalError{path, err.Error()}
+			}
+			return string(b), nil
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return nil, &MarshalError{path, fmt.Sprintf("Number %v does not fit in a Lua integer.", u)}
+		}
+		return int64(u), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Ptr, reflect.Interface:
+		return l.marshal(rv.Elem(), path, depth+1)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes()), nil
+		}
+
+		ln := rv.Len()
+		tbl := newTable(l, ln, 0)
+		for i := 0; i < ln; i++ {
+			v, err := l.marshal(rv.Index(i), fmt.Sprintf("%v[%v]", path, i+1), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tbl.SetRaw(int64(i+1), v)
+		}
+		return tbl, nil
+	case reflect.Map:
+		tbl := newTable(l, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := l.marshal(iter.Key(), path, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := k.(*table); ok || k == nil {
+				return nil, &MarshalError{path, fmt.Sprintf("Unsupported map key type: %v", rv.Type().Key())}
+			}
+			v, err := l.marshal(iter.Value(), joinPath(path, k), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tbl.SetRaw(k, v)
+		}
+		return tbl, nil
+	case reflect.Struct:
+		fs := marshalFields(rv.Type())
+		tbl := newTable(l, 0, len(fs))
+		for _, f := range fs {
+			fv := fields.Value(rv, f, false)
+			if !fv.IsValid() || f.OmitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			v, err := l.marshal(fv, joinPath(path, f.Name), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tbl.SetRaw(f.Name, v)
+		}
+		return tbl, nil
+	}
+	return nil, &MarshalError{path, fmt.Sprintf("Unsupported type: %v", rv.Type())}
+}
+
+func (l *State) marshalCustom(m LuaMarshaler, path string) (value, error) {
+	top := l.stack.TopIndex()
+	err := m.MarshalLua(l)
+	if err != nil {
+		return nil, &MarshalError{path, err.Error()}
+	}
+	if l.stack.TopIndex() != top+1 {
+		return nil, &MarshalError{path, "MarshalLua must push exactly one value."}
+	}
+	v := l.stack.Get(-1)
+	l.stack.Pop(1)
+	return v, nil
+}
+
+func (l *State) unmarshal(v value, rv reflect.Value, path string) error {
+	if v == nil {
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return nil
+	}
+
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return l.unmarshal(v, rv.Elem(), path)
+	}
+
+	if rv.CanAddr() {
+		switch u := rv.Addr().Interface().(type) {
+		case LuaUnmarshaler:
+			l.stack.Push(v)
+			err := u.UnmarshalLua(l, l.AbsIndex(-1))
+			l.stack.Pop(1)
+			if err != nil {
+				return &UnmarshalError{path, err.Error()}
+			}
+			return nil
+		case encoding.TextUnmarshaler:
+			if s, ok := v.(string); ok {
+				err := u.UnmarshalText([]byte(s))
+				if err != nil {
+					return &UnmarshalError{path, err.Error()}
+				}
+				return nil
+			}
+		}
+	}
+
+	bad := func() error {
+		return &UnmarshalError{path, fmt.Sprintf("Cannot store %v in Go value of type %v.", typeOf(v), rv.Type())}
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return bad()
+		}
+		rv.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if typeOf(v) != TypNumber {
+			return bad()
+		}
+		i, ok := tryInt(v)
+		if !ok || rv.OverflowInt(i) {
+			return &UnmarshalError{path, fmt.Sprintf("Number %v does not fit in a %v.", v, rv.Type())}
+		}
+		rv.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if typeOf(v) != TypNumber {
+			return bad()
+		}
+		i, ok := tryInt(v)
+		if !ok || i < 0 || rv.OverflowUint(uint64(i)) {
+			return &UnmarshalError{path, fmt.Sprintf("Number %v does not fit in a %v.", v, rv.Type())}
+		}
+		rv.SetUint(uint64(i))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if typeOf(v) != TypNumber {
+			return bad()
+		}
+		f := forceFloat(v)
+		if rv.OverflowFloat(f) {
+			return &UnmarshalError{path, fmt.Sprintf("Number %v does not fit in a %v.", v, rv.Type())}
+		}
+		rv.SetFloat(f)
+		return nil
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return bad()
+		}
+		rv.SetString(s)
+		return nil
+	case reflect.Interface:
+		if ud, ok := v.(*userData); ok && ud.data != nil && reflect.TypeOf(ud.data).AssignableTo(rv.Type()) {
+			rv.Set(reflect.ValueOf(ud.data))
+			return nil
+		}
+		if rv.NumMethod() != 0 {
+			return bad()
+		}
+		x, err := l.unmarshalAny(v, path)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		rv.Set(reflect.ValueOf(x))
+		return nil
+	}
+
+	if ud, ok := v.(*userData); ok {
+		if ud.data == nil || !reflect.TypeOf(ud.data).AssignableTo(rv.Type()) {
+			return bad()
+		}
+		rv.Set(reflect.ValueOf(ud.data))
+		return nil
+	}
+
+	if s, ok := v.(string); ok && rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+		rv.SetBytes([]byte(s))
+		return nil
+	}
+
+	tbl, ok := v.(*table)
+	if !ok {
+		return bad()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice:
+		ln := tbl.Length()
+		s := reflect.MakeSlice(rv.Type(), ln, ln)
+		for i := 0; i < ln; i++ {
+			err := l.unmarshal(tbl.GetRaw(int64(i+1)), s.Index(i), fmt.Sprintf("%v[%v]", path, i+1))
+			if err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+		return nil
+	case reflect.Array:
+		ln := tbl.Length()
+		for i := 0; i < rv.Len(); i++ {
+			if i >= ln {
+				rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+				continue
+			}
+			err := l.unmarshal(tbl.GetRaw(int64(i+1)), rv.Index(i), fmt.Sprintf("%v[%v]", path, i+1))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		kt, vt := rv.Type().Key(), rv.Type().Elem()
+		for _, kv := range tableEntries(tbl) {
+			k := reflect.New(kt).Elem()
+			err := l.unmarshalKey(kv[0], k, path)
+			if err != nil {
+				return err
+			}
+			e := reflect.New(vt).Elem()
+			err = l.unmarshal(kv[1], e, joinPath(path, kv[0]))
+			if err != nil {
+				return err
+			}
+			rv.SetMapIndex(k, e)
+		}
+		return nil
+	case reflect.Struct:
+		for _, f := range marshalFields(rv.Type()) {
+			x := tbl.GetRaw(f.Name)
+			if x == nil {
+				matches := []string{}
+				for k, hv := range tbl.hash {
+					if s, ok := k.(string); ok && hv != nil && strings.EqualFold(s, f.Name) {
+						matches = append(matches, s)
+					}
+				}
+				if len(matches) > 1 {
+					sort.Strings(matches)
+					return &UnmarshalError{joinPath(path, f.Name), fmt.Sprintf("Keys %q all match this field.", matches)}
+				}
+				if len(matches) == 1 {
+					x = tbl.GetRaw(matches[0])
+				}
+			}
+			if x == nil {
+				continue
+			}
+
+			fv := fields.Value(rv, f, true)
+			if !fv.IsValid() {
+				return &UnmarshalError{joinPath(path, f.Name), "Cannot set field through a nil embedded pointer."}
+			}
+			err := l.unmarshal(x, fv, joinPath(path, f.Name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return bad()
+}
+
+// unmarshalKey converts a table key to a map key. Numbers and strings are converted to each other if needed.
+func (l *State) unmarshalKey(k value, rv reflect.Value, path string) error {
+	switch rv.Kind() {
+	case reflect.String:
+		if typeOf(k) == TypNumber {
+			rv.SetString(toString(k))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if s, ok := k.(string); ok {
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err == nil {
+				k = i
+			}
+		}
+	}
+	return l.unmarshal(k, rv, joinPath(path, k))
+}
+
+// unmarshalAny converts a Lua value to the natural Go type for an empty interface.
+func (l *State) unmarshalAny(v value, path string) (interface{}, error) {
+	switch v2 := v.(type) {
+	case nil, bool, int64, float64, string:
+		return v2, nil
+	case *userData:
+		return v2.data, nil
+	case *table:
+		ln := v2.Length()
+		entries := tableEntries(v2)
+		if ln > 0 && ln == len(entries) {
+			s := make([]interface{}, ln)
+			for i := range s {
+				x, err := l.unmarshalAny(v2.GetRaw(int64(i+1)), fmt.Sprintf("%v[%v]", path, i+1))
+				if err != nil {
+					return nil, err
+				}
+				s[i] = x
+			}
+			return s, nil
+		}
+
+		m := make(map[string]interface{}, len(entries))
+		for _, kv := range entries {
+			x, err := l.unmarshalAny(kv[1], joinPath(path, kv[0]))
+			if err != nil {
+				return nil, err
+			}
+			m[toString(kv[0])] = x
+		}
+		return m, nil
+	}
+	return nil, &UnmarshalError{path, fmt.Sprintf("Cannot store %v in Go value of type interface{}.", typeOf(v))}
+}
+
+// tableEntries returns the key/value pairs in a table. Hash keys are sorted (by their string form) so that errors
+// are reported in a consistent order.
+func tableEntries(tbl *table) [][2]value {
+	entries := [][2]value{}
+	for i, v := range tbl.array {
+		if v != nil {
+			entries = append(entries, [2]value{int64(i + TableIndexOffset), v})
+		}
+	}
+	n := len(entries)
+	for k, v := range tbl.hash {
+		if v != nil {
+			entries = append(entries, [2]value{k, v})
+		}
+	}
+	hash := entries[n:]
+	sort.Slice(hash, func(i, j int) bool {
+		return toString(hash[i][0]) < toString(hash[j][0])
+	})
+	return entries
+}
+
+// joinPath adds a key to a path in Lua syntax.
+func joinPath(path string, k value) string {
+	s, ok := k.(string)
+	if !ok {
+		return fmt.Sprintf("%v[%v]", path, toString(k))
+	}
+	for i, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return fmt.Sprintf("%v[%q]", path, s)
+		}
+	}
+	if path == "" || s == "" {
+		if s == "" {
+			return path + `[""]`
+		}
+		return s
+	}
+	return path + "." + s
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Struct fields
+
+var marshalFieldCache sync.Map // reflect.Type -> []*fields.Field
+
+// marshalFields returns the fields of a struct type that are copied by Marshal and Unmarshal: the exported fields
+// (including promoted fields) in declaration order. Embedded structs are only copied through their promoted fields.
+func marshalFields(t reflect.Type) []*fields.Field {
+	if fs, ok := marshalFieldCache.Load(t); ok {
+		return fs.([]*fields.Field)
+	}
+
+	list := []*fields.Field{}
+	for _, f := range fields.Of(t, true).List {
+		if f.Exported && !f.Embedded {
+			list = append(list, f)
+		}
+	}
+	fs, _ := marshalFieldCache.LoadOrStore(t, list)
+	return fs.([]*fields.Field)
+}
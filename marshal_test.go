@@ -0,0 +1,176 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua_test
+
+import "reflect"
+import "strings"
+import "testing"
+import "time"
+
+import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/testhelp"
+
+type celsius float64
+
+func (c celsius) MarshalLua(l *lua.State) error {
+	l.Push(float64(c))
+	return nil
+}
+
+func (c *celsius) UnmarshalLua(l *lua.State, idx int) error {
+	*c = celsius((l.ToFloat(idx) - 32) * 5 / 9) // Stored in Fahrenheit
+	return nil
+}
+
+type server struct {
+	Host string `json:"host"`
+	Port int    `lua:"port"`
+	TLS  bool   `lua:"tls,omitempty"`
+}
+
+type base struct {
+	Name string `lua:"name"`
+}
+
+type config struct {
+	base
+	Servers []server          `lua:"servers"`
+	Limits  map[string]int    `lua:"limits"`
+	Extra   interface{}       `lua:"extra"`
+	Temp    celsius           `lua:"temp"`
+	Ratio   float32           `lua:"ratio,omitempty"`
+	Timeout time.Duration     `lua:"timeout,omitempty"`
+	Ignored string            `lua:"-"`
+	Labels  map[string]string `lua:"labels,omitempty"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	l := testhelp.MkState()
+
+	err := l.LoadText(strings.NewReader(`return {
+	name = "test",
+	servers = {
+		{host = "a", port = 80},
+		{HOST = "b", port = 443, tls = true},
+	},
+	limits = {cpu = 2, mem = 512},
+	extra = {1, "two", {x = 3}},
+	temp = 212,
+	Ignored = "x",
+}`), "config.lua", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Call(0, 1)
+
+	var c config
+	err = lua.Unmarshal(l, -1, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := config{
+		base: base{Name: "test"},
+		Servers: []server{
+			{Host: "a", Port: 80},
+			{Host: "b", Port: 443, TLS: true},
+		},
+		Limits: map[string]int{"cpu": 2, "mem": 512},
+		Extra:  []interface{}{int64(1), "two", map[string]interface{}{"x": int64(3)}},
+		Temp:   100,
+	}
+	if !reflect.DeepEqual(c, expect) {
+		t.Errorf("Unmarshal result incorrect:\n%#v\n%#v", c, expect)
+	}
+
+	// Errors give the path to the bad value.
+	l.Pop(1)
+	err = l.LoadText(strings.NewReader(`return {servers = {{port = 1}, {port = "x"}}}`), "bad.lua", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Call(0, 1)
+
+	err = lua.Unmarshal(l, -1, &c)
+	if err == nil || !strings.Contains(err.Error(), "servers[2].port") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for src, path := range map[string]string{
+		`return {ratio = 1e300}`:                        "ratio",
+		`return {servers = {{HOST = "a", Host = "b"}}}`: "servers[1].host",
+	} {
+		l.Pop(1)
+		err = l.LoadText(strings.NewReader(src), "bad.lua", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Call(0, 1)
+
+		err = lua.Unmarshal(l, -1, &c)
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("Unexpected error for %v: %v", src, err)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	l := testhelp.MkState()
+
+	c := &config{
+		base:    base{Name: "test"},
+		Servers: []server{{Host: "a", Port: 80}},
+		Limits:  map[string]int{"cpu": 2},
+		Temp:    37.5,
+		Ignored: "x",
+	}
+
+	l.Push("c")
+	err := lua.Marshal(l, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(c.name == "test")
+assert(#c.servers == 1)
+assert(c.servers[1].host == "a" and c.servers[1].port == 80)
+assert(c.servers[1].tls == nil)
+assert(c.limits.cpu == 2)
+assert(c.temp == 37.5)
+assert(c.timeout == nil)
+assert(c.labels == nil)
+assert(c.Ignored == nil)
+	`, nil)
+
+	err = lua.Marshal(l, struct{ F func() }{func() {}})
+	if err == nil || !strings.Contains(err.Error(), "F") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	err = lua.Marshal(l, []uint64{1, 1 << 63})
+	if err == nil || !strings.Contains(err.Error(), "[2]") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
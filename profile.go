@@ -0,0 +1,401 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua
+
+import "compress/gzip"
+import "io"
+import "reflect"
+import "runtime"
+import "sync/atomic"
+import "time"
+
+import "github.com/milochristiansen/lua/luautil"
+
+// The profiler is both instrumenting and sampling. Every call and every Lua instruction is counted against the
+// current call stack, and a ticker goroutine periodically asks for a wall clock sample. The time since the last
+// sample is charged to whatever instruction or native function is running when the request is noticed, so native
+// functions are charged for the time they spend running (minus any time spent in Lua code they call).
+
+// profilePeriod is how often a wall clock sample is taken.
+const profilePeriod = time.Millisecond
+
+type profiler struct {
+	w     io.Writer
+	start time.Time
+
+	root *profNode
+
+	last   time.Time // When the last wall clock sample was taken.
+	tick   int32     // Set when a wall clock sample is wanted, accessed atomically.
+	ticker *time.Ticker
+	done   chan bool
+}
+
+// profNode is one entry in the call tree. The same function called from two places gets two nodes.
+type profNode struct {
+	key  profKey
+	name string
+	fn   *function
+
+	parent   *profNode
+	children map[profKey]*profNode
+
+	calls  int64
+	counts []int64 // Instructions executed, by pc.
+	wall   []int64 // Wall clock time in nanoseconds, by pc (natives use index 0).
+}
+
+type profKey struct {
+	callPC int32        // The instruction in the parent that made the call, -1 if the parent is native.
+	code   *instruction // The function's first instruction (function values are copied, so this identifies the code).
+	native uintptr
+}
+
+// StartProfile starts profiling Lua code run by this State. The profile is written to w in the protobuf format
+// used by pprof when StopProfile is called, use "go tool pprof" to view it.
+//
+// The profile has three sample types: the number of calls, the number of Lua instructions executed, and wall clock
+// time (sampled every millisecond). Lua functions are identified by the name they were called by along with their source and line,
+// native functions by the name they were called by.
+//
+// Profiling must be started and stopped from the goroutine using the State. Only one profile may be active at
+// a time.
+func (l *State) StartProfile(w io.Writer) error {
+	if l.profiler != nil {
+		return luautil.Error{Msg: "Profiling already enabled.", Type: luautil.ErrTypGenRuntime}
+	}
+
+	p := &profiler{
+		w:      w,
+		start:  time.Now(),
+		last:   time.Now(),
+		root:   &profNode{children: map[profKey]*profNode{}},
+		ticker: time.NewTicker(profilePeriod),
+		done:   make(chan bool),
+	}
+	go func() {
+		for {
+			select {
+			case <-p.ticker.C:
+				atomic.StoreInt32(&p.tick, 1)
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	l.profiler = p
+	l.setHooks()
+	return nil
+}
+
+// StopProfile stops the current profile and writes it out.
+func (l *State) StopProfile() error {
+	p := l.profiler
+	if p == nil {
+		return luautil.Error{Msg: "Profiling not enabled.", Type: luautil.ErrTypGenRuntime}
+	}
+	l.profiler = nil
+	l.setHooks()
+	for _, fr := range l.stack.frames {
+		fr.prof, fr.profFn = nil, nil
+	}
+
+	p.ticker.Stop()
+	close(p.done)
+
+	return p.write(time.Since(p.start))
+}
+
+// hook is called by the VM before every instruction.
+func (p *profiler) hook(l *State, fr *callFrame) {
+	n := p.frameNode(l, len(l.stack.frames)-1)
+	idx := fr.pc - 1
+	n.counts[idx]++
+	if atomic.LoadInt32(&p.tick) != 0 {
+		n.wall[idx] += p.sample()
+	}
+}
+
+// sample returns the nanoseconds since the last sample.
+func (p *profiler) sample() int64 {
+	atomic.StoreInt32(&p.tick, 0)
+	now := time.Now()
+	d := now.Sub(p.last)
+	p.last = now
+	return int64(d)
+}
+
+// enter is called by the VM before a native function is run.
+func (p *profiler) enter(l *State) {
+	p.frameNode(l, len(l.stack.frames)-1)
+}
+
+// leave is called by the VM after a native function returns.
+func (p *profiler) leave(fr *callFrame) {
+	if fr.prof != nil && atomic.LoadInt32(&p.tick) != 0 {
+		fr.prof.wall[0] += p.sample()
+	}
+}
+
+// frameNode returns the node for frame k (an index into stack.frames), creating it if needed.
+func (p *profiler) frameNode(l *State, k int) *profNode {
+	fr := l.stack.frames[k]
+	if fr.fn == nil {
+		return p.root
+	}
+	if fr.prof != nil && fr.profFn == fr.fn {
+		return fr.prof
+	}
+
+	parent := p.root
+	key := profKey{callPC: -1}
+	if k > 0 {
+		parent = p.frameNode(l, k-1)
+		if caller := l.stack.frames[k-1]; caller.fn != nil && caller.fn.native == nil {
+			key.callPC = caller.pc - 1
+		}
+	}
+	if fr.fn.native != nil {
+		key.native = reflect.ValueOf(fr.fn.native).Pointer()
+	} else {
+		key.code = &fr.fn.proto.code[0]
+	}
+
+	n := parent.children[key]
+	if n == nil {
+		n = &profNode{key: key, name: l.callName(k), fn: fr.fn, parent: parent, children: map[profKey]*profNode{}}
+		if fr.fn.native != nil {
+			n.wall = make([]int64, 1)
+		} else {
+			n.counts = make([]int64, len(fr.fn.proto.code))
+			n.wall = make([]int64, len(fr.fn.proto.code))
+		}
+		parent.children[key] = n
+	}
+	n.calls++
+	fr.prof, fr.profFn = n, fr.fn
+	return n
+}
+
+// line returns the line for an instruction in a node's function, or 0 if unknown.
+func (n *profNode) line(pc int32) int {
+	if n.fn.native != nil || pc < 0 || int(pc) >= len(n.fn.proto.lineInfo) {
+		return 0
+	}
+	return n.fn.proto.lineInfo[pc]
+}
+
+// write encodes the profile. See https://github.com/google/pprof/blob/master/proto/profile.proto for the format.
+func (p *profiler) write(d time.Duration) error {
+	b := &protoBuf{}
+	strIdx := map[string]int{"": 0}
+	strs := []string{""}
+	str := func(s string) int64 {
+		i, ok := strIdx[s]
+		if !ok {
+			i = len(strs)
+			strIdx[s] = i
+			strs = append(strs, s)
+		}
+		return int64(i)
+	}
+	valueType := func(field int, typ, unit string) {
+		b.msg(field, func(b *protoBuf) {
+			b.int64(1, str(typ))
+			b.int64(2, str(unit))
+		})
+	}
+
+	valueType(1, "calls", "count")
+	valueType(1, "instructions", "count")
+	valueType(1, "wall", "nanoseconds")
+
+	type funcKey struct {
+		name, file string
+		start      int
+	}
+	type locKey struct {
+		fn   uint64
+		line int
+	}
+	funcs := map[funcKey]uint64{}
+	locs := map[locKey]uint64{}
+	fb := &protoBuf{}
+	lb := &protoBuf{}
+
+	location := func(n *profNode, line int) uint64 {
+		fk := funcKey{name: n.name}
+		sys := n.name
+		if n.fn.native != nil {
+			fk.file = "(native code)"
+			if f := runtime.FuncForPC(n.key.native); f != nil {
+				sys = f.Name()
+			}
+		} else {
+			fk.file = n.fn.proto.source
+			fk.start = n.fn.proto.lineDefined
+		}
+		fid, ok := funcs[fk]
+		if !ok {
+			fid = uint64(len(funcs) + 1)
+			funcs[fk] = fid
+			fb.msg(5, func(b *protoBuf) {
+				b.uint64(1, fid)
+				b.int64(2, str(fk.name))
+				b.int64(3, str(sys))
+				b.int64(4, str(fk.file))
+				b.int64(5, int64(fk.start))
+			})
+		}
+
+		lk := locKey{fid, line}
+		lid, ok := locs[lk]
+		if !ok {
+			lid = uint64(len(locs) + 1)
+			locs[lk] = lid
+			lb.msg(4, func(b *protoBuf) {
+				b.uint64(1, lid)
+				b.uint64(2, 1)
+				b.msg(4, func(b *protoBuf) {
+					b.uint64(1, fid)
+					b.int64(2, int64(line))
+				})
+			})
+		}
+		return lid
+	}
+
+	var walk func(n *profNode)
+	walk = func(n *profNode) {
+		if n != p.root {
+			// Samples are per line, not per instruction. Calls are charged to the first line.
+			lines := []int{}
+			values := map[int][3]int64{}
+			for pc := range n.wall {
+				v := [3]int64{0, 0, n.wall[pc]}
+				if pc == 0 {
+					v[0] = n.calls
+				}
+				if n.counts != nil {
+					v[1] = n.counts[pc]
+				}
+				if v == [3]int64{} {
+					continue
+				}
+				line := n.line(int32(pc))
+				old, ok := values[line]
+				if !ok {
+					lines = append(lines, line)
+				}
+				values[line] = [3]int64{old[0] + v[0], old[1] + v[1], old[2] + v[2]}
+			}
+
+			for _, line := range lines {
+				stk := []uint64{location(n, line)}
+				for c := n; c.parent != p.root; c = c.parent {
+					stk = append(stk, location(c.parent, c.parent.line(c.key.callPC)))
+				}
+				v := values[line]
+				b.msg(2, func(b *protoBuf) {
+					b.packed(1, stk)
+					b.packed(2, []uint64{uint64(v[0]), uint64(v[1]), uint64(v[2])})
+				})
+			}
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(p.root)
+
+	// A single fake mapping, marked as already symbolized so pprof doesn't go looking for a binary.
+	b.msg(3, func(b *protoBuf) {
+		b.uint64(1, 1)
+		b.int64(5, str("lua"))
+		b.uint64(7, 1)
+		b.uint64(8, 1)
+		b.uint64(9, 1)
+	})
+	b.data = append(b.data, lb.data...)
+	b.data = append(b.data, fb.data...)
+	valueType(11, "wall", "nanoseconds")
+	b.int64(12, int64(profilePeriod))
+	b.int64(9, p.start.UnixNano())
+	b.int64(10, int64(d))
+	for _, s := range strs {
+		b.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(p.w)
+	_, err := zw.Write(b.data)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protoBuf is a minimal protocol buffer encoder, just enough to write a pprof profile.
+type protoBuf struct {
+	data []byte
+}
+
+func (b *protoBuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuf) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *protoBuf) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuf) bytes(field int, d []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(d)))
+	b.data = append(b.data, d...)
+}
+
+func (b *protoBuf) packed(field int, xs []uint64) {
+	nb := &protoBuf{}
+	for _, x := range xs {
+		nb.varint(x)
+	}
+	b.bytes(field, nb.data)
+}
+
+func (b *protoBuf) msg(field int, f func(b *protoBuf)) {
+	nb := &protoBuf{}
+	f(nb)
+	b.bytes(field, nb.data)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua_test
+
+import "bytes"
+import "compress/gzip"
+import "io"
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua/testhelp"
+
+func TestProfile(t *testing.T) {
+	l := testhelp.MkState()
+
+	err := l.LoadText(strings.NewReader(`
+local function fib(n)
+	if n < 2 then return n end
+	return fib(n - 1) + fib(n - 2)
+end
+for i = 1, 15 do
+	tostring(fib(i))
+end
+`), "fib.lua", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := l.StartProfile(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.StartProfile(buf); err == nil {
+		t.Error("Starting a second profile did not fail.")
+	}
+	if err := l.PCall(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.StopProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.StopProfile(); err == nil {
+		t.Error("Stopping a stopped profile did not fail.")
+	}
+
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding the whole profile is more work than it is worth, make sure the string table looks right.
+	for _, s := range []string{"instructions", "wall", "nanoseconds", "fib", "main chunk", "tostring", "fib.lua", "(native code)"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("Profile does not contain %q.", s)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package lua_test
+
+import "strings"
+import "testing"
+
+import "github.com/milochristiansen/lua/testhelp"
+
+func TestTraceLine(t *testing.T) {
+	l := testhelp.MkState()
+
+	// The failing instruction is the last one on line 2, the next one is on line 3.
+	err := l.LoadText(strings.NewReader("local t\nlocal x = t.a\nlocal y = 3\n"), "trace", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.PCall(0, 0)
+	if err == nil || !strings.Contains(err.Error(), `"trace": <line: 2>`) {
+		t.Errorf("Wrong line in trace: %v", err)
+	}
+}
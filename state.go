@@ -50,6 +50,7 @@ misrepresented as being the original software.
 // restriction?).
 package lua
 
+import "context"
 import "fmt"
 import "os"
 import "io"
@@ -76,6 +77,31 @@ type State struct {
 	metaTbls [typeCount]*table
 
 	stack *stack
+
+	ctx context.Context
+
+	debugger *Debugger
+	profiler *profiler
+	coverage *Coverage
+	hooked   bool // Any of the above is set, so the VM only needs one check per instruction.
+}
+
+// setHooks updates hooked after attaching or removing a debugger, profiler, or coverage counter.
+func (l *State) setHooks() {
+	l.hooked = l.debugger != nil || l.profiler != nil || l.coverage != nil
+}
+
+// hook is called by the VM before every instruction if hooked is set.
+func (l *State) hook(fr *callFrame) {
+	if l.debugger != nil {
+		l.debugger.hook(fr)
+	}
+	if l.profiler != nil {
+		l.profiler.hook(l, fr)
+	}
+	if l.coverage != nil {
+		l.coverage.hook(fr)
+	}
 }
 
 // NewState creates a new State, ready to use.
@@ -122,4 +148,22 @@ func (l *State) Print(msg ...interface{}) {
 	fmt.Fprint(os.Stdout, msg...)
 }
 
+// Cancellation
+
+// SetContext sets the context native functions should use for operations that may block (waiting on a channel,
+// network access, etc). When the context is done such operations should give up and raise an error.
+//
+// The VM itself does not check the context between instructions.
+func (l *State) SetContext(ctx context.Context) {
+	l.ctx = ctx
+}
+
+// Context returns the context set with SetContext, or context.Background if none was set.
+func (l *State) Context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
 // See api.go for more.
@@ -0,0 +1,214 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package supermeta
+
+import "github.com/milochristiansen/lua"
+
+import "errors"
+import "fmt"
+import "reflect"
+
+// Possible channel errors.
+var ErrChanDir = errors.New("Channel direction does not allow this operation.")
+
+// chan
+//
+// Unexported chan fields can't be used through reflection, so they are nil.
+func toChan(l *lua.State, src reflect.Value) {
+	if src.IsNil() || !src.CanInterface() {
+		l.Push(nil)
+		return
+	}
+	chanTable(l, src, false)
+}
+func fromChan(l *lua.State, dest reflect.Value, src int) error {
+	if !dest.CanSet() {
+		return ErrCantSet
+	}
+
+	if v, ok := userValue(l, src, dest.Type()); ok {
+		dest.Set(v)
+		return nil
+	}
+	return ErrBadConv
+}
+
+func chanTable(l *lua.State, obj reflect.Value, ro bool) {
+	pushProxy(l, obj, ro)
+	if cachedMeta(l, obj.Type(), ro) {
+		l.SetMetaTable(-2)
+		return
+	}
+	l.NewTable(0, 5)
+
+	ct := obj.Type()
+	canSend := ct.ChanDir()&reflect.SendDir != 0
+	canRecv := ct.ChanDir()&reflect.RecvDir != 0
+
+	recv := func(l *lua.State, block bool) int {
+		if !canRecv {
+			l.Push(ErrChanDir.Error())
+			l.Error()
+		}
+
+		o, _ := proxyValue(l.ToUser(1))
+
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: o},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(l.Context().Done())},
+		}
+		if !block {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		}
+
+		i, v, ok := reflect.Select(cases)
+		switch {
+		case i == 1:
+			l.Push(l.Context().Err().Error())
+			l.Error()
+		case i == 2 || !ok:
+			l.Push(nil)
+			l.Push(false)
+			return 2
+		}
+		pushValue(l, v, ro)
+		l.Push(true)
+		return 2
+	}
+
+	methods := map[string]lua.NativeFunction{
+		"send": func(l *lua.State) int {
+			if ro {
+				l.Push(ErrReadOnly.Error())
+				l.Error()
+			}
+			if !canSend {
+				l.Push(ErrChanDir.Error())
+				l.Error()
+			}
+
+			o, _ := proxyValue(l.ToUser(1))
+
+			v := reflect.New(ct.Elem()).Elem()
+			if !l.IsNil(2) {
+				err := store(l, v, 2)
+				if err != nil {
+					l.Push(err.Error())
+					l.Error()
+				}
+			}
+
+			i, err := chanSelect([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: o, Send: v},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(l.Context().Done())},
+			})
+			if err != nil {
+				l.Push(err.Error())
+				l.Error()
+			}
+			if i == 1 {
+				l.Push(l.Context().Err().Error())
+				l.Error()
+			}
+			return 0
+		},
+		"recv": func(l *lua.State) int {
+			return recv(l, true)
+		},
+		"tryrecv": func(l *lua.State) int {
+			return recv(l, false)
+		},
+		"close": func(l *lua.State) int {
+			if ro {
+				l.Push(ErrReadOnly.Error())
+				l.Error()
+			}
+			if !canSend {
+				l.Push(ErrChanDir.Error())
+				l.Error()
+			}
+
+			o, _ := proxyValue(l.ToUser(1))
+
+			err := chanClose(o)
+			if err != nil {
+				l.Push(err.Error())
+				l.Error()
+			}
+			return 0
+		},
+	}
+
+	l.Push("__index")
+	l.Push(func(l *lua.State) int {
+		f, ok := methods[l.ToString(2)]
+		if !ok {
+			return 0
+		}
+		l.Push(f)
+		return 1
+	})
+	l.SetTableRaw(-3)
+
+	l.Push("__call")
+	l.Push(func(l *lua.State) int {
+		recv(l, true)
+		l.Pop(1)
+		return 1
+	})
+	l.SetTableRaw(-3)
+
+	l.Push("__len")
+	l.Push(func(l *lua.State) int {
+		o, _ := proxyValue(l.ToUser(1))
+
+		l.Push(o.Len())
+		return 1
+	})
+	l.SetTableRaw(-3)
+
+	cacheMeta(l, obj.Type(), ro)
+	l.SetMetaTable(-2)
+}
+
+// chanSelect is reflect.Select, but sending on a closed channel returns an error instead of panicking.
+func chanSelect(cases []reflect.SelectCase) (i int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	i, _, _ = reflect.Select(cases)
+	return i, nil
+}
+
+// chanClose closes a channel, returning an error instead of panicking if it is already closed.
+func chanClose(ch reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	ch.Close()
+	return nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package supermeta
+
+import "github.com/milochristiansen/lua"
+
+import "encoding"
+import "encoding/json"
+import "fmt"
+import "reflect"
+import "sync"
+import "time"
+
+// ToFunc pushes a Lua value for the given reflect.Value.
+type ToFunc func(l *lua.State, src reflect.Value)
+
+// FromFunc stores the Lua value at index src in dest, returning an error if this is not possible.
+type FromFunc func(l *lua.State, dest reflect.Value, src int) error
+
+type converter struct {
+	t    reflect.Type
+	to   ToFunc
+	from FromFunc
+}
+
+var convLock sync.RWMutex
+var convTypes = map[reflect.Type]*converter{}
+var convIfaces = []*converter{}
+
+// RegisterType sets the functions used to convert values of the given type, replacing any previous registration.
+// Registered converters are used before the normal conversion based on the value's kind. Either function may be nil,
+// in which case the normal conversion is used for that direction.
+//
+// If t is an interface type the converters are used for any type that implements it (or, when setting a value,
+// any type whose pointer implements it). Converters for a specific type always win over interface converters,
+// interfaces are tried in the order they were registered.
+//
+// The following types have converters by default:
+//
+//	time.Time               An RFC 3339 string. Numbers (seconds since the Unix epoch) may also be assigned.
+//	time.Duration           A number of seconds. Strings such as "1h30m" may also be assigned.
+//	json.RawMessage         A string holding the JSON text. Assigned strings must be valid JSON.
+//	encoding.TextMarshaler  A string from MarshalText. Strings are assigned with UnmarshalText if possible.
+//	fmt.Stringer            A string from String. Only used for types that would otherwise be nil (complex numbers).
+func RegisterType(t reflect.Type, to ToFunc, from FromFunc) {
+	convLock.Lock()
+	defer convLock.Unlock()
+
+	c := &converter{t: t, to: to, from: from}
+	if t.Kind() != reflect.Interface {
+		convTypes[t] = c
+		return
+	}
+	for i, old := range convIfaces {
+		if old.t == t {
+			convIfaces[i] = c
+			return
+		}
+	}
+	convIfaces = append(convIfaces, c)
+}
+
+// converterFor returns the registered converter for the given type (or nil). If dest is true interfaces also match
+// types whose pointer implements them.
+func converterFor(t reflect.Type, dest bool) *converter {
+	convLock.RLock()
+	defer convLock.RUnlock()
+
+	if c, ok := convTypes[t]; ok {
+		return c
+	}
+	for _, c := range convIfaces {
+		if t.Implements(c.t) || dest && reflect.PtrTo(t).Implements(c.t) {
+			return c
+		}
+	}
+	return nil
+}
+
+// toRegistered pushes a value using its registered converter, if any.
+func toRegistered(l *lua.State, v reflect.Value) bool {
+	if !v.IsValid() || !v.CanInterface() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+		if v.IsNil() {
+			return false
+		}
+	}
+
+	c := converterFor(v.Type(), false)
+	if c == nil || c.to == nil {
+		return false
+	}
+	if c.t == stringerType && !stringOnly(v) {
+		return false
+	}
+	c.to(l, v)
+	return true
+}
+
+// stringOnly returns true if the value (or the value it points to) has no Lua form other than its String result.
+// Everything else keeps its normal form, so numeric enums can be assigned back and proxies can be read-only.
+func stringOnly(v reflect.Value) bool {
+	k := v.Kind()
+	if k == reflect.Ptr {
+		k = v.Type().Elem().Kind()
+	}
+	switch k {
+	case reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+// store is the "from" equivalent of pushValue, it stores a Lua value using the registered converter for the
+// destination type, or the normal conversion for its kind.
+func store(l *lua.State, dest reflect.Value, src int) error {
+	if dest.IsValid() && dest.CanInterface() {
+		if c := converterFor(dest.Type(), true); c != nil && c.from != nil {
+			return c.from(l, dest, src)
+		}
+	}
+	return from(dest.Kind())(l, dest, src)
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	durationType    = reflect.TypeOf(time.Duration(0))
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	marshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	stringerType    = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+func init() {
+	RegisterType(timeType, toTime, fromTime)
+	RegisterType(durationType, toDuration, fromDuration)
+	RegisterType(rawMessageType, toRawMessage, fromRawMessage)
+	RegisterType(marshalerType, toText, fromText)
+	RegisterType(stringerType, toStringer, nil)
+}
+
+// time.Time
+func toTime(l *lua.State, src reflect.Value) {
+	l.Push(src.Interface().(time.Time).Format(time.RFC3339Nano))
+}
+func fromTime(l *lua.State, dest reflect.Value, src int) error {
+	if !dest.CanSet() {
+		return ErrCantSet
+	}
+
+	var t time.Time
+	switch l.TypeOf(src) {
+	case lua.TypNumber:
+		f := l.ToFloat(src)
+		sec := int64(f)
+		t = time.Unix(sec, int64((f-float64(sec))*1e9))
+	case lua.TypString:
+		var err error
+		t, err = time.Parse(time.RFC3339Nano, l.ToString(src))
+		if err != nil {
+			return ErrBadConv
+		}
+	default:
+		return ErrBadConv
+	}
+	dest.Set(reflect.ValueOf(t).Convert(dest.Type()))
+	return nil
+}
+
+// time.Duration
+func toDuration(l *lua.State, src reflect.Value) {
+	l.Push(time.Duration(src.Int()).Seconds())
+}
+func fromDuration(l *lua.State, dest reflect.Value, src int) error {
+	if !dest.CanSet() {
+		return ErrCantSet
+	}
+
+	switch l.TypeOf(src) {
+	case lua.TypNumber:
+		dest.SetInt(int64(l.ToFloat(src) * float64(time.Second)))
+	case lua.TypString:
+		d, err := time.ParseDuration(l.ToString(src))
+		if err != nil {
+			return ErrBadConv
+		}
+		dest.SetInt(int64(d))
+	default:
+		return ErrBadConv
+	}
+	return nil
+}
+
+// json.RawMessage
+func toRawMessage(l *lua.State, src reflect.Value) {
+	l.Push(string(src.Bytes()))
+}
+func fromRawMessage(l *lua.State, dest reflect.Value, src int) error {
+	if !dest.CanSet() {
+		return ErrCantSet
+	}
+	if l.TypeOf(src) != lua.TypString {
+		return ErrBadConv
+	}
+
+	b := []byte(l.ToString(src))
+	if !json.Valid(b) {
+		return ErrBadConv
+	}
+	dest.SetBytes(b)
+	return nil
+}
+
+// encoding.TextMarshaler
+func toText(l *lua.State, src reflect.Value) {
+	b, err := src.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		l.Push(err.Error())
+		l.Error()
+	}
+	l.Push(string(b))
+}
+func fromText(l *lua.State, dest reflect.Value, src int) error {
+	if l.TypeOf(src) != lua.TypString {
+		return from(dest.Kind())(l, dest, src)
+	}
+
+	var u encoding.TextUnmarshaler
+	switch {
+	case dest.Kind() == reflect.Ptr && dest.Type().Implements(unmarshalerType):
+		if dest.IsNil() {
+			if !dest.CanSet() {
+				return ErrCantSet
+			}
+			dest.Set(reflect.New(dest.Type().Elem()))
+		}
+		u = dest.Interface().(encoding.TextUnmarshaler)
+	case dest.CanAddr() && dest.Addr().Type().Implements(unmarshalerType):
+		if !dest.CanSet() {
+			return ErrCantSet
+		}
+		u = dest.Addr().Interface().(encoding.TextUnmarshaler)
+	default:
+		return from(dest.Kind())(l, dest, src)
+	}
+
+	if err := u.UnmarshalText([]byte(l.ToString(src))); err != nil {
+		return ErrBadConv
+	}
+	return nil
+}
+
+// fmt.Stringer
+func toStringer(l *lua.State, src reflect.Value) {
+	l.Push(src.Interface().(fmt.Stringer).String())
+}
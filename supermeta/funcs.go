@@ -0,0 +1,210 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package supermeta
+
+import "github.com/milochristiansen/lua"
+
+import "fmt"
+import "reflect"
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// func
+//
+// Unexported func fields can't be called through reflection, so they are nil.
+func toFunc(l *lua.State, src reflect.Value) {
+	if src.IsNil() || !src.CanInterface() {
+		l.Push(nil)
+		return
+	}
+	l.Push(func(l *lua.State) int {
+		return callFunc(l, src, 1, false)
+	})
+}
+func fromFunc(l *lua.State, dest reflect.Value, src int) error {
+	if !dest.CanSet() {
+		return ErrCantSet
+	}
+
+	if v, ok := userValue(l, src, dest.Type()); ok {
+		dest.Set(v)
+		return nil
+	}
+	return ErrBadConv
+}
+
+// method pushes a function that calls a method of obj, or returns false if there is no such method. Methods
+// with pointer receivers are found if obj is addressable. The proxy obj was read from must be at index self.
+//
+// The function may be called as "obj:Method()" or "obj.Method()", the receiver argument is skipped if present.
+// Only the exact proxy the method was read from counts as the receiver, another proxy for a value of the same
+// type is a normal argument.
+//
+// If ro is true pointer receiver methods are not available and the results are pushed as read-only values.
+func method(l *lua.State, obj reflect.Value, self int, name string, ro bool) bool {
+	m := obj.MethodByName(name)
+	if !m.IsValid() && obj.CanAddr() && !ro {
+		m = obj.Addr().MethodByName(name)
+	}
+	if !m.IsValid() {
+		return false
+	}
+
+	l.PushClosure(func(l *lua.State) int {
+		first := 1
+		if l.TypeOf(1) == lua.TypUserData && l.CompareRaw(1, lua.FirstUpVal-1, lua.OpEqual) {
+			first = 2
+		}
+		return callFunc(l, m, first, ro)
+	}, self)
+	return true
+}
+
+// userValue returns the Go value in a userdata if it can be assigned to the given type.
+//
+// Read-only proxies never match, so they are converted (copied) the same way as a Lua table would be.
+func userValue(l *lua.State, i int, t reflect.Type) (reflect.Value, bool) {
+	if l.TypeOf(i) != lua.TypUserData {
+		return reflect.Value{}, false
+	}
+
+	v := l.ToUser(i)
+	if rv, ok := v.(reflect.Value); ok {
+		switch {
+		case rv.Type().AssignableTo(t):
+			return rv, true
+		case rv.CanAddr() && rv.Addr().Type().AssignableTo(t):
+			return rv.Addr(), true
+		}
+		return reflect.Value{}, false
+	}
+	if _, ok := v.(readOnly); ok {
+		return reflect.Value{}, false
+	}
+	if v != nil && reflect.TypeOf(v).AssignableTo(t) {
+		return reflect.ValueOf(v), true
+	}
+	return reflect.Value{}, false
+}
+
+// ifaceValue stores the value at index src in an interface typed dest. Basic Lua values are stored as the
+// matching Go value (bool, int64, float64 or string) and proxies as the value they hold.
+//
+// Read-only proxies are stored as a copy, proxies for maps, slices and other reference types are not allowed.
+func ifaceValue(l *lua.State, dest reflect.Value, src int) error {
+	var v reflect.Value
+	switch l.TypeOf(src) {
+	case lua.TypBool, lua.TypNumber, lua.TypString:
+		v = reflect.ValueOf(l.GetRaw(src))
+	case lua.TypUserData:
+		u := l.ToUser(src)
+		if ro, ok := u.(readOnly); ok {
+			v = reflect.Indirect(ro.v)
+			switch v.Kind() {
+			case reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Ptr, reflect.Interface,
+				reflect.UnsafePointer:
+				return ErrBadConv
+			}
+			break
+		}
+		if rv, ok := proxyValue(u); ok {
+			v = rv
+			break
+		}
+		v = reflect.ValueOf(u)
+	default:
+		return ErrBadConv
+	}
+
+	if !v.IsValid() || !v.Type().AssignableTo(dest.Type()) {
+		return ErrBadConv
+	}
+	dest.Set(v)
+	return nil
+}
+
+// callFunc calls a Go function with the arguments on the stack starting at first, and pushes the results.
+//
+// Missing arguments and nils are passed as zero values, extra arguments are ignored. If the last result is an
+// error it is raised (if not nil) instead of being returned. If ro is true the results are pushed as read-only
+// values.
+func callFunc(l *lua.State, fn reflect.Value, first int, ro bool) int {
+	ft := fn.Type()
+	top := l.AbsIndex(-1)
+
+	fixed := ft.NumIn()
+	if ft.IsVariadic() {
+		fixed--
+	}
+
+	args := []reflect.Value{}
+	arg := func(t reflect.Type, i int) reflect.Value {
+		v := reflect.New(t).Elem()
+		if i > top || l.IsNil(i) {
+			return v
+		}
+		if uv, ok := userValue(l, i, t); ok {
+			v.Set(uv)
+			return v
+		}
+
+		var err error
+		switch t.Kind() {
+		case reflect.Interface:
+			err = ifaceValue(l, v, i)
+		case reflect.Ptr:
+			v.Set(reflect.New(t.Elem()))
+			fallthrough
+		default:
+			err = store(l, v, i)
+		}
+		if err != nil {
+			l.Push(fmt.Sprintf("Bad argument #%v: %v", i-first+1, err))
+			l.Error()
+		}
+		return v
+	}
+	for i := 0; i < fixed; i++ {
+		args = append(args, arg(ft.In(i), first+i))
+	}
+	if ft.IsVariadic() {
+		et := ft.In(fixed).Elem()
+		for i := first + fixed; i <= top; i++ {
+			args = append(args, arg(et, i))
+		}
+	}
+
+	rtns := fn.Call(args)
+
+	if n := len(rtns); n > 0 && ft.Out(n-1) == errorType {
+		if err := rtns[n-1]; !err.IsNil() {
+			l.Push(err.Interface().(error).Error())
+			l.Error()
+		}
+		rtns = rtns[:n-1]
+	}
+	for _, r := range rtns {
+		pushValue(l, r, ro)
+	}
+	return len(rtns)
+}
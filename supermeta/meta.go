@@ -0,0 +1,127 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package supermeta
+
+import "github.com/milochristiansen/lua"
+
+import "fmt"
+import "reflect"
+import "sync"
+import "sync/atomic"
+
+// Proxy metatables are built once per type (and mode) for each State and kept in the registry. For this to work
+// metamethods must only get the object they work on from their first argument, never from a closure.
+
+type metaKey struct {
+	t  reflect.Type
+	ro bool
+}
+
+var metaIDs sync.Map // metaKey -> string (registry key)
+var metaNext int64
+
+func metaName(t reflect.Type, ro bool) string {
+	k := metaKey{t, ro}
+	if name, ok := metaIDs.Load(k); ok {
+		return name.(string)
+	}
+	name, _ := metaIDs.LoadOrStore(k, fmt.Sprintf("supermeta.%v", atomic.AddInt64(&metaNext, 1)))
+	return name.(string)
+}
+
+// cachedMeta pushes the cached metatable for the given type and returns true, or returns false (and pushes nothing)
+// if there is no cached metatable.
+func cachedMeta(l *lua.State, t reflect.Type, ro bool) bool {
+	l.Push(metaName(t, ro))
+	if l.GetTableRaw(lua.RegistryIndex) == lua.TypTable {
+		return true
+	}
+	l.Pop(1)
+	return false
+}
+
+// cacheMeta adds the metamethods shared by all proxies to the metatable on TOS and stores it in the cache.
+// The table is left on the stack.
+func cacheMeta(l *lua.State, t reflect.Type, ro bool) {
+	l.Push("__eq")
+	l.Push(func(l *lua.State) int {
+		l.Push(sameValue(l))
+		return 1
+	})
+	l.SetTableRaw(-3)
+
+	l.Push("__tostring")
+	l.Push(func(l *lua.State) int {
+		o, _ := proxyValue(l.ToUser(1))
+
+		if p, ok := address(o); ok {
+			l.Push(fmt.Sprintf("%v: %#x", o.Type(), p))
+			return 1
+		}
+		l.Push(o.Type().String())
+		return 1
+	})
+	l.SetTableRaw(-3)
+
+	l.Push(metaName(t, ro))
+	l.PushIndex(-2)
+	l.SetTableRaw(lua.RegistryIndex)
+}
+
+// address returns the address of the data behind a value, if it has one.
+func address(v reflect.Value) (uintptr, bool) {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.Ptr:
+		return v.Pointer(), true
+	}
+	if v.CanAddr() {
+		return v.Addr().Pointer(), true
+	}
+	return 0, false
+}
+
+// sameValue returns true if the first two arguments are proxies for the same Go value. Values that are not
+// addressable (copies) are the same if they are equal.
+func sameValue(l *lua.State) bool {
+	if l.TypeOf(1) != lua.TypUserData || l.TypeOf(2) != lua.TypUserData {
+		return false
+	}
+	a, ok := proxyValue(l.ToUser(1))
+	if !ok {
+		return false
+	}
+	b, ok := proxyValue(l.ToUser(2))
+	if !ok || a.Type() != b.Type() {
+		return false
+	}
+
+	pa, oka := address(a)
+	pb, okb := address(b)
+	if oka && okb {
+		return pa == pb && (a.Kind() != reflect.Slice || a.Len() == b.Len())
+	}
+	if a.Type().Comparable() && a.CanInterface() && b.CanInterface() {
+		return a.Interface() == b.Interface()
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package supermeta
+
+import "github.com/milochristiansen/lua"
+
+import "reflect"
+
+// readOnly wraps the value of a read-only proxy. Using a different type (rather than a plain reflect.Value)
+// keeps code that accepts proxies (function arguments, table assignment) from getting a writable reference.
+type readOnly struct {
+	v reflect.Value
+}
+
+// proxyValue returns the value held by a proxy, read-only or not.
+func proxyValue(u interface{}) (reflect.Value, bool) {
+	switch v := u.(type) {
+	case reflect.Value:
+		return v, true
+	case readOnly:
+		return v.v, true
+	}
+	return reflect.Value{}, false
+}
+
+// pushProxy pushes the userdata for a proxy.
+func pushProxy(l *lua.State, obj reflect.Value, ro bool) {
+	if ro {
+		l.Push(readOnly{obj})
+		return
+	}
+	l.Push(obj)
+}
+
+// pushValue pushes a value using its registered converter or the normal conversion for its kind. Proxies are
+// read-only if ro is true.
+func pushValue(l *lua.State, v reflect.Value, ro bool) {
+	if toRegistered(l, v) {
+		return
+	}
+	if !ro {
+		to(v.Kind())(l, v)
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		if asString(v.Type()) {
+			l.Push(sliceString(v))
+			return
+		}
+		sliceTable(l, v, true)
+	case reflect.Map:
+		mapTable(l, v, true)
+	case reflect.Struct:
+		structTable(l, v, true)
+	case reflect.Ptr, reflect.Interface:
+		pushValue(l, v.Elem(), true)
+	case reflect.Chan:
+		if v.IsNil() || !v.CanInterface() {
+			l.Push(nil)
+			return
+		}
+		chanTable(l, v, true)
+	case reflect.Func:
+		if v.IsNil() || !v.CanInterface() {
+			l.Push(nil)
+			return
+		}
+		l.Push(func(l *lua.State) int {
+			return callFunc(l, v, 1, true)
+		})
+	default:
+		to(v.Kind())(l, v)
+	}
+}
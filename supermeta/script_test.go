@@ -24,6 +24,14 @@ package supermeta_test
 
 import "testing"
 
+import "context"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "math/big"
+import "reflect"
+import "time"
+
 import "github.com/milochristiansen/lua"
 import "github.com/milochristiansen/lua/supermeta"
 import "github.com/milochristiansen/lua/testhelp"
@@ -225,3 +233,463 @@ x.Y = {[{A = "A"}] = 1, [{A = "B"}] = 2, [{A = "C"}] = 3}
 	testhelp.Assert(t, x[basics{A: "B"}] == 2, "Set failed")
 	testhelp.Assert(t, x[basics{A: "C"}] == 3, "Set failed")
 }
+
+type counter struct {
+	N int
+}
+
+func (c counter) Get() int {
+	return c.N
+}
+
+func (c *counter) Add(n ...int) int {
+	for _, v := range n {
+		c.N += v
+	}
+	return c.N
+}
+
+func (c *counter) Check(max int) (bool, error) {
+	if c.N > max {
+		return false, errors.New("too big")
+	}
+	return true, nil
+}
+
+func (c counter) Same(o counter) bool {
+	return c.N == o.N
+}
+
+func (c counter) Join(v ...interface{}) string {
+	return fmt.Sprint(v...)
+}
+
+func TestMethods(t *testing.T) {
+	l := testhelp.MkState()
+
+	l.Push("x")
+	x := &counter{N: 1}
+	supermeta.New(l, x)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(x:Get() == 1)
+assert(x.Get() == 1)
+
+assert(x:Add(1, 2, 3) == 7)
+assert(x.Add() == 7)
+
+assert(x:Check(10) == true)
+local ok = pcall(x.Check, x, 5)
+assert(not ok)
+
+return x.N
+	`, 7)
+
+	testhelp.Assert(t, x.N == 7, "Value did not persist")
+
+	l.Push("m")
+	supermeta.New(l, map[string]counter{"a": {N: 1}, "b": {N: 2}, "c": {N: 1}})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(m.a:Same(m.c))
+assert(not m.a.Same(m.b))
+assert(m.a.Same(m.c))
+
+assert(x:Join("a", 1, true) == "a1 true")
+assert(x.Join(m.b, nil) == "{2} <nil>")
+return x.Join()
+	`, "")
+}
+
+func TestFuncs(t *testing.T) {
+	l := testhelp.MkState()
+
+	l.Push("x")
+	x := &struct {
+		F func(string, int) (string, int)
+		G func(*counter) int
+		C counter
+		h func() int
+	}{
+		F: func(s string, n int) (string, int) { return s + "!", n * 2 },
+		G: func(c *counter) int { return c.N },
+		C: counter{N: 3},
+		h: func() int { return 1 },
+	}
+	supermeta.New(l, x)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+local s, n = x.F("a", 2)
+assert(s == "a!" and n == 4)
+
+assert(x.G(x.C) == 3)
+
+-- Unexported funcs can't be called.
+assert(x.h == nil)
+
+local s, n = x.F()
+return s..n
+	`, "!0")
+}
+
+type tagBase struct {
+	ID   int `lua:"id,readonly"`
+	Port int `lua:"port,omitempty,readonly"`
+	Name string
+}
+
+type Inner struct {
+	N int
+}
+
+type tagged struct {
+	tagBase
+	*Inner
+	Title  string `lua:"title"`
+	Secret string `lua:"-"`
+}
+
+func TestStructTags(t *testing.T) {
+	l := testhelp.MkState()
+
+	l.Push("x")
+	x := &tagged{tagBase: tagBase{ID: 1, Port: 80, Name: "a"}, Title: "t", Secret: "s"}
+	supermeta.New(l, x)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(x.id == 1)
+assert(x.ID == nil)
+assert(x.Name == "a")
+assert(x.title == "t")
+assert(x.Title == nil)
+assert(x.Secret == nil)
+
+assert(not pcall(function() x.id = 2 end))
+assert(not pcall(function() x.port = 8080 end))
+assert(x.port == 80)
+assert(not pcall(function() x.Secret = "x" end))
+assert(not pcall(function() x.Nope = "x" end))
+
+x.Name = "b"
+x.title = "u"
+x.N = 5 -- allocates the embedded pointer
+
+assert(not pcall(function() x.tagBase = {id = 5} end))
+
+return x.N
+	`, 5)
+
+	testhelp.Assert(t, x.Name == "b" && x.Title == "u" && x.ID == 1 && x.Port == 80 && x.Secret == "s", "Fields not set correctly")
+	testhelp.Assert(t, x.Inner != nil && x.N == 5, "Embedded pointer not allocated")
+}
+
+func TestByteSlices(t *testing.T) {
+	l := testhelp.MkState()
+
+	l.Push("x")
+	x := &struct {
+		B []byte
+		R []rune
+		A [4]byte
+		N []int32
+	}{B: []byte("abc"), R: []rune("héllo"), N: []int32{-5, 2000000}}
+	supermeta.New(l, x)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(x.B[1] == 97)
+assert(x.B:string() == "abc")
+assert(x.R:string() == "héllo")
+local b = x.B:bytes()
+assert(#b == 3 and b[3] == 99)
+
+x.B = "xyz"
+x.R = "wörld"
+x.A = "hi"
+	`, nil)
+
+	testhelp.Assert(t, string(x.B) == "xyz" && string(x.R) == "wörld", "Value did not persist")
+	testhelp.Assert(t, x.A == [4]byte{'h', 'i', 0, 0}, "Array not set correctly")
+
+	supermeta.BytesAsStrings = true
+	defer func() { supermeta.BytesAsStrings = false }()
+
+	testhelp.AssertBlock(t, l, `
+assert(x.R:string() == "wörld")
+assert(x.N[1] == -5 and x.N[2] == 2000000)
+assert(x.A == "hi\0\0")
+return x.B
+	`, "xyz")
+
+	supermeta.RunesAsStrings = true
+	defer func() { supermeta.RunesAsStrings = false }()
+
+	testhelp.AssertBlock(t, l, `
+return x.R
+	`, "wörld")
+}
+
+func TestReadOnly(t *testing.T) {
+	l := testhelp.MkState()
+
+	type item struct {
+		Name string
+		Tags []string
+	}
+
+	l.Push("x")
+	x := &struct {
+		Items []item
+		Index map[string]int
+		C     counter
+		Sum   func(*[]string) int
+	}{
+		Items: []item{{Name: "a", Tags: []string{"t"}}},
+		Index: map[string]int{"a": 0},
+		C:     counter{N: 2},
+		Sum:   func(s *[]string) int { *s = append(*s, "bad"); return len(*s) },
+	}
+	supermeta.NewReadOnly(l, x)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(x.Items[1].Name == "a")
+assert(x.Index.a == 0)
+assert(x.C:Get() == 2)
+assert(x.C.Add == nil)
+
+local n = 0
+for k, v in pairs(x.Items[1].Tags) do
+	n = n + 1
+end
+assert(n == 1)
+
+local function fails(f)
+	local ok, err = pcall(f)
+	assert(not ok)
+end
+fails(function() x.Items[1].Name = "b" end)
+fails(function() x.Items[2] = {Name = "b"} end)
+fails(function() x.Items[1].Tags[2] = "u" end)
+fails(function() x.Index.b = 1 end)
+fails(function() x.C = {N = 5} end)
+
+return x.Sum(x.Items[1].Tags)
+	`, 2)
+
+	testhelp.Assert(t, x.Items[0].Name == "a" && len(x.Items) == 1 && len(x.Items[0].Tags) == 1, "Value was modified")
+	testhelp.Assert(t, len(x.Index) == 1 && x.C.N == 2, "Value was modified")
+
+	// Stringers that would otherwise be proxies must stay read-only.
+	l.Push("a")
+	a := &acct{Bal: 5}
+	supermeta.NewReadOnly(l, a)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	l.Push("g")
+	g := &struct{ Owner acct }{Owner: acct{Bal: 1}}
+	supermeta.NewReadOnly(l, g)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(not pcall(function() a.Bal = 777 end))
+assert(not pcall(function() g.Owner.Bal = 999 end))
+return a.Bal + g.Owner.Bal
+	`, 6)
+
+	testhelp.Assert(t, a.Bal == 5 && g.Owner.Bal == 1, "Value was modified")
+}
+
+type acct struct {
+	Bal int
+}
+
+func (a acct) String() string {
+	return fmt.Sprintf("$%v", a.Bal)
+}
+
+type color int
+
+func (c color) String() string {
+	return [...]string{"red", "green", "blue"}[c]
+}
+
+type phasor complex128
+
+func (p phasor) String() string {
+	return fmt.Sprintf("%v@%v", real(p), imag(p))
+}
+
+type point struct {
+	X, Y int
+}
+
+func TestConverters(t *testing.T) {
+	l := testhelp.MkState()
+
+	supermeta.RegisterType(reflect.TypeOf(point{}), func(l *lua.State, src reflect.Value) {
+		p := src.Interface().(point)
+		l.Push(fmt.Sprintf("%v,%v", p.X, p.Y))
+	}, nil)
+
+	l.Push("x")
+	x := &struct {
+		T time.Time
+		D time.Duration
+		J json.RawMessage
+		B *big.Int
+		C color
+		M map[color]int
+		Z phasor
+		P point
+	}{
+		T: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		D: 1500 * time.Millisecond,
+		J: json.RawMessage(`{"a":1}`),
+		B: big.NewInt(42),
+		C: 2,
+		M: map[color]int{0: 1, 1: 2},
+		Z: phasor(complex(1, 2)),
+		P: point{1, 2},
+	}
+	supermeta.New(l, x)
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(x.T == "2020-01-02T03:04:05Z")
+assert(x.D == 1.5)
+assert(x.J == '{"a":1}')
+assert(x.B == "42")
+assert(x.C == 2) -- Numeric enums stay numbers, so they can be assigned back.
+assert(x.Z == "1@2")
+assert(x.P == "1,2")
+
+x.C = x.C
+for k, v in pairs(x.M) do
+	x.M[k] = v + 1
+end
+
+x.T = "2021-06-07T08:09:10Z"
+x.D = "2m"
+x.J = "[1, 2]"
+x.B = "123456789012345678901234567890"
+x.C = 0
+x.P = {X = 3}
+
+assert(not pcall(function() x.J = "{" end))
+	`, nil)
+
+	testhelp.Assert(t, x.T.Equal(time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)), "Time not set")
+	testhelp.Assert(t, x.D == 2*time.Minute, "Duration not set")
+	testhelp.Assert(t, string(x.J) == "[1, 2]", "RawMessage not set")
+	testhelp.Assert(t, x.B.String() == "123456789012345678901234567890", "big.Int not set")
+	testhelp.Assert(t, x.C == 0 && x.P.X == 3, "Value did not persist")
+	testhelp.Assert(t, x.M[0] == 2 && x.M[1] == 3 && len(x.M) == 2, "Map with enum keys not updated")
+}
+
+func TestChannels(t *testing.T) {
+	l := testhelp.MkState()
+
+	in := make(chan int, 3)
+	out := make(chan string, 3)
+	in <- 1
+	in <- 2
+	in <- 3
+	close(in)
+
+	l.Push("x")
+	supermeta.New(l, &struct {
+		In  <-chan int
+		Out chan string
+		ch  chan int
+	}{in, out, make(chan int, 1)})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(x.In:recv() == 1)
+assert(x.ch == nil)
+
+local sum = 0
+for v in x.In do
+	sum = sum + v
+end
+assert(sum == 5)
+
+local v, ok = x.In:tryrecv()
+assert(v == nil and not ok)
+assert(not pcall(x.In.send, x.In, 5))
+
+x.Out:send("a")
+x.Out:send(2)
+assert(#x.Out == 2)
+x.Out:close()
+assert(not pcall(x.Out.send, x.Out, "b"))
+	`, nil)
+
+	testhelp.Assert(t, <-out == "a" && <-out == "2", "Values not sent")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	l.SetContext(ctx)
+
+	l.Push("y")
+	supermeta.New(l, make(chan int))
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+local ok, err = pcall(y.recv, y)
+return ok
+	`, false)
+}
+
+func TestProxyMetamethods(t *testing.T) {
+	l := testhelp.MkState()
+
+	type pair struct {
+		Z int
+		A string
+		M int
+	}
+
+	l.Push("x")
+	supermeta.New(l, &struct {
+		P  pair
+		Q  pair
+		S  []int
+		M  map[string]int
+		PP []*pair
+	}{
+		P:  pair{1, "a", 2},
+		Q:  pair{1, "a", 2},
+		S:  []int{1, 2, 3},
+		M:  map[string]int{"a": 1, "b": 2},
+		PP: []*pair{{}, nil},
+	})
+	l.SetTableRaw(lua.GlobalsIndex)
+
+	testhelp.AssertBlock(t, l, `
+assert(#x.M == 2)
+assert(#x.P == 3)
+assert(#x.S == 3)
+
+assert(x.P == x.P)
+assert(x.S == x.S)
+assert(x.M == x.M)
+assert(x.P ~= x.Q)
+assert(x.P ~= 1)
+
+assert(getmetatable(x.P) == getmetatable(x.Q))
+
+assert(tostring(x.P):find("pair: 0x"))
+
+local order = ""
+for k, v in pairs(x.P) do
+	order = order..k..v
+end
+return order
+	`, "Z1AaM2")
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2016-2017 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package supermeta
+
+import "github.com/milochristiansen/lua"
+
+import "reflect"
+
+// BytesAsStrings controls how byte slices (and arrays) are converted. If true they are pushed as Lua strings
+// instead of proxies.
+//
+// Lua strings can always be assigned to byte and rune slices and arrays, no matter how this is set.
+var BytesAsStrings = false
+
+// RunesAsStrings is BytesAsStrings for rune slices (and arrays), which are encoded as UTF-8. It is separate because
+// rune is an alias of int32, so this also turns every []int32 into a string, mangling any that hold plain numbers.
+var RunesAsStrings = false
+
+// asString returns true if values of the given slice or array type should be pushed as a string.
+func asString(t reflect.Type) bool {
+	switch t.Elem().Kind() {
+	case reflect.Uint8:
+		return BytesAsStrings
+	case reflect.Int32:
+		return RunesAsStrings
+	}
+	return false
+}
+
+// isStringSlice returns true if the given slice or array type holds bytes or runes.
+func isStringSlice(t reflect.Type) bool {
+	switch t.Elem().Kind() {
+	case reflect.Uint8, reflect.Int32:
+		return true
+	}
+	return false
+}
+
+// sliceString returns the contents of a byte or rune slice or array as a string.
+func sliceString(v reflect.Value) string {
+	ln := v.Len()
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, ln)
+		for i := 0; i < ln; i++ {
+			b[i] = byte(v.Index(i).Uint())
+		}
+		return string(b)
+	}
+
+	r := make([]rune, ln)
+	for i := 0; i < ln; i++ {
+		r[i] = rune(v.Index(i).Int())
+	}
+	return string(r)
+}
+
+// setSliceString stores a string in a byte or rune slice or array. Slices are resized to fit, arrays are
+// truncated or padded with zeros.
+func setSliceString(dest reflect.Value, s string) {
+	var src reflect.Value
+	if dest.Type().Elem().Kind() == reflect.Uint8 {
+		src = reflect.ValueOf([]byte(s))
+	} else {
+		src = reflect.ValueOf([]rune(s))
+	}
+
+	ln := src.Len()
+	if dest.Kind() == reflect.Slice {
+		dest.Set(reflect.MakeSlice(dest.Type(), ln, ln))
+	}
+
+	et := dest.Type().Elem()
+	for i := 0; i < dest.Len(); i++ {
+		if i >= ln {
+			dest.Index(i).Set(reflect.Zero(et))
+			continue
+		}
+		dest.Index(i).Set(src.Index(i).Convert(et))
+	}
+}
+
+// stringMethod pushes the "string" or "bytes" method for a byte or rune slice proxy. "string" returns the contents
+// as a Lua string, "bytes" returns a table holding the bytes of that string.
+func stringMethod(l *lua.State, name string) bool {
+	switch name {
+	case "string":
+		l.Push(func(l *lua.State) int {
+			o, _ := proxyValue(l.ToUser(1))
+			l.Push(sliceString(o))
+			return 1
+		})
+	case "bytes":
+		l.Push(func(l *lua.State) int {
+			o, _ := proxyValue(l.ToUser(1))
+			s := sliceString(o)
+			l.NewTable(len(s), 0)
+			for i := 0; i < len(s); i++ {
+				l.Push(int64(i + 1))
+				l.Push(int64(s[i]))
+				l.SetTableRaw(-3)
+			}
+			return 1
+		})
+	default:
+		return false
+	}
+	return true
+}
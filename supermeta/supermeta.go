@@ -30,17 +30,49 @@ The following kinds are not supported:
 
 	Complex64
 	Complex128
-	Chan
-	Func
 	UnsafePointer
 
 These are not implemented because they have no corresponding Lua types, some of these may be
 supported later...
 
+Conversion for specific types (or interfaces) can be overridden with RegisterType. By default time.Time,
+time.Duration, json.RawMessage, encoding.TextMarshaler, and fmt.Stringer have converters, see RegisterType for
+details.
+
+Functions become Lua functions. Arguments are converted to the required types (missing arguments and nils are
+passed as zero values, proxies are passed as the Go value they wrap) and results are converted back. Variadic
+functions work as expected. If the last result is an error it is raised as a Lua error instead of returned.
+
+Struct fields are looked up by name, but this can be changed with a "lua" struct tag. `lua:"name"` exposes the
+field as "name", `lua:"-"` hides it, and `lua:",readonly"` (or `lua:"name,readonly"`) lets scripts read but not
+set it. Unexported fields can be read but never set. Fields of embedded structs are promoted just like in Go (except
+that, as with lua.Marshal and encoding/json, a tagged field wins over untagged ones at the same depth), and the
+embedded struct itself is available by its type name. Setting a field that does not exist is an error.
+
+The exported methods of a struct (including methods with pointer receivers, if the struct is addressable) can be
+read just like fields. Call them with either "obj:Method()" or "obj.Method()".
+
+Byte and rune slices (and arrays) are normally proxies like any other slice, but they have two extra methods:
+"string" returns the contents as a Lua string and "bytes" returns a table of the bytes in that string. If
+BytesAsStrings (for bytes) or RunesAsStrings (for runes) is set they are pushed as plain Lua strings instead. Since
+rune is an alias of int32 RunesAsStrings applies to every []int32, so only set it if none of those hold plain numbers.
+Either way Lua strings may be assigned to them.
+
+Channels are userdata with "send", "recv", "tryrecv", and "close" methods. "recv" blocks until a value is
+available and returns the value and true, or nil and false if the channel is closed. "tryrecv" is the same, but
+returns nil and false right away if no value is ready. Calling a channel is the same as "recv" (minus the second
+result), so "for v in ch do ... end" loops until the channel is closed. Blocking operations raise an error if the
+State's context (see State.SetContext) is done.
+
 Slices are special in that they allow you to write to the key one passed the end of the slice.
 This allows you to append now data to an existing slice. If you want anything more complicated
 than that you should write a custom metatable.
 
+All proxies support "#" (the length of slices, arrays, maps, and channels, or the number of fields in a struct),
+"==" (true if both proxies refer to the same Go value), and tostring (the type and address). Slices, arrays, maps,
+and structs also support pairs, struct fields are iterated in declaration order. Metatables are built once per type
+and cached in the registry.
+
 You should be able to assign a table to a value containing a "complex" type (slice, array, map,
 struct). This does not create a new object (unless the existing object is a nil pointer), instead
 the data from the table is used to fill as many keys in the object as possible. Slices will be
@@ -62,11 +94,12 @@ mix of good and bad, I simply give up at the first issue.
 
 When working with untrusted scripts be very careful what you expose with this API! Some actions
 have the potential to trash the exposed data! Always use a set of dedicated metatables where
-possible!
+possible! If scripts only need to look at the data use NewReadOnly, which refuses all direct changes.
 */
 package supermeta
 
 import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/internal/fields"
 
 import "reflect"
 import "errors"
@@ -90,14 +123,26 @@ import "errors"
 // but this may not work for some types.
 func New(l *lua.State, obj interface{}) {
 	rval := reflect.ValueOf(obj)
-	to(rval.Kind())(l, rval)
+	pushValue(l, rval, false)
+}
+
+// NewReadOnly is like New, but scripts may only read and iterate the pushed value and anything reachable from it.
+// Any attempt to set a field, key, or index (including appending to a slice or assigning a table to a nested
+// value) raises an error. Methods with pointer receivers are not available.
+//
+// This is the mode to use when handing data to untrusted scripts, but note that it only covers what scripts do
+// directly. Func fields and value receiver methods can still be called, and they run with whatever access the Go
+// code has: a value receiver method on a map or slice type (or a struct holding one) can change the shared data,
+// and so can any func field. Only expose values whose methods and funcs are safe to call.
+func NewReadOnly(l *lua.State, obj interface{}) {
+	pushValue(l, reflect.ValueOf(obj), true)
 }
 
 // RValueToLValue pushes the given reflect.Value onto the stack.
 //
 // This is basically the same as New, but for use when you have an existing reflect.Value.
 func RValueToLValue(l *lua.State, src reflect.Value) {
-	to(src.Kind())(l, src)
+	pushValue(l, src, false)
 }
 
 // LValueToRValue stores a given Lua value in the given reflect.Value.
@@ -109,13 +154,15 @@ func RValueToLValue(l *lua.State, src reflect.Value) {
 //
 // Most of the time you should use the normal API to get values out of the VM!
 func LValueToRValue(l *lua.State, dest reflect.Value, src int) error {
-	return from(dest.Kind())(l, dest, src)
+	return store(l, dest, src)
 }
 
 // Possible errors.
 var ErrCantSet = errors.New("Cannot set given value.")
 var ErrCantConv = errors.New("Conversion to given type not implemented.")
 var ErrBadConv = errors.New("Conversion to required type not possible for this value.")
+var ErrReadOnly = errors.New("Value is read-only.")
+var ErrNoField = errors.New("No field with this name.")
 
 // The "to" functions push a Lua value for the given reflect.Value, the "from" functions grab a Lua
 // value and store it in the given reflect.Value if possible (returning an error if not possible).
@@ -204,7 +251,11 @@ func fromBool(l *lua.State, dest reflect.Value, src int) error {
 
 // []type
 func toSlice(l *lua.State, src reflect.Value) {
-	sliceTable(l, src)
+	if asString(src.Type()) {
+		l.Push(sliceString(src))
+		return
+	}
+	sliceTable(l, src, false)
 }
 func fromSlice(l *lua.State, dest reflect.Value, src int) (err error) {
 	defer l.Recover(0, false)(&err) // So stuff in here can raise errors!
@@ -228,6 +279,11 @@ func fromSlice(l *lua.State, dest reflect.Value, src int) (err error) {
 		}
 	}
 
+	if l.TypeOf(src) == lua.TypString && isStringSlice(dest.Type()) {
+		setSliceString(dest, l.ToString(src))
+		return nil
+	}
+
 	i := l.Length(src)
 
 	// Based on code from "encoding/json"
@@ -257,7 +313,7 @@ func fromSlice(l *lua.State, dest reflect.Value, src int) (err error) {
 
 		l.Push(j)
 		l.GetTable(src)
-		err := from(d.Kind())(l, d, -1)
+		err := store(l, d, -1)
 		l.Pop(1)
 		if err != nil {
 			return err
@@ -268,7 +324,7 @@ func fromSlice(l *lua.State, dest reflect.Value, src int) (err error) {
 
 // map[type]type
 func toMap(l *lua.State, src reflect.Value) {
-	mapTable(l, src)
+	mapTable(l, src, false)
 }
 func fromMap(l *lua.State, dest reflect.Value, src int) (err error) {
 	defer l.Recover(0, false)(&err) // So stuff in here can raise errors!
@@ -294,18 +350,16 @@ func fromMap(l *lua.State, dest reflect.Value, src int) (err error) {
 
 	dt := dest.Type()
 	kt := dt.Key()
-	kc := from(kt.Kind())
 	vt := dt.Elem()
-	vc := from(vt.Kind())
 	l.ForEach(src, func() bool {
 		k := reflect.New(kt).Elem()
-		err = kc(l, k, -2)
+		err = store(l, k, -2)
 		if err != nil {
 			return false
 		}
 
 		v := reflect.New(vt).Elem()
-		err = vc(l, v, -1)
+		err = store(l, v, -1)
 		if err != nil {
 			return false
 		}
@@ -317,7 +371,7 @@ func fromMap(l *lua.State, dest reflect.Value, src int) (err error) {
 
 // struct
 func toStruct(l *lua.State, src reflect.Value) {
-	structTable(l, src)
+	structTable(l, src, false)
 }
 func fromStruct(l *lua.State, dest reflect.Value, src int) (err error) {
 	defer l.Recover(0, false)(&err) // So stuff in here can raise errors!
@@ -342,19 +396,23 @@ func fromStruct(l *lua.State, dest reflect.Value, src int) (err error) {
 	}
 
 	// Iterate all structure fields looking for matching fields in the table.
-	ln := dest.NumField()
-	dt := dest.Type()
-	for i := 0; i < ln; i++ {
-		fi := dt.Field(i)
-		l.Push(fi.Name)
+	for _, f := range fields.Of(dest.Type(), false).List {
+		l.Push(f.Name)
 		l.GetTable(src)
 
 		if l.IsNil(-1) {
 			l.Pop(1)
 			continue
 		}
+		if f.ReadOnly {
+			return ErrReadOnly
+		}
 
-		err := from(fi.Type.Kind())(l, dest.Field(i), -1)
+		fv := fields.Value(dest, f, true)
+		if !fv.IsValid() {
+			return ErrCantSet
+		}
+		err := store(l, fv, -1)
 		l.Pop(1)
 		if err != nil {
 			return err
@@ -366,7 +424,7 @@ func fromStruct(l *lua.State, dest reflect.Value, src int) (err error) {
 // *type
 func toPtr(l *lua.State, src reflect.Value) {
 	src = src.Elem()
-	to(src.Kind())(l, src)
+	pushValue(l, src, false)
 }
 func fromPtr(l *lua.State, dest reflect.Value, src int) error {
 	dest = dest.Elem()
@@ -394,7 +452,7 @@ func fromPtr(l *lua.State, dest reflect.Value, src int) error {
 		}
 	}
 
-	return from(dest.Kind())(l, dest, src)
+	return store(l, dest, src)
 }
 
 // Default case
@@ -425,6 +483,10 @@ func to(k reflect.Kind) func(*lua.State, reflect.Value) {
 		return toStruct
 	case reflect.Ptr, reflect.Interface:
 		return toPtr
+	case reflect.Func:
+		return toFunc
+	case reflect.Chan:
+		return toChan
 	}
 	return toErr
 }
@@ -449,6 +511,10 @@ func from(k reflect.Kind) func(*lua.State, reflect.Value, int) error {
 		return fromStruct
 	case reflect.Ptr, reflect.Interface:
 		return fromPtr
+	case reflect.Func:
+		return fromFunc
+	case reflect.Chan:
+		return fromChan
 	}
 	return fromErr
 }
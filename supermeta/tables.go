@@ -23,19 +23,28 @@ misrepresented as being the original software.
 package supermeta
 
 import "github.com/milochristiansen/lua"
+import "github.com/milochristiansen/lua/internal/fields"
 
 import "reflect"
 
-func sliceTable(l *lua.State, obj reflect.Value) {
-	l.Push(obj)
+func sliceTable(l *lua.State, obj reflect.Value, ro bool) {
+	pushProxy(l, obj, ro)
+	if cachedMeta(l, obj.Type(), ro) {
+		l.SetMetaTable(-2)
+		return
+	}
 	l.NewTable(0, 4)
 
 	vt := obj.Type().Elem()
-	vk := vt.Kind()
+	str := isStringSlice(obj.Type())
 
 	l.Push("__index")
 	l.Push(func(l *lua.State) int {
-		o := l.ToUser(1).(reflect.Value)
+		o, _ := proxyValue(l.ToUser(1))
+
+		if str && l.TypeOf(2) == lua.TypString && stringMethod(l, l.ToString(2)) {
+			return 1
+		}
 
 		k, ok := l.TryInt(2)
 		if !ok {
@@ -47,14 +56,18 @@ func sliceTable(l *lua.State, obj reflect.Value) {
 			return 0
 		}
 
-		to(vk)(l, o.Index(int(k)))
+		pushValue(l, o.Index(int(k)), ro)
 		return 1
 	})
 	l.SetTableRaw(-3)
 
 	l.Push("__newindex")
 	l.Push(func(l *lua.State) int {
-		o := l.ToUser(1).(reflect.Value)
+		if ro {
+			l.Push(ErrReadOnly.Error())
+			l.Error()
+		}
+		o, _ := proxyValue(l.ToUser(1))
 
 		k, ok := l.TryInt(2)
 		if !ok {
@@ -70,10 +83,14 @@ func sliceTable(l *lua.State, obj reflect.Value) {
 			if o.Kind() != reflect.Slice {
 				return 0
 			}
+			if !o.CanSet() {
+				l.Push(ErrCantSet.Error())
+				l.Error()
+			}
 			o.Set(reflect.Append(o, reflect.Zero(vt)))
 		}
 
-		err := from(vk)(l, o.Index(int(k)), 3)
+		err := store(l, o.Index(int(k)), 3)
 		if err != nil {
 			l.Push(err.Error())
 			l.Error()
@@ -84,7 +101,7 @@ func sliceTable(l *lua.State, obj reflect.Value) {
 
 	l.Push("__len")
 	l.Push(func(l *lua.State) int {
-		o := l.ToUser(1).(reflect.Value)
+		o, _ := proxyValue(l.ToUser(1))
 
 		l.Push(o.Len())
 		return 1
@@ -94,13 +111,13 @@ func sliceTable(l *lua.State, obj reflect.Value) {
 	l.Push("__pairs")
 	l.Push(func(l *lua.State) int {
 		l.Push(func(l *lua.State) int {
-			o := l.ToUser(1).(reflect.Value)
+			o, _ := proxyValue(l.ToUser(1))
 
 			i := int(l.ToInt(2))
 			i++
 			if i <= o.Len() {
 				l.Push(int64(i))
-				to(vk)(l, o.Index(i-1))
+				pushValue(l, o.Index(i-1), ro)
 				return 2
 			}
 			l.Push(nil)
@@ -112,6 +129,7 @@ func sliceTable(l *lua.State, obj reflect.Value) {
 	})
 	l.SetTableRaw(-3)
 
+	cacheMeta(l, obj.Type(), ro)
 	l.SetMetaTable(-2)
 }
 
@@ -121,45 +139,51 @@ type mapIter struct {
 	self reflect.Value
 }
 
-func mapTable(l *lua.State, obj reflect.Value) {
-	l.Push(obj)
+func mapTable(l *lua.State, obj reflect.Value, ro bool) {
+	pushProxy(l, obj, ro)
+	if cachedMeta(l, obj.Type(), ro) {
+		l.SetMetaTable(-2)
+		return
+	}
 	l.NewTable(0, 3)
 
-	kk := obj.Type().Key().Kind()
 	kt := obj.Type().Key()
-	vk := obj.Type().Elem().Kind()
 	vt := obj.Type().Elem()
 
 	l.Push("__index")
 	l.Push(func(l *lua.State) int {
-		o := l.ToUser(1).(reflect.Value)
+		o, _ := proxyValue(l.ToUser(1))
 
 		k := reflect.New(kt).Elem()
-		err := from(kk)(l, k, 2)
+		err := store(l, k, 2)
 		if err != nil {
 			l.Push(err.Error())
 			l.Error()
 		}
 		// k is now a valid key for o
 
-		to(vk)(l, o.MapIndex(k))
+		pushValue(l, o.MapIndex(k), ro)
 		return 1
 	})
 	l.SetTableRaw(-3)
 
 	l.Push("__newindex")
 	l.Push(func(l *lua.State) int {
-		o := l.ToUser(1).(reflect.Value)
+		if ro {
+			l.Push(ErrReadOnly.Error())
+			l.Error()
+		}
+		o, _ := proxyValue(l.ToUser(1))
 
 		k := reflect.New(kt).Elem()
-		err := from(kk)(l, k, 2)
+		err := store(l, k, 2)
 		if err != nil {
 			l.Push(err.Error())
 			l.Error()
 		}
 
 		v := reflect.New(vt).Elem()
-		err = from(vk)(l, v, 3)
+		err = store(l, v, 3)
 		if err != nil {
 			l.Push(err.Error())
 			l.Error()
@@ -170,6 +194,15 @@ func mapTable(l *lua.State, obj reflect.Value) {
 	})
 	l.SetTableRaw(-3)
 
+	l.Push("__len")
+	l.Push(func(l *lua.State) int {
+		o, _ := proxyValue(l.ToUser(1))
+
+		l.Push(o.Len())
+		return 1
+	})
+	l.SetTableRaw(-3)
+
 	l.Push("__pairs")
 	l.Push(func(l *lua.State) int {
 		l.Push(func(l *lua.State) int {
@@ -177,58 +210,89 @@ func mapTable(l *lua.State, obj reflect.Value) {
 
 			iter.i++
 			if iter.i < len(iter.keys) {
-				to(kk)(l, iter.keys[iter.i])
-				to(vk)(l, iter.self.MapIndex(iter.keys[iter.i]))
+				pushValue(l, iter.keys[iter.i], ro)
+				pushValue(l, iter.self.MapIndex(iter.keys[iter.i]), ro)
 				return 2
 			}
 			l.Push(nil)
 			return 1
 		})
+		o, _ := proxyValue(l.ToUser(1))
+
 		l.Push(&mapIter{
 			i:    -1,
-			keys: obj.MapKeys(),
-			self: obj,
+			keys: o.MapKeys(),
+			self: o,
 		})
 		l.Push(nil)
 		return 3
 	})
 	l.SetTableRaw(-3)
 
+	cacheMeta(l, obj.Type(), ro)
 	l.SetMetaTable(-2)
 }
 
-func structTable(l *lua.State, obj reflect.Value) {
-	l.Push(obj)
+func structTable(l *lua.State, obj reflect.Value, ro bool) {
+	pushProxy(l, obj, ro)
+	if cachedMeta(l, obj.Type(), ro) {
+		l.SetMetaTable(-2)
+		return
+	}
 	l.NewTable(0, 2)
 
+	si := fields.Of(obj.Type(), false)
+
 	l.Push("__index")
 	l.Push(func(l *lua.State) int {
-		o := l.ToUser(1).(reflect.Value)
+		o, _ := proxyValue(l.ToUser(1))
 
 		k := l.ToString(2)
 
-		f, ok := o.Type().FieldByName(k)
-		if !ok {
+		f := si.ByName[k]
+		if f == nil {
+			if method(l, o, 1, k, ro) {
+				return 1
+			}
 			return 0
 		}
 
-		to(f.Type.Kind())(l, o.FieldByName(k))
+		fv := fields.Value(o, f, false)
+		if !fv.IsValid() {
+			return 0
+		}
+		pushValue(l, fv, ro)
 		return 1
 	})
 	l.SetTableRaw(-3)
 
 	l.Push("__newindex")
 	l.Push(func(l *lua.State) int {
-		o := l.ToUser(1).(reflect.Value)
+		if ro {
+			l.Push(ErrReadOnly.Error())
+			l.Error()
+		}
+		o, _ := proxyValue(l.ToUser(1))
 
 		k := l.ToString(2)
 
-		fv := o.FieldByName(k)
+		f := si.ByName[k]
+		if f == nil {
+			l.Push(k + ": " + ErrNoField.Error())
+			l.Error()
+		}
+		if f.ReadOnly {
+			l.Push(k + ": " + ErrReadOnly.Error())
+			l.Error()
+		}
+
+		fv := fields.Value(o, f, true)
 		if !fv.IsValid() || !fv.CanSet() {
-			return 0
+			l.Push(k + ": " + ErrCantSet.Error())
+			l.Error()
 		}
 
-		err := from(fv.Type().Kind())(l, fv, 3)
+		err := store(l, fv, 3)
 		if err != nil {
 			l.Push(err.Error())
 			l.Error()
@@ -237,5 +301,48 @@ func structTable(l *lua.State, obj reflect.Value) {
 	})
 	l.SetTableRaw(-3)
 
+	l.Push("__len")
+	l.Push(func(l *lua.State) int {
+		l.Push(len(si.List))
+		return 1
+	})
+	l.SetTableRaw(-3)
+
+	l.Push("__pairs")
+	l.Push(func(l *lua.State) int {
+		l.Push(func(l *lua.State) int {
+			o, _ := proxyValue(l.ToUser(1))
+
+			i := 0
+			if !l.IsNil(2) {
+				prev := si.ByName[l.ToString(2)]
+				if prev == nil {
+					l.Push(nil)
+					return 1
+				}
+				i = prev.Pos + 1
+			}
+			if i >= len(si.List) {
+				l.Push(nil)
+				return 1
+			}
+
+			f := si.List[i]
+			l.Push(f.Name)
+			fv := fields.Value(o, f, false)
+			if !fv.IsValid() {
+				l.Push(nil)
+				return 2
+			}
+			pushValue(l, fv, ro)
+			return 2
+		})
+		l.PushIndex(1)
+		l.Push(nil)
+		return 3
+	})
+	l.SetTableRaw(-3)
+
+	cacheMeta(l, obj.Type(), ro)
 	l.SetMetaTable(-2)
 }
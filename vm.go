@@ -71,13 +71,22 @@ func (l *State) call(fi, args, rtns int, tail bool) {
 func (l *State) exec() {
 	if l.stack.cFrame().fn.native != nil {
 		fr := l.stack.cFrame()
+		if l.profiler != nil {
+			l.profiler.enter(l)
+		}
 		fr.retC = fr.fn.native(l)
+		if l.profiler != nil {
+			l.profiler.leave(fr)
+		}
 		fr.retBase = l.stack.TopIndex() + 1 - fr.retC
 	} else {
 		i, ok := l.stack.cFrame().nxtOp()
 		for ok {
 			//l.Printf("[%v]\t%v\n", l.stack.cFrame().pc-1, i)
-			_ = "breakpoint"                           // Next Instruction
+			_ = "breakpoint" // Next Instruction
+			if l.hooked {
+				l.hook(l.stack.cFrame())
+			}
 			if instructionTable[i.getOpCode()](l, i) { // RETURN and TAILCALL return true
 				return
 			}